@@ -637,8 +637,9 @@ type ImageDatastores struct {
 
 // Image creation spec.
 type ImageCreateSpec struct {
-	Name            string `json:"name"`
-	ReplicationType string `json:"replicationType"`
+	Name            string   `json:"name"`
+	ReplicationType string   `json:"replicationType"`
+	Tags            []string `json:"tags,omitempty"`
 }
 
 // Represents deployment info
@@ -0,0 +1,315 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ways of grouping the entries of an audit report.
+const (
+	AuditGroupBySubject  string = "subject"
+	AuditGroupByResource string = "resource"
+)
+
+const defaultAuditConcurrency int = 4
+
+// Options for GenerateAuditReport.
+type AuditOptions struct {
+	// Maximum number of API requests in flight, 4 if not set.
+	Concurrency int
+}
+
+// One role held by one principal on one entity of the deployment.
+type AuditEntry struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+
+	// ScopeSystem or one of the EntityKind constants.
+	ResourceKind string `json:"resourceKind"`
+	ResourceID   string `json:"resourceId,omitempty"`
+	ResourceName string `json:"resourceName,omitempty"`
+	TenantID     string `json:"tenantId,omitempty"`
+	ProjectID    string `json:"projectId,omitempty"`
+
+	// GrantSourceIam or GrantSourceSecurityGroup.
+	Source    string `json:"source"`
+	Inherited bool   `json:"inherited,omitempty"`
+}
+
+// Returns the key the entry is grouped under when grouping by resource.
+func (entry *AuditEntry) ResourceKey() string {
+	if entry.ResourceID == "" {
+		return entry.ResourceKind
+	}
+	return resourceKey(entry.ResourceKind, entry.ResourceID)
+}
+
+// Returns the key the entry is grouped under when grouping by subject. Names
+// of the same principal written differently are grouped together.
+func (entry *AuditEntry) SubjectKey() string {
+	return normalizePrincipal(entry.Subject)
+}
+
+// Every principal with a role anywhere in the deployment.
+type AuditReport struct {
+	GeneratedAt time.Time    `json:"generatedAt"`
+	Entries     []AuditEntry `json:"entries"`
+}
+
+// Returns the entries grouped by subject or by resource.
+func (report *AuditReport) GroupBy(groupBy string) map[string][]AuditEntry {
+	groups := map[string][]AuditEntry{}
+	for _, entry := range report.Entries {
+		key := entry.ResourceKey()
+		if groupBy == AuditGroupBySubject {
+			key = entry.SubjectKey()
+		}
+		groups[key] = append(groups[key], entry)
+	}
+	return groups
+}
+
+// Writes the entries grouped by subject or by resource as a JSON object.
+func (report *AuditReport) WriteJSON(w io.Writer, groupBy string) error {
+	return json.NewEncoder(w).Encode(&struct {
+		GeneratedAt time.Time               `json:"generatedAt"`
+		GroupBy     string                  `json:"groupBy"`
+		Groups      map[string][]AuditEntry `json:"groups"`
+	}{report.GeneratedAt, groupBy, report.GroupBy(groupBy)})
+}
+
+var auditCSVHeader = []string{
+	"group", "subject", "role", "resource_kind", "resource_id", "resource_name",
+	"tenant_id", "project_id", "source", "inherited",
+}
+
+// Writes the entries as CSV with a header row, sorted by subject or by resource.
+// The first column holds the key the entry is grouped under.
+func (report *AuditReport) WriteCSV(w io.Writer, groupBy string) error {
+	groups := report.GroupBy(groupBy)
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writer := csv.NewWriter(w)
+	err := writer.Write(auditCSVHeader)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		for _, entry := range groups[key] {
+			err = writer.Write([]string{
+				key, entry.Subject, entry.Role, entry.ResourceKind, entry.ResourceID, entry.ResourceName,
+				entry.TenantID, entry.ProjectID, entry.Source, strconv.FormatBool(entry.Inherited),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Walks all tenants, projects, VMs, disks and images of the deployment and
+// reports the roles granted by their IAM policies and security groups, as well
+// as the system security groups. Returns the first error hit.
+func GenerateAuditReport(client *Client, options *AuditOptions) (report *AuditReport, err error) {
+	concurrency := defaultAuditConcurrency
+	if options != nil && options.Concurrency > 0 {
+		concurrency = options.Concurrency
+	}
+
+	walker := &auditWalker{jobRunner: newJobRunner(concurrency), client: client}
+	walker.spawn(walker.walkSystem)
+	walker.spawn(walker.walkTenants)
+	walker.spawn(walker.walkImages)
+	err = walker.wait()
+	if err != nil {
+		return
+	}
+
+	sort.Sort(auditEntries(walker.entries))
+	return &AuditReport{GeneratedAt: time.Now().UTC(), Entries: walker.entries}, nil
+}
+
+// Walks the deployment, collecting entries.
+type auditWalker struct {
+	*jobRunner
+	client *Client
+
+	lock    sync.Mutex
+	entries []AuditEntry
+}
+
+func (w *auditWalker) add(entries ...AuditEntry) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.entries = append(w.entries, entries...)
+}
+
+func (w *auditWalker) walkSystem() error {
+	authInfo, err := w.client.System.GetAuthInfo()
+	if err != nil {
+		return err
+	}
+	for _, group := range authInfo.SecurityGroups {
+		w.add(AuditEntry{
+			Subject:      group,
+			Role:         SystemAdminRole,
+			ResourceKind: ScopeSystem,
+			Source:       GrantSourceSecurityGroup,
+		})
+	}
+	return nil
+}
+
+func (w *auditWalker) walkTenants() error {
+	tenants, err := w.client.Tenants.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants.Items {
+		tenant := tenant
+		resource := AuditEntry{ResourceKind: EntityKindTenant, ResourceID: tenant.ID, ResourceName: tenant.Name, TenantID: tenant.ID}
+		w.addSecurityGroups(resource, tenant.SecurityGroups, TenantAdminRole)
+		w.spawnIam(resource)
+		w.spawn(func() error { return w.walkProjects(&tenant) })
+	}
+	return nil
+}
+
+func (w *auditWalker) walkProjects(tenant *Tenant) error {
+	projects, err := w.client.Tenants.GetProjects(tenant.ID, nil)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects.Items {
+		projectID := project.ID
+		resource := AuditEntry{
+			ResourceKind: EntityKindProject,
+			ResourceID:   project.ID,
+			ResourceName: project.Name,
+			TenantID:     tenant.ID,
+			ProjectID:    project.ID,
+		}
+		w.addSecurityGroups(resource, project.SecurityGroups, ProjectUserRole)
+		w.spawnIam(resource)
+		w.spawn(func() error { return w.walkVMs(tenant.ID, projectID) })
+		w.spawn(func() error { return w.walkDisks(tenant.ID, projectID) })
+	}
+	return nil
+}
+
+func (w *auditWalker) walkVMs(tenantID string, projectID string) error {
+	vms, err := w.client.Projects.GetVMs(projectID, nil)
+	if err != nil {
+		return err
+	}
+	for _, vm := range vms.Items {
+		w.spawnIam(AuditEntry{
+			ResourceKind: EntityKindVm,
+			ResourceID:   vm.ID,
+			ResourceName: vm.Name,
+			TenantID:     tenantID,
+			ProjectID:    projectID,
+		})
+	}
+	return nil
+}
+
+func (w *auditWalker) walkDisks(tenantID string, projectID string) error {
+	disks, err := w.client.Projects.GetDisks(projectID, nil)
+	if err != nil {
+		return err
+	}
+	for _, disk := range disks.Items {
+		w.spawnIam(AuditEntry{
+			ResourceKind: EntityKindDisk,
+			ResourceID:   disk.ID,
+			ResourceName: disk.Name,
+			TenantID:     tenantID,
+			ProjectID:    projectID,
+		})
+	}
+	return nil
+}
+
+func (w *auditWalker) walkImages() error {
+	images, err := w.client.Images.GetAll(nil)
+	if err != nil {
+		return err
+	}
+	for _, image := range images.Items {
+		w.spawnIam(AuditEntry{ResourceKind: EntityKindImage, ResourceID: image.ID, ResourceName: image.Name})
+	}
+	return nil
+}
+
+// Adds an entry per security group, copying the resource fields of the given entry.
+func (w *auditWalker) addSecurityGroups(resource AuditEntry, groups []SecurityGroup, role string) {
+	for _, group := range groups {
+		entry := resource
+		entry.Subject = group.Name
+		entry.Role = role
+		entry.Source = GrantSourceSecurityGroup
+		entry.Inherited = group.Inherited
+		w.add(entry)
+	}
+}
+
+// Fetches the IAM policy of the resource described by the given entry and adds
+// an entry per role binding subject.
+func (w *auditWalker) spawnIam(resource AuditEntry) {
+	w.spawn(func() error {
+		policy, err := w.client.Iam.Get(Entity{ID: resource.ResourceID, Kind: resource.ResourceKind})
+		if err != nil {
+			return err
+		}
+		for _, binding := range policy {
+			for _, subject := range binding.Subjects {
+				entry := resource
+				entry.Subject = subject
+				entry.Role = binding.Role
+				entry.Source = GrantSourceIam
+				w.add(entry)
+			}
+		}
+		return nil
+	})
+}
+
+// Sorts entries by resource, then subject and role.
+type auditEntries []AuditEntry
+
+func (entries auditEntries) Len() int      { return len(entries) }
+func (entries auditEntries) Swap(i, j int) { entries[i], entries[j] = entries[j], entries[i] }
+func (entries auditEntries) Less(i, j int) bool {
+	a, b := entries[i], entries[j]
+	if a.ResourceKey() != b.ResourceKey() {
+		return a.ResourceKey() < b.ResourceKey()
+	}
+	if a.SubjectKey() != b.SubjectKey() {
+		return a.SubjectKey() < b.SubjectKey()
+	}
+	if a.Role != b.Role {
+		return a.Role < b.Role
+	}
+	return a.Source < b.Source
+}
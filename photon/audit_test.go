@@ -0,0 +1,178 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+// Counts the requests in flight at once, holding each a little.
+type inFlightTransport struct {
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (t *inFlightTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	t.inFlight++
+	if t.inFlight > t.maxInFlight {
+		t.maxInFlight = t.inFlight
+	}
+	t.lock.Unlock()
+	defer func() {
+		t.lock.Lock()
+		t.inFlight--
+		t.lock.Unlock()
+	}()
+	time.Sleep(5 * time.Millisecond)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+var _ = Describe("Audit", func() {
+	var (
+		server    *mocks.Server
+		client    *Client
+		transport *inFlightTransport
+	)
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping audit test on integration mode.")
+		}
+		server = mocks.NewTestServer()
+		transport = &inFlightTransport{}
+		client = NewTestClient(server.HttpServer.URL, nil, &http.Client{Transport: transport})
+
+		server.SetResponseJsonForPath(rootUrl+"/system/auth", 200,
+			&AuthInfo{SecurityGroups: []string{"photon.local\\Administrators"}})
+		server.SetResponseJsonForPath(rootUrl+"/tenants", 200, &Tenants{Items: []Tenant{{
+			ID:             "t1",
+			Name:           "tenant1",
+			SecurityGroups: []SecurityGroup{{Name: "photon.local\\TenantAdmins"}},
+		}}})
+		server.SetResponseJsonForPath(rootUrl+"/tenants/t1/iam", 200,
+			[]*RoleBinding{{Role: "viewer", Subjects: []string{"joe@photon.local"}}})
+		server.SetResponseJsonForPath(rootUrl+"/tenants/t1/projects", 200, &ProjectList{Items: []ProjectCompact{{
+			ID:             "p1",
+			Name:           "project1",
+			SecurityGroups: []SecurityGroup{{Name: "photon.local\\TenantAdmins", Inherited: true}},
+		}}})
+		server.SetResponseJsonForPath(rootUrl+"/projects/p1/iam", 200, []*RoleBinding{})
+		server.SetResponseJsonForPath(rootUrl+"/projects/p1/vms", 200,
+			&VMs{Items: []VM{{ID: "vm1", Name: "web"}, {ID: "vm2", Name: "db"}}})
+		server.SetResponseJsonForPath(rootUrl+"/vms/vm1/iam", 200,
+			[]*RoleBinding{{Role: "owner", Subjects: []string{"joe@photon.local"}}})
+		server.SetResponseJsonForPath(rootUrl+"/vms/vm2/iam", 200,
+			[]*RoleBinding{{Role: "owner", Subjects: []string{"ann@photon.local", "joe@photon.local"}}})
+		server.SetResponseJsonForPath(rootUrl+"/projects/p1/disks", 200,
+			&DiskList{Items: []PersistentDisk{{ID: "disk1", Name: "data"}}})
+		server.SetResponseJsonForPath(rootUrl+"/disks/disk1/iam", 200, []*RoleBinding{})
+		server.SetResponseJsonForPath(rootUrl+"/images", 200, &Images{Items: []Image{{ID: "img1", Name: "ubuntu"}}})
+		server.SetResponseJsonForPath(rootUrl+"/images/img1/iam", 200,
+			[]*RoleBinding{{Role: "viewer", Subjects: []string{"photon.local\\Developers"}}})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("reports every principal", func() {
+		report, err := GenerateAuditReport(client, &AuditOptions{Concurrency: 2})
+		Expect(err).Should(BeNil())
+		Expect(transport.maxInFlight).Should(BeNumerically("<=", 2))
+
+		Expect(report.Entries).Should(Equal([]AuditEntry{
+			{"photon.local\\Developers", "viewer", EntityKindImage, "img1", "ubuntu", "", "", GrantSourceIam, false},
+			{"photon.local\\TenantAdmins", ProjectUserRole, EntityKindProject, "p1", "project1", "t1", "p1", GrantSourceSecurityGroup, true},
+			{"photon.local\\Administrators", SystemAdminRole, ScopeSystem, "", "", "", "", GrantSourceSecurityGroup, false},
+			{"joe@photon.local", "viewer", EntityKindTenant, "t1", "tenant1", "t1", "", GrantSourceIam, false},
+			{"photon.local\\TenantAdmins", TenantAdminRole, EntityKindTenant, "t1", "tenant1", "t1", "", GrantSourceSecurityGroup, false},
+			{"joe@photon.local", "owner", EntityKindVm, "vm1", "web", "t1", "p1", GrantSourceIam, false},
+			{"ann@photon.local", "owner", EntityKindVm, "vm2", "db", "t1", "p1", GrantSourceIam, false},
+			{"joe@photon.local", "owner", EntityKindVm, "vm2", "db", "t1", "p1", GrantSourceIam, false},
+		}))
+	})
+
+	It("groups by subject", func() {
+		report, err := GenerateAuditReport(client, nil)
+		Expect(err).Should(BeNil())
+
+		groups := report.GroupBy(AuditGroupBySubject)
+		Expect(groups).Should(HaveLen(5))
+		Expect(groups["joe@photon.local"]).Should(HaveLen(3))
+		Expect(groups["tenantadmins@photon.local"]).Should(HaveLen(2))
+
+		buf := &bytes.Buffer{}
+		Expect(report.WriteJSON(buf, AuditGroupBySubject)).Should(Succeed())
+		var decoded struct {
+			GroupBy string                  `json:"groupBy"`
+			Groups  map[string][]AuditEntry `json:"groups"`
+		}
+		Expect(json.Unmarshal(buf.Bytes(), &decoded)).Should(Succeed())
+		Expect(decoded.GroupBy).Should(Equal(AuditGroupBySubject))
+		Expect(decoded.Groups).Should(Equal(groups))
+	})
+
+	It("writes CSV grouped by resource", func() {
+		report, err := GenerateAuditReport(client, nil)
+		Expect(err).Should(BeNil())
+
+		buf := &bytes.Buffer{}
+		Expect(report.WriteCSV(buf, AuditGroupByResource)).Should(Succeed())
+		rows, err := csv.NewReader(buf).ReadAll()
+		Expect(err).Should(BeNil())
+		Expect(rows).Should(HaveLen(9))
+		Expect(rows[0]).Should(Equal(auditCSVHeader))
+		Expect(rows[1]).Should(Equal([]string{
+			"image/img1", "photon.local\\Developers", "viewer", "image", "img1", "ubuntu", "", "", "iam", "false"}))
+		Expect(rows[8][0]).Should(Equal("vm/vm2"))
+	})
+
+	It("reports the same entries one request at a time", func() {
+		concurrent, err := GenerateAuditReport(client, nil)
+		Expect(err).Should(BeNil())
+		Expect(transport.maxInFlight).Should(BeNumerically("<=", defaultAuditConcurrency))
+
+		transport.maxInFlight = 0
+		serial, err := GenerateAuditReport(client, &AuditOptions{Concurrency: 1})
+		Expect(err).Should(BeNil())
+		Expect(transport.maxInFlight).Should(Equal(1))
+		Expect(serial.Entries).Should(Equal(concurrent.Entries))
+	})
+
+	It("returns the first error", func() {
+		server.SetResponseJsonForPath(rootUrl+"/vms/vm2/iam", 404,
+			createMockApiError("VmNotFound", "VM vm2 not found", 404))
+		_, err := GenerateAuditReport(client, nil)
+		Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+		Expect(err.(ApiError).Code).Should(Equal("VmNotFound"))
+	})
+
+	It("does not walk below an entity it fails to list", func() {
+		server.SetResponseJsonForPath(rootUrl+"/tenants", 500,
+			createMockApiError("InternalError", "tenants unavailable", 500))
+		report, err := GenerateAuditReport(client, &AuditOptions{Concurrency: 1})
+		Expect(report).Should(BeNil())
+		Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+		Expect(err.(ApiError).Code).Should(Equal("InternalError"))
+		Expect(server.RequestsFor("GET", rootUrl+"/tenants/")).Should(BeEmpty())
+		Expect(server.RequestsFor("GET", rootUrl+"/projects/")).Should(BeEmpty())
+	})
+})
@@ -10,24 +10,35 @@
 package photon
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
 	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
 )
 
 // Contains functionality for auth API.
 type AuthAPI struct {
 	client *Client
+
+	// Cached auth info and OIDC client, see InvalidateCache.
+	lock       sync.Mutex
+	authInfo   *AuthInfo
+	oidcClient *lightwave.OIDCClient
 }
 
 // Gets Tokens from username/password.
 func (api *AuthAPI) GetTokensByPassword(username string, password string) (tokenOptions *TokenOptions, err error) {
-	oidcClient, err := api.buildOIDCClient()
+	oidcClient, err := api.getOIDCClient()
 	if err != nil {
 		return
 	}
 
 	tokenResponse, err := oidcClient.GetTokenByPasswordGrant(username, password)
 	if err != nil {
+		api.invalidateOnConnectionError(err)
 		return
 	}
 
@@ -36,13 +47,14 @@ func (api *AuthAPI) GetTokensByPassword(username string, password string) (token
 
 // Gets tokens for client from username, password and a client ID.
 func (api *AuthAPI) GetClientTokensByPassword(username string, password string, clientID string) (tokenOptions *TokenOptions, err error) {
-	oidcClient, err := api.buildOIDCClient()
+	oidcClient, err := api.getOIDCClient()
 	if err != nil {
 		return
 	}
 
 	tokenResponse, err := oidcClient.GetClientTokenByPasswordGrant(username, password, clientID)
 	if err != nil {
+		api.invalidateOnConnectionError(err)
 		return
 	}
 
@@ -52,57 +64,252 @@ func (api *AuthAPI) GetClientTokensByPassword(username string, password string,
 // GetTokensFromWindowsLogInContext gets tokens based on Windows logged in context
 // In case of running on platform other than Windows, it returns error
 func (api *AuthAPI) GetTokensFromWindowsLogInContext() (tokenOptions *TokenOptions, err error) {
-	oidcClient, err := api.buildOIDCClient()
+	oidcClient, err := api.getOIDCClient()
 	if err != nil {
 		return
 	}
 
 	tokenResponse, err := oidcClient.GetTokensFromWindowsLogInContext()
 	if err != nil {
+		api.invalidateOnConnectionError(err)
+		return
+	}
+
+	return api.toTokenOptions(tokenResponse), nil
+}
+
+// Gets tokens using the GSS tokens produced by the authenticator, e.g. a
+// kerberos.Authenticator on platforms other than Windows.
+func (api *AuthAPI) GetTokensByGSSAuthenticator(auth lightwave.GSSAuthenticator) (tokenOptions *TokenOptions, err error) {
+	oidcClient, err := api.getOIDCClient()
+	if err != nil {
+		return
+	}
+
+	tokenResponse, err := oidcClient.GetTokensByGSSAuthenticator(auth)
+	if err != nil {
+		api.invalidateOnConnectionError(err)
 		return
 	}
 
 	return api.toTokenOptions(tokenResponse), nil
 }
 
+// Gets the service principal name of the auth server, needed to create a GSS authenticator.
+func (api *AuthAPI) GetServicePrincipalName() (spn string, err error) {
+	oidcClient, err := api.getOIDCClient()
+	if err != nil {
+		return
+	}
+
+	return oidcClient.ServicePrincipalName()
+}
+
 // Gets tokens from refresh token.
 func (api *AuthAPI) GetTokensByRefreshToken(refreshtoken string) (tokenOptions *TokenOptions, err error) {
-	oidcClient, err := api.buildOIDCClient()
+	oidcClient, err := api.getOIDCClient()
 	if err != nil {
 		return
 	}
 
 	tokenResponse, err := oidcClient.GetTokenByRefreshTokenGrant(refreshtoken)
 	if err != nil {
+		api.invalidateOnConnectionError(err)
 		return
 	}
 
 	return api.toTokenOptions(tokenResponse), nil
 }
 
+// Revokes the refresh token so it can no longer be used to get new access tokens.
+func (api *AuthAPI) RevokeRefreshToken(refreshToken string) (err error) {
+	oidcClient, err := api.getOIDCClient()
+	if err != nil {
+		return
+	}
+
+	err = oidcClient.RevokeToken(refreshToken, lightwave.RefreshTokenTypeHint)
+	api.invalidateOnConnectionError(err)
+	return
+}
+
+// Logs out the current user. The refresh token held by the client is revoked,
+// the Lightwave session is ended and the client's tokens are cleared.
+// The tokens are cleared even if the auth server could not be reached.
+// With ClientOptions.CredentialProvider, requests are no longer logged in
+// automatically until Login is called.
+func (api *AuthAPI) Logout() (err error) {
+	login := api.client.login
+	if login != nil {
+		login.lock.Lock()
+		defer login.lock.Unlock()
+		login.loggedOut = true
+	}
+
+	tokens := api.client.options.TokenOptions
+	if tokens != nil && (tokens.RefreshToken != "" || tokens.IdToken != "") {
+		var oidcClient *lightwave.OIDCClient
+		oidcClient, err = api.getOIDCClient()
+		if err == nil {
+			err = oidcClient.Logout(tokens.RefreshToken, tokens.IdToken)
+			api.invalidateOnConnectionError(err)
+		}
+	}
+
+	// Cleared in place, so that a caller holding the TokenOptions passed to
+	// NewClient does not keep the old tokens.
+	*api.client.options.TokenOptions = TokenOptions{}
+	if api.client.options.UpdateAccessTokenCallback != nil {
+		api.client.options.UpdateAccessTokenCallback("")
+	}
+	return
+}
+
+// Logs in with the credentials from ClientOptions.CredentialProvider, and
+// turns automatic login back on after Logout.
+func (api *AuthAPI) Login() (err error) {
+	login := api.client.login
+	if login == nil {
+		return errors.New("photon: Client has no credential provider to log in with")
+	}
+	login.lock.Lock()
+	login.loggedOut = false
+	staleToken := api.client.options.TokenOptions.AccessToken
+	login.lock.Unlock()
+
+	_, err = api.client.loginWithCredentials(staleToken)
+	return
+}
+
+// Gets a client for the Lightwave IDM API of the auth server's tenant. If accessToken is
+// empty the client's current access token is used; note that the IDM API only accepts
+// tokens requested with lightwave.IDMTokenScope.
+func (api *AuthAPI) GetIDMClient(accessToken string) (idmClient *lightwave.IDMClient, err error) {
+	authInfo, err := api.getAuthInfo()
+	if err != nil {
+		return
+	}
+
+	authEndpoint, err := api.getAuthEndpoint()
+	if err != nil {
+		return
+	}
+
+	if accessToken == "" && api.client.options.TokenOptions != nil {
+		accessToken = api.client.options.TokenOptions.AccessToken
+	}
+
+	return lightwave.NewIDMClient(
+		authEndpoint,
+		authInfo.Domain,
+		accessToken,
+		api.buildOIDCClientOptions(&api.client.options),
+		api.client.restClient.logger), nil
+}
+
+// Sets the endpoint of the auth server, overriding the one reported by the system
+// auth info, see ClientOptions.AuthEndpoint. An empty endpoint removes the override.
+func (api *AuthAPI) SetAuthEndpoint(endpoint string) {
+	api.client.options.AuthEndpoint = endpoint
+	api.InvalidateCache()
+}
+
+// Drops the cached auth info and OIDC client, so that the next call fetches the
+// auth info again and connects to the auth server with a new connection pool.
+// This happens automatically when a connection to the auth server fails.
+func (api *AuthAPI) InvalidateCache() {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	api.authInfo = nil
+	api.oidcClient = nil
+}
+
+// TLS and connection errors are reported as *url.Error; the auth server may
+// have moved or changed certificates, so start over on the next call.
+func (api *AuthAPI) invalidateOnConnectionError(err error) {
+	if _, ok := err.(*url.Error); ok {
+		api.InvalidateCache()
+	}
+}
+
+func (api *AuthAPI) getAuthInfo() (authInfo *AuthInfo, err error) {
+	api.lock.Lock()
+	authInfo = api.authInfo
+	api.lock.Unlock()
+	if authInfo != nil {
+		return
+	}
+
+	// The lock is not held during the request, since it may take a while
+	// and concurrent callers would all fetch the same info anyway.
+	authInfo, err = api.client.System.GetAuthInfo()
+	if err != nil {
+		return
+	}
+
+	api.lock.Lock()
+	api.authInfo = authInfo
+	api.lock.Unlock()
+	return
+}
+
 func (api *AuthAPI) getAuthEndpoint() (endpoint string, err error) {
-	authInfo, err := api.client.System.GetAuthInfo()
+	if api.client.options.AuthEndpoint != "" {
+		return strings.TrimRight(api.client.options.AuthEndpoint, "/"), nil
+	}
+
+	authInfo, err := api.getAuthInfo()
 	if err != nil {
 		return
 	}
 
-	if authInfo.Port == 0 {
-		authInfo.Port = 443
+	return toAuthEndpoint(authInfo), nil
+}
+
+func toAuthEndpoint(authInfo *AuthInfo) string {
+	port := authInfo.Port
+	if port == 0 {
+		port = 443
 	}
 
-	return fmt.Sprintf("https://%s:%d", authInfo.Endpoint, authInfo.Port), nil
+	return fmt.Sprintf("https://%s:%d", authInfo.Endpoint, port)
 }
 
-func (api *AuthAPI) buildOIDCClient() (client *lightwave.OIDCClient, err error) {
+// Returns the cached OIDC client, creating it on first use. Reusing the client
+// keeps its connection pool, so token refreshes do not open new connections.
+func (api *AuthAPI) getOIDCClient() (client *lightwave.OIDCClient, err error) {
+	api.lock.Lock()
+	client = api.oidcClient
+	api.lock.Unlock()
+	if client != nil {
+		return
+	}
+
+	// The client is built with the TLS settings of the trust bootstrap, which
+	// has not run yet if no API call was made before, e.g. with AuthEndpoint set.
+	if api.client.restClient.TrustBootstrap != nil {
+		err = api.client.restClient.TrustBootstrap()
+		if err != nil {
+			return
+		}
+	}
+
 	authEndPoint, err := api.getAuthEndpoint()
 	if err != nil {
 		return
 	}
 
-	return lightwave.NewOIDCClient(
+	client = lightwave.NewOIDCClient(
 		authEndPoint,
 		api.buildOIDCClientOptions(&api.client.options),
-		api.client.restClient.logger), nil
+		api.client.restClient.logger)
+
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	if api.oidcClient == nil {
+		api.oidcClient = client
+	}
+	return api.oidcClient, nil
 }
 
 const tokenScope string = "openid offline_access rs_photon_platform at_groups"
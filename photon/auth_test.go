@@ -10,7 +10,9 @@
 package photon
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -60,6 +62,19 @@ var _ = Describe("Auth", func() {
 				Expect(err).Should(BeNil())
 				Expect(info).Should(BeEquivalentTo(expected))
 			})
+
+			It("returns the typed OIDC error unchanged", func() {
+				authServer.SetResponseJson(400, &lightwave.OIDCError{
+					Code:    "invalid_grant",
+					Message: "incorrect username or password",
+				})
+
+				info, err := client.Auth.GetTokensByPassword("username", "password")
+				Expect(info).Should(BeNil())
+				_, ok := err.(lightwave.InvalidGrantError)
+				Expect(ok).Should(BeTrue())
+				Expect(lightwave.NeedsReprompt(err)).Should(BeTrue())
+			})
 		})
 	})
 
@@ -87,6 +102,85 @@ var _ = Describe("Auth", func() {
 		})
 	})
 
+	Describe("Cache", func() {
+		var expected *TokenOptions
+
+		BeforeEach(func() {
+			expected = &TokenOptions{
+				AccessToken: "fake_access_token",
+				ExpiresIn:   36000,
+				IdToken:     "fake_id_token",
+				TokenType:   "Bearer",
+			}
+			authServer.SetResponseJson(200, expected)
+		})
+
+		It("reuses the auth info and OIDC client", func() {
+			server.SetResponseJson(200, createMockAuthInfo(authServer))
+			_, err := client.Auth.GetTokensByRefreshToken("refresh_token")
+			Expect(err).Should(BeNil())
+			oidcClient := client.Auth.oidcClient
+			Expect(oidcClient).ShouldNot(BeNil())
+
+			server.SetResponse(500, "Error")
+			info, err := client.Auth.GetTokensByRefreshToken("refresh_token")
+			Expect(err).Should(BeNil())
+			Expect(info).Should(BeEquivalentTo(expected))
+			Expect(client.Auth.oidcClient).Should(BeIdenticalTo(oidcClient))
+		})
+
+		It("is invalidated on connection errors", func() {
+			server.SetResponseJson(200, createMockAuthInfo(authServer))
+			_, err := client.Auth.GetTokensByRefreshToken("refresh_token")
+			Expect(err).Should(BeNil())
+
+			client.Auth.oidcClient.Endpoint = "https://127.0.0.1:1"
+			_, err = client.Auth.GetTokensByRefreshToken("refresh_token")
+			_, ok := err.(*url.Error)
+			Expect(ok).Should(BeTrue())
+			Expect(client.Auth.oidcClient).Should(BeNil())
+			Expect(client.Auth.authInfo).Should(BeNil())
+
+			info, err := client.Auth.GetTokensByRefreshToken("refresh_token")
+			Expect(err).Should(BeNil())
+			Expect(info).Should(BeEquivalentTo(expected))
+		})
+
+		It("uses the overridden auth endpoint", func() {
+			server.SetResponseJson(200, &AuthInfo{Endpoint: "127.0.0.1", Port: 1})
+			client.Auth.SetAuthEndpoint(authServer.HttpServer.URL)
+
+			info, err := client.Auth.GetTokensByRefreshToken("refresh_token")
+			Expect(err).Should(BeNil())
+			Expect(info).Should(BeEquivalentTo(expected))
+			Expect(client.Auth.oidcClient.Endpoint).Should(Equal(authServer.HttpServer.URL))
+		})
+	})
+
+	Describe("GetTokensByGSSAuthenticator", func() {
+		Context("when auth is enabled", func() {
+			BeforeEach(func() {
+				server.SetResponseJson(200, createMockAuthInfo(authServer))
+			})
+
+			It("returns tokens", func() {
+				expected := &TokenOptions{
+					AccessToken:  "fake_access_token",
+					ExpiresIn:    36000,
+					RefreshToken: "fake_refresh_token",
+					IdToken:      "fake_id_token",
+					TokenType:    "Bearer",
+				}
+				authServer.SetResponseJson(200, expected)
+
+				info, err := client.Auth.GetTokensByGSSAuthenticator(&fakeGSSAuthenticator{})
+				fmt.Fprintf(GinkgoWriter, "Got tokens: %+v\n", info)
+				Expect(err).Should(BeNil())
+				Expect(info).Should(BeEquivalentTo(expected))
+			})
+		})
+	})
+
 	Describe("GetTokensByRefreshToken", func() {
 		Context("when auth is enabled", func() {
 			BeforeEach(func() {
@@ -110,6 +204,87 @@ var _ = Describe("Auth", func() {
 		})
 	})
 
+	Describe("Logout", func() {
+		Context("when auth is enabled", func() {
+			BeforeEach(func() {
+				server.SetResponseJson(200, createMockAuthInfo(authServer))
+			})
+
+			It("revokes tokens and clears them from the client", func() {
+				authServer.SetResponse(200, "")
+				client.options.TokenOptions = &TokenOptions{
+					AccessToken:  "fake_access_token",
+					RefreshToken: "fake_refresh_token",
+					IdToken:      "fake_id_token",
+				}
+				var updatedToken *string
+				client.options.UpdateAccessTokenCallback = func(token string) {
+					updatedToken = &token
+				}
+
+				err := client.Auth.Logout()
+				Expect(err).Should(BeNil())
+				Expect(client.options.TokenOptions).Should(BeEquivalentTo(&TokenOptions{}))
+				Expect(updatedToken).ShouldNot(BeNil())
+				Expect(*updatedToken).Should(Equal(""))
+			})
+
+			It("clears tokens when revocation fails", func() {
+				authServer.SetResponse(400, "Error")
+				client.options.TokenOptions = &TokenOptions{
+					AccessToken:  "fake_access_token",
+					RefreshToken: "fake_refresh_token",
+				}
+
+				err := client.Auth.Logout()
+				Expect(err).ShouldNot(BeNil())
+				Expect(client.options.TokenOptions).Should(BeEquivalentTo(&TokenOptions{}))
+			})
+
+			It("clears the tokens the caller passed in", func() {
+				authServer.SetResponse(200, "")
+				tokens := &TokenOptions{
+					AccessToken:  "fake_access_token",
+					RefreshToken: "fake_refresh_token",
+				}
+				client.options.TokenOptions = tokens
+
+				err := client.Auth.Logout()
+				Expect(err).Should(BeNil())
+				Expect(tokens).Should(BeEquivalentTo(&TokenOptions{}))
+			})
+		})
+	})
+
+	Describe("GetIDMClient", func() {
+		It("uses the tenant and access token of the client", func() {
+			authInfo := createMockAuthInfo(authServer)
+			authInfo.Domain = "photon.local"
+			server.SetResponseJson(200, authInfo)
+			client.options.TokenOptions = &TokenOptions{AccessToken: "fake_access_token"}
+
+			idmClient, err := client.Auth.GetIDMClient("")
+			Expect(err).Should(BeNil())
+			Expect(idmClient.Tenant).Should(Equal("photon.local"))
+			Expect(idmClient.AccessToken).Should(Equal("fake_access_token"))
+			Expect(idmClient.Endpoint).Should(Equal(authServer.HttpServer.URL))
+		})
+	})
+
+	Describe("RevokeRefreshToken", func() {
+		Context("when auth is enabled", func() {
+			BeforeEach(func() {
+				server.SetResponseJson(200, createMockAuthInfo(authServer))
+			})
+
+			It("revokes the token", func() {
+				authServer.SetResponse(200, "")
+				err := client.Auth.RevokeRefreshToken("fake_refresh_token")
+				Expect(err).Should(BeNil())
+			})
+		})
+	})
+
 	Describe("ParseTokenDetails", func() {
 		Context("with the fake token", func() {
 			BeforeEach(func() {
@@ -130,3 +305,15 @@ var _ = Describe("Auth", func() {
 		})
 	})
 })
+
+type fakeGSSAuthenticator struct{}
+
+func (auth *fakeGSSAuthenticator) InitialBytes() ([]byte, error) {
+	return []byte("fake_ticket"), nil
+}
+
+func (auth *fakeGSSAuthenticator) NextBytes(serverToken []byte) ([]byte, error) {
+	return nil, errors.New("Unexpected GSS continuation")
+}
+
+func (auth *fakeGSSAuthenticator) Free() {}
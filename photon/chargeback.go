@@ -0,0 +1,495 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ways of totaling a chargeback snapshot.
+const (
+	ChargebackGroupByTenant  string = "tenant"
+	ChargebackGroupByProject string = "project"
+	ChargebackGroupByTag     string = "tag"
+)
+
+// Group key of resources without tags when totaling by tag.
+const ChargebackUntagged string = "(untagged)"
+
+// Price of one unit of a quota key, e.g. of 1 GB of "vm.memory", for one
+// billing period such as a month.
+type Price struct {
+	Key   string  `json:"key"`
+	Unit  string  `json:"unit"`
+	Price float64 `json:"price"`
+}
+
+// Prices by quota key. Line items are converted to the unit of their price,
+// so memory can be priced by GB while flavors report it in MB.
+type PriceTable struct {
+	Currency string  `json:"currency"`
+	Prices   []Price `json:"prices"`
+}
+
+// Reads a price table from JSON.
+func LoadPriceTable(r io.Reader) (table *PriceTable, err error) {
+	table = &PriceTable{}
+	err = json.NewDecoder(r).Decode(table)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Returns the price of the line items, and the keys that have no price. A nil
+// table has no prices, so all keys are unpriced.
+func (table *PriceTable) Amount(items []QuotaLineItem) (amount float64, unpriced []string, err error) {
+	prices := map[string]Price{}
+	if table != nil {
+		for _, price := range table.Prices {
+			prices[price.Key] = price
+		}
+	}
+	for _, item := range items {
+		price, ok := prices[item.Key]
+		if !ok {
+			unpriced = append(unpriced, item.Key)
+			continue
+		}
+		var value float64
+		value, err = ConvertQuotaUnit(item.Value, item.Unit, price.Unit)
+		if err != nil {
+			return 0, nil, fmt.Errorf("photon: Price of '%s': %s", item.Key, err)
+		}
+		amount += value * price.Price
+	}
+	return
+}
+
+// Options for GenerateChargebackSnapshot.
+type ChargebackOptions struct {
+	// Maximum number of API requests in flight, 4 if not set.
+	Concurrency int
+}
+
+// Cost of one VM or persistent disk.
+type ChargebackResource struct {
+	// EntityKindVm or EntityKindDisk.
+	Kind        string   `json:"kind"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	TenantID    string   `json:"tenantId"`
+	TenantName  string   `json:"tenantName"`
+	ProjectID   string   `json:"projectId"`
+	ProjectName string   `json:"projectName"`
+	Tags        []string `json:"tags,omitempty"`
+
+	// The cost line items as reported by the API, those of the flavor if the
+	// VM or disk reports none, and their price.
+	Cost     []QuotaLineItem `json:"cost"`
+	Amount   float64         `json:"amount"`
+	Unpriced []string        `json:"unpriced,omitempty"`
+}
+
+// Costs of all VMs and persistent disks of the deployment at one point in time.
+// Snapshots can be saved and loaded, so that runs can be compared.
+type ChargebackSnapshot struct {
+	TakenAt   time.Time            `json:"takenAt"`
+	Prices    *PriceTable          `json:"prices"`
+	Resources []ChargebackResource `json:"resources"`
+}
+
+// Total cost of one tenant, project or tag.
+type ChargebackTotal struct {
+	// ID of the tenant or project, or the tag.
+	Key       string  `json:"key"`
+	Name      string  `json:"name,omitempty"`
+	TenantID  string  `json:"tenantId,omitempty"`
+	Resources int     `json:"resources"`
+	Amount    float64 `json:"amount"`
+}
+
+// Change of the total of one tenant, project or tag between two snapshots.
+type ChargebackDelta struct {
+	Key    string  `json:"key"`
+	Name   string  `json:"name,omitempty"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Change float64 `json:"change"`
+}
+
+// Changes between two snapshots, of the groups whose total changed.
+type ChargebackDiff struct {
+	GroupBy string            `json:"groupBy"`
+	From    time.Time         `json:"from"`
+	To      time.Time         `json:"to"`
+	Deltas  []ChargebackDelta `json:"deltas"`
+}
+
+var chargebackCSVHeader = []string{"group", "key", "name", "tenant_id", "resources", "amount", "currency"}
+
+var chargebackDiffCSVHeader = []string{"group", "key", "name", "before", "after", "change"}
+
+// Walks all tenants, projects, VMs and persistent disks of the deployment and
+// prices their cost line items, falling back to the cost of their flavor. With
+// nil prices, all resources are unpriced. Returns the first error hit.
+func GenerateChargebackSnapshot(client *Client, prices *PriceTable, options *ChargebackOptions) (snapshot *ChargebackSnapshot, err error) {
+	concurrency := defaultAuditConcurrency
+	if options != nil && options.Concurrency > 0 {
+		concurrency = options.Concurrency
+	}
+
+	walker := &chargebackWalker{jobRunner: newJobRunner(concurrency), client: client, prices: prices}
+	walker.spawn(walker.walkTenants)
+	err = walker.wait()
+	if err != nil {
+		return
+	}
+
+	sort.Sort(chargebackResources(walker.resources))
+	snapshot = &ChargebackSnapshot{TakenAt: time.Now().UTC(), Prices: prices, Resources: walker.resources}
+	return
+}
+
+// Reads a snapshot saved with Save.
+func LoadChargebackSnapshot(r io.Reader) (snapshot *ChargebackSnapshot, err error) {
+	snapshot = &ChargebackSnapshot{}
+	err = json.NewDecoder(r).Decode(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Writes the snapshot as JSON.
+func (snapshot *ChargebackSnapshot) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+// Returns the totals by ChargebackGroupByTenant, ChargebackGroupByProject or
+// ChargebackGroupByTag, sorted by key. A resource with several tags counts
+// towards each of them.
+func (snapshot *ChargebackSnapshot) Totals(groupBy string) (totals []ChargebackTotal, err error) {
+	switch groupBy {
+	case ChargebackGroupByTenant, ChargebackGroupByProject, ChargebackGroupByTag:
+	default:
+		return nil, fmt.Errorf("photon: Unknown chargeback grouping '%s'", groupBy)
+	}
+
+	byKey := map[string]*ChargebackTotal{}
+	keys := []string{}
+	add := func(key string, name string, tenantID string, resource *ChargebackResource) {
+		total := byKey[key]
+		if total == nil {
+			total = &ChargebackTotal{Key: key, Name: name, TenantID: tenantID}
+			byKey[key] = total
+			keys = append(keys, key)
+		}
+		total.Resources++
+		total.Amount += resource.Amount
+	}
+
+	for idx := range snapshot.Resources {
+		resource := &snapshot.Resources[idx]
+		switch groupBy {
+		case ChargebackGroupByTenant:
+			add(resource.TenantID, resource.TenantName, "", resource)
+		case ChargebackGroupByProject:
+			add(resource.ProjectID, resource.ProjectName, resource.TenantID, resource)
+		case ChargebackGroupByTag:
+			if len(resource.Tags) == 0 {
+				add(ChargebackUntagged, "", "", resource)
+			}
+			for _, tag := range resource.Tags {
+				add(tag, "", "", resource)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		totals = append(totals, *byKey[key])
+	}
+	return
+}
+
+// Writes the totals as JSON.
+func (snapshot *ChargebackSnapshot) WriteJSON(w io.Writer, groupBy string) error {
+	totals, err := snapshot.Totals(groupBy)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(&struct {
+		TakenAt  time.Time         `json:"takenAt"`
+		GroupBy  string            `json:"groupBy"`
+		Currency string            `json:"currency"`
+		Totals   []ChargebackTotal `json:"totals"`
+	}{snapshot.TakenAt, groupBy, snapshot.currency(), totals})
+}
+
+// Writes the totals as CSV, one row per group after a header row.
+func (snapshot *ChargebackSnapshot) WriteCSV(w io.Writer, groupBy string) error {
+	totals, err := snapshot.Totals(groupBy)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	err = writer.Write(chargebackCSVHeader)
+	if err != nil {
+		return err
+	}
+	for _, total := range totals {
+		err = writer.Write([]string{
+			groupBy,
+			total.Key,
+			total.Name,
+			total.TenantID,
+			strconv.Itoa(total.Resources),
+			formatAmount(total.Amount),
+			snapshot.currency(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (snapshot *ChargebackSnapshot) currency() string {
+	if snapshot.Prices == nil {
+		return ""
+	}
+	return snapshot.Prices.Currency
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// Compares the totals of two snapshots. Groups only in one of them count as
+// zero in the other.
+func DiffChargeback(before *ChargebackSnapshot, after *ChargebackSnapshot, groupBy string) (diff *ChargebackDiff, err error) {
+	beforeTotals, err := before.Totals(groupBy)
+	if err != nil {
+		return
+	}
+	afterTotals, err := after.Totals(groupBy)
+	if err != nil {
+		return
+	}
+
+	deltas := map[string]*ChargebackDelta{}
+	keys := []string{}
+	delta := func(total ChargebackTotal) *ChargebackDelta {
+		if deltas[total.Key] == nil {
+			deltas[total.Key] = &ChargebackDelta{Key: total.Key}
+			keys = append(keys, total.Key)
+		}
+		// The later name wins, e.g. for a renamed project.
+		if total.Name != "" {
+			deltas[total.Key].Name = total.Name
+		}
+		return deltas[total.Key]
+	}
+	for _, total := range beforeTotals {
+		delta(total).Before = total.Amount
+	}
+	for _, total := range afterTotals {
+		delta(total).After = total.Amount
+	}
+
+	diff = &ChargebackDiff{GroupBy: groupBy, From: before.TakenAt, To: after.TakenAt}
+	sort.Strings(keys)
+	for _, key := range keys {
+		change := deltas[key]
+		change.Change = change.After - change.Before
+		if formatAmount(change.Change) != formatAmount(0) {
+			diff.Deltas = append(diff.Deltas, *change)
+		}
+	}
+	return
+}
+
+// Writes the diff as JSON.
+func (diff *ChargebackDiff) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(diff)
+}
+
+// Writes the diff as CSV, one row per changed group after a header row.
+func (diff *ChargebackDiff) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(chargebackDiffCSVHeader)
+	if err != nil {
+		return err
+	}
+	for _, delta := range diff.Deltas {
+		err = writer.Write([]string{
+			diff.GroupBy,
+			delta.Key,
+			delta.Name,
+			formatAmount(delta.Before),
+			formatAmount(delta.After),
+			formatAmount(delta.Change),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Walks the deployment, pricing resources.
+type chargebackWalker struct {
+	*jobRunner
+	client *Client
+	prices *PriceTable
+
+	lock      sync.Mutex
+	resources []ChargebackResource
+
+	// Flavors are only read if a resource reports no cost.
+	flavorsOnce sync.Once
+	flavors     flavorIndex
+	flavorsErr  error
+}
+
+// Returns the cost of the flavor of the given kind and name, none if there is
+// no such flavor.
+func (w *chargebackWalker) flavorCost(kind string, name string) ([]QuotaLineItem, error) {
+	w.flavorsOnce.Do(func() {
+		w.flavors, w.flavorsErr = getFlavorIndex(w.client)
+	})
+	if w.flavorsErr != nil {
+		return nil, w.flavorsErr
+	}
+	if flavor := w.flavors[kind][name]; flavor != nil {
+		return flavor.Cost, nil
+	}
+	return nil, nil
+}
+
+func (w *chargebackWalker) add(resource ChargebackResource) (err error) {
+	resource.Amount, resource.Unpriced, err = w.prices.Amount(resource.Cost)
+	if err != nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.resources = append(w.resources, resource)
+	return
+}
+
+func (w *chargebackWalker) walkTenants() error {
+	tenants, err := w.client.Tenants.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants.Items {
+		tenant := tenant
+		w.spawn(func() error { return w.walkProjects(&tenant) })
+	}
+	return nil
+}
+
+func (w *chargebackWalker) walkProjects(tenant *Tenant) error {
+	projects, err := w.client.Tenants.GetProjects(tenant.ID, nil)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects.Items {
+		owner := ChargebackResource{
+			TenantID:    tenant.ID,
+			TenantName:  tenant.Name,
+			ProjectID:   project.ID,
+			ProjectName: project.Name,
+		}
+		w.spawn(func() error { return w.walkVMs(owner) })
+		w.spawn(func() error { return w.walkDisks(owner) })
+	}
+	return nil
+}
+
+func (w *chargebackWalker) walkVMs(owner ChargebackResource) error {
+	vms, err := w.client.Projects.GetVMs(owner.ProjectID, nil)
+	if err != nil {
+		return err
+	}
+	for _, vm := range vms.Items {
+		resource := owner
+		resource.Kind = EntityKindVm
+		resource.ID = vm.ID
+		resource.Name = vm.Name
+		resource.Tags = vm.Tags
+		resource.Cost = vm.Cost
+		if len(resource.Cost) == 0 && vm.Flavor != "" {
+			if resource.Cost, err = w.flavorCost(VmFlavorKind, vm.Flavor); err != nil {
+				return err
+			}
+		}
+		if err := w.add(resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *chargebackWalker) walkDisks(owner ChargebackResource) error {
+	disks, err := w.client.Projects.GetDisks(owner.ProjectID, nil)
+	if err != nil {
+		return err
+	}
+	for _, disk := range disks.Items {
+		resource := owner
+		resource.Kind = EntityKindDisk
+		resource.ID = disk.ID
+		resource.Name = disk.Name
+		resource.Tags = disk.Tags
+		resource.Cost = disk.Cost
+		if len(resource.Cost) == 0 && disk.Flavor != "" {
+			if resource.Cost, err = w.flavorCost(PersistentDiskKind, disk.Flavor); err != nil {
+				return err
+			}
+		}
+		if err := w.add(resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sorts resources by tenant, project, kind and ID.
+type chargebackResources []ChargebackResource
+
+func (resources chargebackResources) Len() int { return len(resources) }
+func (resources chargebackResources) Swap(i, j int) {
+	resources[i], resources[j] = resources[j], resources[i]
+}
+func (resources chargebackResources) Less(i, j int) bool {
+	a, b := resources[i], resources[j]
+	if a.TenantID != b.TenantID {
+		return a.TenantID < b.TenantID
+	}
+	if a.ProjectID != b.ProjectID {
+		return a.ProjectID < b.ProjectID
+	}
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return a.ID < b.ID
+}
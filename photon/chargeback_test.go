@@ -0,0 +1,244 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("Chargeback", func() {
+	var (
+		server *mocks.Server
+		client *Client
+		prices *PriceTable
+	)
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping chargeback test on integration mode.")
+		}
+		server, client = mockServerClient()
+		server.SetResponseJson(404, createMockApiError("NotFound", "Not found", 404))
+		responses := map[string]interface{}{
+			"/tenants":             &Tenants{Items: []Tenant{{ID: "t1", Name: "acme"}, {ID: "t2", Name: "globex"}}},
+			"/tenants/t1/projects": &ProjectList{Items: []ProjectCompact{{ID: "p1", Name: "web"}}},
+			"/tenants/t2/projects": &ProjectList{Items: []ProjectCompact{{ID: "p2", Name: "db"}}},
+			"/projects/p1/vms": &VMs{Items: []VM{
+				{ID: "vm1", Name: "web-1", Tags: []string{"web"}, Cost: []QuotaLineItem{
+					{"COUNT", 2, "vm.cpu"}, {"MB", 4096, "vm.memory"}, {"COUNT", 1, "vm"}}},
+				{ID: "vm2", Name: "web-2", Tags: []string{"web", "frontend"}, Cost: []QuotaLineItem{
+					{"COUNT", 1, "vm.cpu"}, {"GB", 2, "vm.memory"}}},
+			}},
+			"/projects/p1/disks": &DiskList{Items: []PersistentDisk{
+				{ID: "d1", Name: "data", Cost: []QuotaLineItem{{"GB", 100, "persistent-disk.capacity"}}},
+			}},
+			"/projects/p2/vms": &VMs{Items: []VM{
+				{ID: "vm3", Name: "db-1", Tags: []string{"db"}, Cost: []QuotaLineItem{{"COUNT", 4, "vm.cpu"}}},
+			}},
+			"/projects/p2/disks": &DiskList{},
+		}
+		for path, response := range responses {
+			server.SetResponseJsonForPath(rootUrl+path, 200, response)
+		}
+
+		prices, _ = LoadPriceTable(strings.NewReader(`{"currency": "USD", "prices": [
+			{"key": "vm.cpu", "unit": "COUNT", "price": 10},
+			{"key": "vm.memory", "unit": "GB", "price": 5},
+			{"key": "persistent-disk.capacity", "unit": "GB", "price": 0.1}
+		]}`))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("prices every VM and disk", func() {
+		snapshot, err := GenerateChargebackSnapshot(client, prices, &ChargebackOptions{Concurrency: 2})
+		Expect(err).Should(BeNil())
+		Expect(snapshot.Resources).Should(HaveLen(4))
+
+		resource := snapshot.Resources[0]
+		Expect(resource.Kind).Should(Equal(EntityKindDisk))
+		Expect(resource.ID).Should(Equal("d1"))
+		Expect(resource.TenantName).Should(Equal("acme"))
+		Expect(resource.ProjectName).Should(Equal("web"))
+		Expect(resource.Amount).Should(BeNumerically("~", 10, 1e-9))
+
+		resource = snapshot.Resources[1]
+		Expect(resource.ID).Should(Equal("vm1"))
+		Expect(resource.Amount).Should(Equal(40.0))
+		Expect(resource.Unpriced).Should(Equal([]string{"vm"}))
+	})
+
+	It("totals by tenant, project and tag", func() {
+		snapshot, err := GenerateChargebackSnapshot(client, prices, nil)
+		Expect(err).Should(BeNil())
+
+		totals, err := snapshot.Totals(ChargebackGroupByTenant)
+		Expect(err).Should(BeNil())
+		Expect(totals).Should(HaveLen(2))
+		Expect(totals[0].Key).Should(Equal("t1"))
+		Expect(totals[0].Name).Should(Equal("acme"))
+		Expect(totals[0].Resources).Should(Equal(3))
+		Expect(totals[0].Amount).Should(BeNumerically("~", 70, 1e-9))
+		Expect(totals[1]).Should(Equal(ChargebackTotal{Key: "t2", Name: "globex", Resources: 1, Amount: 40}))
+
+		totals, err = snapshot.Totals(ChargebackGroupByProject)
+		Expect(err).Should(BeNil())
+		Expect(totals[1]).Should(Equal(ChargebackTotal{Key: "p2", Name: "db", TenantID: "t2", Resources: 1, Amount: 40}))
+
+		totals, err = snapshot.Totals(ChargebackGroupByTag)
+		Expect(err).Should(BeNil())
+		keys := []string{}
+		for _, total := range totals {
+			keys = append(keys, total.Key)
+		}
+		Expect(keys).Should(Equal([]string{ChargebackUntagged, "db", "frontend", "web"}))
+		Expect(totals[3].Amount).Should(Equal(60.0))
+
+		_, err = snapshot.Totals("zone")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("writes totals as CSV and JSON", func() {
+		snapshot, err := GenerateChargebackSnapshot(client, prices, nil)
+		Expect(err).Should(BeNil())
+
+		var buf bytes.Buffer
+		Expect(snapshot.WriteCSV(&buf, ChargebackGroupByTenant)).Should(BeNil())
+		rows, err := csv.NewReader(&buf).ReadAll()
+		Expect(err).Should(BeNil())
+		Expect(rows).Should(Equal([][]string{
+			chargebackCSVHeader,
+			{"tenant", "t1", "acme", "", "3", "70.00", "USD"},
+			{"tenant", "t2", "globex", "", "1", "40.00", "USD"},
+		}))
+
+		buf.Reset()
+		Expect(snapshot.WriteJSON(&buf, ChargebackGroupByProject)).Should(BeNil())
+		var result struct {
+			Currency string
+			Totals   []ChargebackTotal
+		}
+		Expect(json.Unmarshal(buf.Bytes(), &result)).Should(BeNil())
+		Expect(result.Currency).Should(Equal("USD"))
+		Expect(result.Totals).Should(HaveLen(2))
+	})
+
+	It("reports CSV write errors", func() {
+		snapshot, err := GenerateChargebackSnapshot(client, prices, nil)
+		Expect(err).Should(BeNil())
+		reader, writer := io.Pipe()
+		reader.Close()
+		Expect(snapshot.WriteCSV(writer, ChargebackGroupByTenant)).Should(Equal(io.ErrClosedPipe))
+
+		diff := &ChargebackDiff{GroupBy: ChargebackGroupByTenant, Deltas: []ChargebackDelta{{Key: "t1", Change: 1}}}
+		Expect(diff.WriteCSV(writer)).Should(Equal(io.ErrClosedPipe))
+	})
+
+	It("diffs saved snapshots", func() {
+		snapshot, err := GenerateChargebackSnapshot(client, prices, nil)
+		Expect(err).Should(BeNil())
+		var buf bytes.Buffer
+		Expect(snapshot.Save(&buf)).Should(BeNil())
+		before, err := LoadChargebackSnapshot(&buf)
+		Expect(err).Should(BeNil())
+		Expect(before.Resources).Should(Equal(snapshot.Resources))
+
+		// A month later vm2 is gone and vm3 is bigger.
+		after := *before
+		after.TakenAt = before.TakenAt.AddDate(0, 1, 0)
+		after.Resources = []ChargebackResource{before.Resources[0], before.Resources[1], before.Resources[3]}
+		after.Resources[2].Amount = 60
+
+		diff, err := DiffChargeback(before, &after, ChargebackGroupByTenant)
+		Expect(err).Should(BeNil())
+		Expect(diff.Deltas).Should(HaveLen(2))
+		Expect(diff.Deltas[0].Key).Should(Equal("t1"))
+		Expect(diff.Deltas[0].Change).Should(BeNumerically("~", -20, 1e-9))
+		Expect(diff.Deltas[1]).Should(Equal(ChargebackDelta{Key: "t2", Name: "globex", Before: 40, After: 60, Change: 20}))
+
+		diff, err = DiffChargeback(before, &after, ChargebackGroupByTag)
+		Expect(err).Should(BeNil())
+		buf.Reset()
+		Expect(diff.WriteCSV(&buf)).Should(BeNil())
+		rows, err := csv.NewReader(&buf).ReadAll()
+		Expect(err).Should(BeNil())
+		Expect(rows).Should(Equal([][]string{
+			chargebackDiffCSVHeader,
+			{"tag", "db", "", "40.00", "60.00", "20.00"},
+			{"tag", "frontend", "", "20.00", "0.00", "-20.00"},
+			{"tag", "web", "", "60.00", "40.00", "-20.00"},
+		}))
+	})
+
+	It("prices VMs and disks without cost by their flavor", func() {
+		server.SetResponseJsonForPath(rootUrl+"/projects/p2/vms", 200, &VMs{Items: []VM{
+			{ID: "vm3", Name: "db-1", Flavor: "vm-large"},
+			{ID: "vm4", Name: "db-2", Flavor: "vm-gone"},
+		}})
+		server.SetResponseJsonForPath(rootUrl+"/projects/p2/disks", 200, &DiskList{Items: []PersistentDisk{
+			{ID: "d2", Name: "db-data", Flavor: "disk-ssd"},
+		}})
+		server.SetResponseJsonForPath(rootUrl+"/flavors", 200, &FlavorList{Items: []Flavor{
+			{Name: "vm-large", Kind: VmFlavorKind, Cost: []QuotaLineItem{{"COUNT", 8, "vm.cpu"}}},
+			{Name: "disk-ssd", Kind: PersistentDiskKind, Cost: []QuotaLineItem{{"GB", 50, "persistent-disk.capacity"}}},
+			{Name: "disk-ssd", Kind: EphemeralDiskKind, Cost: []QuotaLineItem{{"GB", 1, "ephemeral-disk.capacity"}}},
+		}})
+
+		snapshot, err := GenerateChargebackSnapshot(client, prices, nil)
+		Expect(err).Should(BeNil())
+		byID := map[string]ChargebackResource{}
+		for _, resource := range snapshot.Resources {
+			byID[resource.ID] = resource
+		}
+		Expect(byID["vm3"].Amount).Should(Equal(80.0))
+		Expect(byID["vm4"].Cost).Should(BeEmpty())
+		Expect(byID["vm4"].Amount).Should(BeZero())
+		Expect(byID["d2"].Amount).Should(BeNumerically("~", 5, 1e-9))
+		Expect(byID["vm1"].Amount).Should(Equal(40.0))
+		Expect(server.RequestsFor("GET", rootUrl+"/flavors")).Should(HaveLen(1))
+	})
+
+	It("does not read flavors when every resource reports its cost", func() {
+		_, err := GenerateChargebackSnapshot(client, prices, nil)
+		Expect(err).Should(BeNil())
+		Expect(server.RequestsFor("GET", rootUrl+"/flavors")).Should(BeEmpty())
+	})
+
+	It("leaves everything unpriced without prices", func() {
+		snapshot, err := GenerateChargebackSnapshot(client, nil, nil)
+		Expect(err).Should(BeNil())
+		Expect(snapshot.Resources).Should(HaveLen(4))
+		for _, resource := range snapshot.Resources {
+			Expect(resource.Amount).Should(BeZero())
+			Expect(resource.Unpriced).ShouldNot(BeEmpty())
+		}
+
+		buf := new(bytes.Buffer)
+		Expect(snapshot.WriteCSV(buf, ChargebackGroupByTenant)).Should(Succeed())
+		Expect(buf.String()).Should(ContainSubstring("tenant,t1,acme,,3,0.00,\n"))
+	})
+
+	It("fails on prices in units the cost cannot be converted to", func() {
+		prices.Prices[0].Unit = "GB"
+		_, err := GenerateChargebackSnapshot(client, prices, nil)
+		Expect(err).ShouldNot(BeNil())
+		Expect(err.Error()).Should(ContainSubstring("vm.cpu"))
+	})
+})
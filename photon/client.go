@@ -17,6 +17,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
 )
 
 // Represents stateless context needed to call photon APIs.
@@ -44,6 +46,9 @@ type Client struct {
 	Zones      *ZonesAPI
 	Infra      *InfraAPI
 	InfraHosts *InfraHostsAPI
+	Iam        *IamAPI
+	trust      *trustBootstrap
+	login      *credentialLogin
 }
 
 // Represents Tokens
@@ -73,6 +78,18 @@ type ClientOptions struct {
 	// nil by default.
 	RootCAs *x509.CertPool
 
+	// When set, the auth server root certificates are downloaded before the
+	// first API call and verified against these fingerprints or TOFU store.
+	// The verified certificates are then used to validate all connections to
+	// photon and the auth server, replacing IgnoreCertificate and RootCAs.
+	// nil by default.
+	TrustOptions *lightwave.CertTrustOptions
+
+	// Endpoint of the auth server, e.g. "https://lightwave.example.com:443".
+	// Only needed when the auth server is reached through a different host
+	// than the one reported by the system auth info. Empty by default.
+	AuthEndpoint string
+
 	// For tasks APIs, defines the delay between each polling attempt.
 	// Default is 100 milliseconds.
 	TaskPollDelay time.Duration
@@ -88,6 +105,12 @@ type ClientOptions struct {
 	// The client can save the new access token for future API
 	// calls so that it doesn't need to be refreshed again.
 	UpdateAccessTokenCallback TokenCallback
+
+	// When set, the client logs in with credentials from this provider
+	// before the first API call if TokenOptions has no access token, and
+	// again whenever the access token expires and cannot be refreshed.
+	// nil by default.
+	CredentialProvider CredentialProvider
 }
 
 // Creates a new photon client with specified options. If options
@@ -120,6 +143,14 @@ func NewClient(endpoint string, options *ClientOptions, logger *log.Logger) (c *
 		}
 		defaultOptions.IgnoreCertificate = options.IgnoreCertificate
 		defaultOptions.UpdateAccessTokenCallback = options.UpdateAccessTokenCallback
+		defaultOptions.TrustOptions = options.TrustOptions
+		defaultOptions.AuthEndpoint = options.AuthEndpoint
+		defaultOptions.CredentialProvider = options.CredentialProvider
+	}
+
+	if defaultOptions.TrustOptions != nil {
+		// Certificates are always verified once trust has been bootstrapped.
+		defaultOptions.IgnoreCertificate = false
 	}
 
 	if logger == nil {
@@ -162,7 +193,7 @@ func NewClient(endpoint string, options *ClientOptions, logger *log.Logger) (c *
 	c.Hosts = &HostsAPI{c}
 	c.Datastores = &DatastoresAPI{c}
 	c.Services = &ServicesAPI{c}
-	c.Auth = &AuthAPI{c}
+	c.Auth = &AuthAPI{client: c}
 	c.Info = &InfoAPI{c}
 	c.Routers = &RoutersAPI{c}
 	c.Networks = &NetworksAPI{c}
@@ -171,10 +202,22 @@ func NewClient(endpoint string, options *ClientOptions, logger *log.Logger) (c *
 	c.Zones = &ZonesAPI{c}
 	c.Infra = &InfraAPI{c}
 	c.InfraHosts = &InfraHostsAPI{c}
+	c.Iam = &IamAPI{c}
 
 	// Tell the restClient about the Auth API so it can request new
 	// acces tokens when they expire
 	restClient.Auth = c.Auth
+
+	if c.options.TrustOptions != nil {
+		c.trust = &trustBootstrap{}
+		restClient.TrustBootstrap = c.bootstrapTrust
+	}
+
+	if c.options.CredentialProvider != nil {
+		c.login = &credentialLogin{}
+		restClient.Login = c.loginWithCredentials
+		restClient.TokenLock = &c.login.lock
+	}
 	return
 }
 
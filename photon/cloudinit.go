@@ -0,0 +1,215 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Volume label cloud-init looks for to find a NoCloud seed.
+const NoCloudLabel string = "cidata"
+
+// Name of the seed image when attached to a VM.
+const NoCloudISOName string = "cidata.iso"
+
+// Contents of a cloud-init NoCloud seed image. The meta-data is required;
+// user-data is either a cloud-config or raw, e.g. a shell script.
+type NoCloudSeed struct {
+	MetaData      CloudInitMetaData
+	UserData      *CloudConfig
+	RawUserData   []byte
+	NetworkConfig *CloudInitNetworkConfig
+
+	// Modification time recorded in the image, now if not set.
+	ModTime time.Time
+}
+
+type CloudInitMetaData struct {
+	InstanceID    string   `yaml:"instance-id"`
+	LocalHostname string   `yaml:"local-hostname,omitempty"`
+	PublicKeys    []string `yaml:"public-keys,omitempty"`
+}
+
+// A #cloud-config user-data document. Keys without a field here can be set
+// in Extra.
+type CloudConfig struct {
+	Hostname          string                 `yaml:"hostname,omitempty"`
+	FQDN              string                 `yaml:"fqdn,omitempty"`
+	Users             []CloudConfigUser      `yaml:"users,omitempty"`
+	SSHAuthorizedKeys []string               `yaml:"ssh_authorized_keys,omitempty"`
+	Packages          []string               `yaml:"packages,omitempty"`
+	WriteFiles        []CloudConfigFile      `yaml:"write_files,omitempty"`
+	RunCmd            []string               `yaml:"runcmd,omitempty"`
+	Extra             map[string]interface{} `yaml:",inline"`
+}
+
+type CloudConfigUser struct {
+	Name              string   `yaml:"name"`
+	Groups            string   `yaml:"groups,omitempty"`
+	Shell             string   `yaml:"shell,omitempty"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	LockPasswd        *bool    `yaml:"lock_passwd,omitempty"`
+	PasswdHash        string   `yaml:"passwd,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type CloudConfigFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+}
+
+// A version 2 network-config document.
+type CloudInitNetworkConfig struct {
+	Version   int                           `yaml:"version"`
+	Ethernets map[string]*CloudInitEthernet `yaml:"ethernets"`
+}
+
+type CloudInitEthernet struct {
+	Match       *CloudInitMatch       `yaml:"match,omitempty"`
+	SetName     string                `yaml:"set-name,omitempty"`
+	DHCP4       bool                  `yaml:"dhcp4,omitempty"`
+	Addresses   []string              `yaml:"addresses,omitempty"`
+	Gateway4    string                `yaml:"gateway4,omitempty"`
+	Nameservers *CloudInitNameservers `yaml:"nameservers,omitempty"`
+}
+
+type CloudInitMatch struct {
+	MacAddress string `yaml:"macaddress,omitempty"`
+	Name       string `yaml:"name,omitempty"`
+}
+
+type CloudInitNameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+// Returns the files of the seed, named as cloud-init expects them.
+func (seed *NoCloudSeed) files() (files []isoFile, err error) {
+	if seed.MetaData.InstanceID == "" {
+		return nil, errors.New("photon: NoCloud meta-data needs an instance ID")
+	}
+	if seed.UserData != nil && seed.RawUserData != nil {
+		return nil, errors.New("photon: Set either cloud-config or raw user-data, not both")
+	}
+
+	metaData, err := yaml.Marshal(&seed.MetaData)
+	if err != nil {
+		return
+	}
+	userData := seed.RawUserData
+	if seed.UserData != nil {
+		var config []byte
+		config, err = yaml.Marshal(seed.UserData)
+		if err != nil {
+			return
+		}
+		userData = append([]byte("#cloud-config\n"), config...)
+	}
+	files = []isoFile{{"meta-data", metaData}, {"user-data", userData}}
+
+	if seed.NetworkConfig != nil {
+		var networkConfig []byte
+		networkConfig, err = yaml.Marshal(seed.NetworkConfig)
+		if err != nil {
+			return
+		}
+		files = append(files, isoFile{"network-config", networkConfig})
+	}
+	return
+}
+
+// Builds the seed image. The image is small and kept in memory, so it can be
+// passed to VmAPI.AttachISO as it is.
+func (seed *NoCloudSeed) ISO() (image *bytes.Reader, err error) {
+	files, err := seed.files()
+	if err != nil {
+		return
+	}
+	modTime := seed.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	data, err := buildISO(NoCloudLabel, files, modTime)
+	if err != nil {
+		return
+	}
+	return bytes.NewReader(data), nil
+}
+
+// Builds the seed image and attaches it to the VM as its CD-ROM.
+func (api *VmAPI) AttachCloudInit(id string, seed *NoCloudSeed) (task *Task, err error) {
+	image, err := seed.ISO()
+	if err != nil {
+		return
+	}
+	return api.AttachISO(id, image, NoCloudISOName)
+}
+
+// Creates a VM, attaches the cloud-init seed and starts it, waiting for each
+// task. If creating, attaching or starting fails, a VM that was created is
+// deleted again.
+func (api *ProjectsAPI) CreateVMWithCloudInit(projectID string, spec *VmCreateSpec, seed *NoCloudSeed) (vm *VM, err error) {
+	// Fail on a bad seed before creating anything.
+	image, err := seed.ISO()
+	if err != nil {
+		return
+	}
+
+	task, err := api.CreateVM(projectID, spec)
+	if err != nil {
+		return
+	}
+	task, err = api.client.Tasks.Wait(task.ID)
+	if err != nil {
+		// A failed task may still have created the VM.
+		if task != nil && task.Entity.ID != "" {
+			api.deleteVM(task.Entity.ID)
+		}
+		return nil, err
+	}
+	id := task.Entity.ID
+
+	err = api.attachAndStart(id, image)
+	if err != nil {
+		api.deleteVM(id)
+		return nil, err
+	}
+	return api.client.VMs.Get(id)
+}
+
+// Deletes a VM left over by a failed creation, waiting for the delete.
+func (api *ProjectsAPI) deleteVM(id string) {
+	if task, err := api.client.VMs.Delete(id); err == nil {
+		api.client.Tasks.Wait(task.ID)
+	}
+}
+
+func (api *ProjectsAPI) attachAndStart(id string, image *bytes.Reader) (err error) {
+	task, err := api.client.VMs.AttachISO(id, image, NoCloudISOName)
+	if err != nil {
+		return
+	}
+	_, err = api.client.Tasks.Wait(task.ID)
+	if err != nil {
+		return
+	}
+	task, err = api.client.VMs.Start(id)
+	if err != nil {
+		return
+	}
+	_, err = api.client.Tasks.Wait(task.ID)
+	return
+}
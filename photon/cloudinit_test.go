@@ -0,0 +1,246 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+// Reads the root directory of an image through the volume descriptor in the
+// given sector, returning the file contents by name. Rock Ridge names are used
+// if present, Joliet names are decoded for the Joliet descriptor.
+func readTestISO(image []byte, descriptorSector int) (label string, files map[string]string) {
+	descriptor := image[descriptorSector*isoSectorSize:]
+	joliet := descriptor[0] == 2
+	decode := func(b []byte) string {
+		if !joliet {
+			return string(b)
+		}
+		units := make([]uint16, len(b)/2)
+		for idx := range units {
+			units[idx] = binary.BigEndian.Uint16(b[2*idx:])
+		}
+		return string(utf16.Decode(units))
+	}
+
+	label = strings.TrimSpace(decode(descriptor[40:72]))
+	rootRecord := descriptor[156:]
+	rootSector := binary.LittleEndian.Uint32(rootRecord[2:])
+	rootSize := binary.LittleEndian.Uint32(rootRecord[10:])
+
+	files = map[string]string{}
+	dir := image[int(rootSector)*isoSectorSize : int(rootSector)*isoSectorSize+int(rootSize)]
+	for pos := 0; pos < len(dir); {
+		length := int(dir[pos])
+		if length == 0 {
+			pos = (pos/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		record := dir[pos : pos+length]
+		pos += length
+		if record[25]&2 != 0 {
+			continue
+		}
+
+		identLen := int(record[32])
+		name := decode(record[33 : 33+identLen])
+		systemUse := record[33+identLen+(1-identLen%2):]
+		for len(systemUse) >= 4 && systemUse[2] > 0 {
+			if string(systemUse[:2]) == "NM" {
+				name = string(systemUse[5:systemUse[2]])
+			}
+			systemUse = systemUse[systemUse[2]:]
+		}
+
+		extent := binary.LittleEndian.Uint32(record[2:])
+		size := binary.LittleEndian.Uint32(record[10:])
+		files[name] = string(image[int(extent)*isoSectorSize : int(extent)*isoSectorSize+int(size)])
+	}
+	return
+}
+
+var _ = Describe("CloudInit", func() {
+	var seed *NoCloudSeed
+
+	BeforeEach(func() {
+		lockPasswd := true
+		seed = &NoCloudSeed{
+			MetaData: CloudInitMetaData{InstanceID: "iid-web-1", LocalHostname: "web"},
+			UserData: &CloudConfig{
+				Hostname: "web",
+				Users: []CloudConfigUser{{
+					Name:              "deploy",
+					Sudo:              "ALL=(ALL) NOPASSWD:ALL",
+					LockPasswd:        &lockPasswd,
+					SSHAuthorizedKeys: []string{"ssh-rsa AAAA deploy@example.com"},
+				}},
+				RunCmd: []string{"systemctl enable docker"},
+				Extra:  map[string]interface{}{"package_upgrade": true},
+			},
+			NetworkConfig: &CloudInitNetworkConfig{
+				Version: 2,
+				Ethernets: map[string]*CloudInitEthernet{
+					"eth0": {Match: &CloudInitMatch{MacAddress: "00:50:56:00:00:01"}, DHCP4: true},
+				},
+			},
+			ModTime: time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+		}
+	})
+
+	Describe("ISO", func() {
+		var expected map[string]string
+
+		BeforeEach(func() {
+			expected = map[string]string{
+				"meta-data": "instance-id: iid-web-1\nlocal-hostname: web\n",
+				"user-data": "#cloud-config\n" +
+					"hostname: web\n" +
+					"users:\n" +
+					"- name: deploy\n" +
+					"  sudo: ALL=(ALL) NOPASSWD:ALL\n" +
+					"  lock_passwd: true\n" +
+					"  ssh_authorized_keys:\n" +
+					"  - ssh-rsa AAAA deploy@example.com\n" +
+					"runcmd:\n" +
+					"- systemctl enable docker\n" +
+					"package_upgrade: true\n",
+				"network-config": "version: 2\n" +
+					"ethernets:\n" +
+					"  eth0:\n" +
+					"    match:\n" +
+					"      macaddress: \"00:50:56:00:00:01\"\n" +
+					"    dhcp4: true\n",
+			}
+		})
+
+		It("has the cidata label and the seed files under their Rock Ridge names", func() {
+			reader, err := seed.ISO()
+			Expect(err).Should(BeNil())
+			image, _ := ioutil.ReadAll(reader)
+			Expect(len(image) % isoSectorSize).Should(Equal(0))
+			Expect(string(image[16*isoSectorSize+1 : 16*isoSectorSize+6])).Should(Equal("CD001"))
+
+			label, files := readTestISO(image, 16)
+			Expect(label).Should(Equal(NoCloudLabel))
+			Expect(files).Should(Equal(expected))
+		})
+
+		It("has the seed files under their Joliet names", func() {
+			reader, err := seed.ISO()
+			Expect(err).Should(BeNil())
+			image, _ := ioutil.ReadAll(reader)
+
+			label, files := readTestISO(image, 17)
+			Expect(label).Should(Equal(NoCloudLabel))
+			Expect(files).Should(Equal(expected))
+		})
+
+		It("uses raw user-data as it is", func() {
+			seed.UserData = nil
+			seed.RawUserData = []byte("#!/bin/sh\necho hello\n")
+			seed.NetworkConfig = nil
+			reader, err := seed.ISO()
+			Expect(err).Should(BeNil())
+			image, _ := ioutil.ReadAll(reader)
+
+			_, files := readTestISO(image, 17)
+			Expect(files).Should(HaveLen(2))
+			Expect(files["user-data"]).Should(Equal("#!/bin/sh\necho hello\n"))
+		})
+
+		It("requires an instance ID", func() {
+			seed.MetaData.InstanceID = ""
+			_, err := seed.ISO()
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		It("makes unique primary names", func() {
+			names := isoPrimaryNames([]isoFile{{name: "user-data"}, {name: "user-data.txt"}, {name: "user_data"}})
+			Expect(names).Should(Equal([]string{"USER_DAT.;1", "USER_DAT.TXT;1", "USER_DA1.;1"}))
+		})
+	})
+
+	Describe("CreateVMWithCloudInit", func() {
+		var (
+			server *mocks.Server
+			client *Client
+		)
+
+		task := func(id string, state string) *Task {
+			return &Task{ID: id, State: state, Entity: Entity{ID: "vm1", Kind: EntityKindVm}}
+		}
+
+		BeforeEach(func() {
+			if isIntegrationTest() {
+				Skip("Skipping cloud-init VM creation test on integration mode.")
+			}
+
+			server, client = mockServerClient()
+			server.SetResponseJson(200, task("delete-task", "COMPLETED"))
+			server.SetResponseJsonForPath(rootUrl+"/projects/p1/vms", 200, task("create-task", "QUEUED"))
+			server.SetResponseJsonForPath(rootUrl+"/tasks/create-task", 200, task("create-task", "COMPLETED"))
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm1", 200, &VM{ID: "vm1", State: VmStateStarted})
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm1/attach_iso", 200, task("attach-task", "QUEUED"))
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm1/start", 200, task("start-task", "QUEUED"))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		calls := func() (calls []string) {
+			for _, r := range server.Requests() {
+				if !strings.HasPrefix(r.Path, rootUrl+"/tasks/") {
+					calls = append(calls, r.Method+" "+strings.TrimPrefix(r.Path, rootUrl))
+				}
+			}
+			return
+		}
+
+		It("creates, attaches the seed and starts", func() {
+			vm, err := client.Projects.CreateVMWithCloudInit("p1", &VmCreateSpec{Name: "web"}, seed)
+			Expect(err).Should(BeNil())
+			Expect(vm.ID).Should(Equal("vm1"))
+			Expect(calls()).Should(Equal([]string{
+				"POST /projects/p1/vms", "POST /vms/vm1/attach_iso", "POST /vms/vm1/start", "GET /vms/vm1"}))
+
+			uploads := server.RequestsFor("POST", rootUrl+"/vms/vm1/attach_iso")
+			Expect(uploads).Should(HaveLen(1))
+			Expect(uploads[0].Body).Should(ContainSubstring(`filename="cidata.iso"`))
+			image, _ := seed.ISO()
+			data, _ := ioutil.ReadAll(image)
+			Expect(bytes.Contains([]byte(uploads[0].Body), data)).Should(BeTrue())
+		})
+
+		It("deletes the VM if it cannot be started", func() {
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm1/start", 400, &ApiError{Code: "InvalidVmState"})
+			_, err := client.Projects.CreateVMWithCloudInit("p1", &VmCreateSpec{Name: "web"}, seed)
+			Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+			calls := calls()
+			Expect(calls[len(calls)-1]).Should(Equal("DELETE /vms/vm1"))
+		})
+
+		It("deletes the VM if the create task fails after creating it", func() {
+			server.SetResponseJsonForPath(rootUrl+"/tasks/create-task", 200, task("create-task", "ERROR"))
+			_, err := client.Projects.CreateVMWithCloudInit("p1", &VmCreateSpec{Name: "web"}, seed)
+			Expect(err).Should(BeAssignableToTypeOf(TaskError{}))
+			Expect(calls()).Should(Equal([]string{"POST /projects/p1/vms", "DELETE /vms/vm1"}))
+		})
+	})
+})
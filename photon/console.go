@@ -0,0 +1,318 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ticket for the console of a VM, the resource properties of the
+// GET_MKS_TICKET task. A ticket is short lived and usually good for one
+// connection only.
+type MksTicket struct {
+	// Host running the VM.
+	Host string `json:"host"`
+
+	// Port of the native MKS protocol on the host.
+	Port    int    `json:"port"`
+	CfgFile string `json:"cfgFile"`
+	Ticket  string `json:"ticket"`
+
+	// SHA-1 thumbprint of the host's certificate, e.g. "AB:CD:...".
+	SslThumbprint string `json:"sslThumbprint"`
+}
+
+// Options for connecting to a console.
+type ConsoleOptions struct {
+	// Local address the proxy listens on, "127.0.0.1:0" if not set.
+	ListenAddress string
+
+	// Port of the WebMKS endpoint on the host, 443 if not set.
+	Port int
+
+	// How long to wait for the host to accept the connection, 30 seconds if
+	// not set.
+	DialTimeout time.Duration
+
+	// Don't check the host's certificate against the ticket's thumbprint.
+	InsecureSkipVerify bool
+
+	// Where to log failed console connections, nowhere if not set.
+	Logger *log.Logger
+}
+
+// Gets a console ticket for a VM, waiting for the GET_MKS_TICKET task.
+func (api *VmAPI) GetMksTicketInfo(id string) (ticket *MksTicket, err error) {
+	task, err := api.GetMKSTicket(id)
+	if err != nil {
+		return
+	}
+	task, err = api.client.Tasks.Wait(task.ID)
+	if err != nil {
+		return
+	}
+
+	// The resource properties are decoded generically, so convert them back.
+	data, err := json.Marshal(task.ResourceProperties)
+	if err != nil {
+		return
+	}
+	ticket = &MksTicket{}
+	err = json.Unmarshal(data, ticket)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Connects to the WebMKS endpoint of the ticket's host. The connection carries
+// the console as a VNC (RFB) stream.
+func DialConsole(ticket *MksTicket, options *ConsoleOptions) (conn io.ReadWriteCloser, err error) {
+	options = consoleDefaults(options)
+	if ticket.SslThumbprint == "" && !options.InsecureSkipVerify {
+		return nil, errors.New("photon: MKS ticket has no SSL thumbprint to verify the host with")
+	}
+
+	address := net.JoinHostPort(ticket.Host, strconv.Itoa(options.Port))
+	dialer := &net.Dialer{Timeout: options.DialTimeout}
+	// Hosts have self-signed certificates, so they are verified by thumbprint
+	// instead of by chain.
+	tlsConn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	if !options.InsecureSkipVerify {
+		err = verifyThumbprint(tlsConn, ticket.SslThumbprint)
+		if err != nil {
+			tlsConn.Close()
+			return
+		}
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(options.DialTimeout))
+	ws, err := dialWebSocket(tlsConn, address, "/ticket/"+ticket.Ticket, "binary")
+	if err != nil {
+		tlsConn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+func verifyThumbprint(conn *tls.Conn, expected string) error {
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return errors.New("photon: Host sent no certificate")
+	}
+	hash := sha1.Sum(certificates[0].Raw)
+	actual := hex.EncodeToString(hash[:])
+	if actual != strings.ToLower(strings.Replace(expected, ":", "", -1)) {
+		return fmt.Errorf("photon: Host certificate thumbprint %s does not match MKS ticket", actual)
+	}
+	return nil
+}
+
+func consoleDefaults(options *ConsoleOptions) *ConsoleOptions {
+	result := ConsoleOptions{}
+	if options != nil {
+		result = *options
+	}
+	if result.ListenAddress == "" {
+		result.ListenAddress = "127.0.0.1:0"
+	}
+	if result.Port == 0 {
+		result.Port = 443
+	}
+	if result.DialTimeout == 0 {
+		result.DialTimeout = 30 * time.Second
+	}
+	if result.Logger == nil {
+		result.Logger = createPassThroughLogger()
+	}
+	return &result
+}
+
+// Local TCP proxy to a VM console. Each connection to Addr is forwarded to the
+// host's WebMKS endpoint, so a VNC client pointed at Addr shows the console.
+type ConsoleProxy struct {
+	listener net.Listener
+	options  *ConsoleOptions
+	tickets  func() (*MksTicket, error)
+
+	lock   sync.Mutex
+	conns  map[io.Closer]bool
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Starts a console proxy using the given ticket for every connection. As hosts
+// usually accept a ticket once, use VmAPI.OpenConsole to be able to reconnect.
+func NewConsoleProxy(ticket *MksTicket, options *ConsoleOptions) (proxy *ConsoleProxy, err error) {
+	return newConsoleProxy(func() (*MksTicket, error) { return ticket, nil }, options)
+}
+
+// Starts a console proxy for a VM, getting a new ticket for every connection.
+func (api *VmAPI) OpenConsole(id string, options *ConsoleOptions) (proxy *ConsoleProxy, err error) {
+	// Get the first ticket now, so a VM without a console fails here.
+	first, err := api.GetMksTicketInfo(id)
+	if err != nil {
+		return
+	}
+	var lock sync.Mutex
+	tickets := func() (*MksTicket, error) {
+		lock.Lock()
+		ticket := first
+		first = nil
+		lock.Unlock()
+		if ticket != nil {
+			return ticket, nil
+		}
+		return api.GetMksTicketInfo(id)
+	}
+
+	if options == nil || options.Logger == nil {
+		withLogger := ConsoleOptions{}
+		if options != nil {
+			withLogger = *options
+		}
+		withLogger.Logger = api.client.logger
+		options = &withLogger
+	}
+	return newConsoleProxy(tickets, options)
+}
+
+func newConsoleProxy(tickets func() (*MksTicket, error), options *ConsoleOptions) (proxy *ConsoleProxy, err error) {
+	options = consoleDefaults(options)
+	listener, err := net.Listen("tcp", options.ListenAddress)
+	if err != nil {
+		return
+	}
+	proxy = &ConsoleProxy{
+		listener: listener,
+		options:  options,
+		tickets:  tickets,
+		conns:    map[io.Closer]bool{},
+	}
+	proxy.wg.Add(1)
+	go proxy.serve()
+	return
+}
+
+// Local address to point a VNC client at.
+func (proxy *ConsoleProxy) Addr() string {
+	return proxy.listener.Addr().String()
+}
+
+// Stops listening and closes all console connections.
+func (proxy *ConsoleProxy) Close() (err error) {
+	proxy.lock.Lock()
+	if proxy.closed {
+		proxy.lock.Unlock()
+		return
+	}
+	proxy.closed = true
+	err = proxy.listener.Close()
+	for conn := range proxy.conns {
+		conn.Close()
+	}
+	proxy.lock.Unlock()
+
+	proxy.wg.Wait()
+	return
+}
+
+func (proxy *ConsoleProxy) serve() {
+	defer proxy.wg.Done()
+	for {
+		local, err := proxy.listener.Accept()
+		if err != nil {
+			return
+		}
+		proxy.lock.Lock()
+		if proxy.closed {
+			proxy.lock.Unlock()
+			local.Close()
+			return
+		}
+		proxy.conns[local] = true
+		proxy.wg.Add(1)
+		proxy.lock.Unlock()
+
+		go proxy.forward(local)
+	}
+}
+
+// Tracks a connection so Close closes it. Returns false if the proxy is closed.
+func (proxy *ConsoleProxy) track(conn io.Closer) bool {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	if proxy.closed {
+		return false
+	}
+	proxy.conns[conn] = true
+	return true
+}
+
+func (proxy *ConsoleProxy) untrack(conn io.Closer) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	delete(proxy.conns, conn)
+}
+
+func (proxy *ConsoleProxy) forward(local net.Conn) {
+	defer proxy.wg.Done()
+	defer proxy.untrack(local)
+	defer local.Close()
+
+	ticket, err := proxy.tickets()
+	if err != nil {
+		proxy.options.Logger.Printf("Could not get an MKS ticket for console connection from %s. Error: %s",
+			local.RemoteAddr(), err)
+		return
+	}
+	remote, err := DialConsole(ticket, proxy.options)
+	if err != nil {
+		proxy.options.Logger.Printf("Could not connect console connection from %s to %s. Error: %s",
+			local.RemoteAddr(), ticket.Host, err)
+		return
+	}
+	if !proxy.track(remote) {
+		remote.Close()
+		return
+	}
+	defer proxy.untrack(remote)
+
+	// Copy both ways until either side is done, then close both.
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- true
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- true
+	}()
+	<-done
+	remote.Close()
+	local.Close()
+	<-done
+}
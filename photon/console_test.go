@@ -0,0 +1,252 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const rfbBanner = "RFB 003.008\n"
+
+// Stands in for the WebMKS endpoint of a host: accepts tickets it knows, sends
+// an RFB banner and echoes everything after it.
+type fakeMksHost struct {
+	server  *httptest.Server
+	lock    sync.Mutex
+	tickets map[string]bool
+	used    []string
+}
+
+func newFakeMksHost(tickets ...string) *fakeMksHost {
+	host := &fakeMksHost{tickets: map[string]bool{}}
+	for _, ticket := range tickets {
+		host.tickets[ticket] = true
+	}
+	host.server = httptest.NewTLSServer(http.HandlerFunc(host.serve))
+	return host
+}
+
+func (host *fakeMksHost) serve(w http.ResponseWriter, r *http.Request) {
+	ticket := strings.TrimPrefix(r.URL.Path, "/ticket/")
+	host.lock.Lock()
+	valid := host.tickets[ticket]
+	delete(host.tickets, ticket)
+	host.used = append(host.used, ticket)
+	host.lock.Unlock()
+
+	if !valid || r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Protocol") != "binary" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	conn, buffer, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(buffer, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Protocol: binary\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	buffer.Flush()
+
+	ws := &wsConn{conn: conn, reader: buffer.Reader}
+	defer ws.Close()
+	ws.writeFrame(wsOpPing, []byte("ping"))
+	ws.Write([]byte(rfbBanner))
+	io.Copy(ws, ws)
+}
+
+func (host *fakeMksHost) usedTickets() []string {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return append([]string{}, host.used...)
+}
+
+func (host *fakeMksHost) ticket(ticket string) *MksTicket {
+	hash := sha1.Sum(host.server.TLS.Certificates[0].Certificate[0])
+	thumbprint := []string{}
+	for _, b := range hash {
+		thumbprint = append(thumbprint, fmt.Sprintf("%02X", b))
+	}
+	address, _ := url.Parse(host.server.URL)
+	hostname, _, _ := net.SplitHostPort(address.Host)
+	return &MksTicket{Host: hostname, Port: 902, Ticket: ticket, SslThumbprint: strings.Join(thumbprint, ":")}
+}
+
+func (host *fakeMksHost) options() *ConsoleOptions {
+	address, _ := url.Parse(host.server.URL)
+	_, port, _ := net.SplitHostPort(address.Host)
+	number, _ := strconv.Atoi(port)
+	return &ConsoleOptions{Port: number, DialTimeout: 5 * time.Second}
+}
+
+// Connects to a console proxy and reads the RFB banner.
+func readConsoleBanner(address string) (conn net.Conn, banner string, err error) {
+	conn, err = net.Dial("tcp", address)
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	data := make([]byte, len(rfbBanner))
+	_, err = io.ReadFull(conn, data)
+	return conn, string(data), err
+}
+
+var _ = Describe("Console", func() {
+	var host *fakeMksHost
+
+	BeforeEach(func() {
+		host = newFakeMksHost("t1", "t2")
+	})
+
+	AfterEach(func() {
+		host.server.Close()
+	})
+
+	Describe("DialConsole", func() {
+		It("connects with a valid ticket and thumbprint", func() {
+			conn, err := DialConsole(host.ticket("t1"), host.options())
+			Expect(err).Should(BeNil())
+			defer conn.Close()
+
+			data := make([]byte, len(rfbBanner))
+			_, err = io.ReadFull(conn, data)
+			Expect(err).Should(BeNil())
+			Expect(string(data)).Should(Equal(rfbBanner))
+
+			payload := strings.Repeat("x", 70000)
+			go conn.Write([]byte(payload))
+			echo := make([]byte, len(payload))
+			_, err = io.ReadFull(conn, echo)
+			Expect(err).Should(BeNil())
+			Expect(string(echo)).Should(Equal(payload))
+		})
+
+		It("rejects a host with another certificate", func() {
+			ticket := host.ticket("t1")
+			ticket.SslThumbprint = strings.Repeat("00:", 19) + "00"
+			_, err := DialConsole(ticket, host.options())
+			Expect(err).ShouldNot(BeNil())
+			Expect(err.Error()).Should(ContainSubstring("thumbprint"))
+			Expect(host.usedTickets()).Should(BeEmpty())
+		})
+
+		It("fails when the host refuses the ticket", func() {
+			_, err := DialConsole(host.ticket("unknown"), host.options())
+			Expect(err).ShouldNot(BeNil())
+			Expect(err.Error()).Should(ContainSubstring("403"))
+		})
+	})
+
+	Describe("ConsoleProxy", func() {
+		It("forwards a local connection to the console", func() {
+			proxy, err := NewConsoleProxy(host.ticket("t1"), host.options())
+			Expect(err).Should(BeNil())
+			defer proxy.Close()
+			Expect(proxy.Addr()).Should(HavePrefix("127.0.0.1:"))
+
+			conn, banner, err := readConsoleBanner(proxy.Addr())
+			Expect(err).Should(BeNil())
+			defer conn.Close()
+			Expect(banner).Should(Equal(rfbBanner))
+
+			conn.Write([]byte("hello"))
+			echo := make([]byte, 5)
+			_, err = io.ReadFull(conn, echo)
+			Expect(err).Should(BeNil())
+			Expect(string(echo)).Should(Equal("hello"))
+		})
+
+		It("closes local connections when closed", func() {
+			proxy, err := NewConsoleProxy(host.ticket("t1"), host.options())
+			Expect(err).Should(BeNil())
+			conn, _, err := readConsoleBanner(proxy.Addr())
+			Expect(err).Should(BeNil())
+			defer conn.Close()
+
+			Expect(proxy.Close()).Should(BeNil())
+			_, err = conn.Read(make([]byte, 1))
+			Expect(err).Should(Equal(io.EOF))
+		})
+
+		It("gets a new ticket for every connection when opened for a VM", func() {
+			server, client := mockServerClient()
+			defer server.Close()
+			// Every read of the task gives the next ticket, the last one repeated.
+			tasks := []interface{}{}
+			for _, ticket := range []string{"t1", "t2", "t3"} {
+				tasks = append(tasks, &Task{
+					ID:                 "mks-task",
+					State:              "COMPLETED",
+					Operation:          "GET_MKS_TICKET",
+					ResourceProperties: host.ticket(ticket),
+				})
+			}
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm1/mks_ticket", 200, &Task{ID: "mks-task", State: "QUEUED"})
+			server.SetResponsesJsonForPath(rootUrl+"/tasks/mks-task", 200, tasks...)
+
+			proxy, err := client.VMs.OpenConsole("vm1", host.options())
+			Expect(err).Should(BeNil())
+			defer proxy.Close()
+			Expect(server.RequestsFor("GET", rootUrl+"/vms/vm1/mks_ticket")).Should(HaveLen(1))
+
+			for i := 0; i < 2; i++ {
+				conn, banner, err := readConsoleBanner(proxy.Addr())
+				Expect(err).Should(BeNil())
+				Expect(banner).Should(Equal(rfbBanner))
+				conn.Close()
+			}
+			Expect(host.usedTickets()).Should(Equal([]string{"t1", "t2"}))
+			Expect(server.RequestsFor("GET", rootUrl+"/vms/vm1/mks_ticket")).Should(HaveLen(2))
+		})
+	})
+
+	Describe("GetMksTicketInfo", func() {
+		It("decodes the ticket from the task", func() {
+			server, client := mockServerClient()
+			defer server.Close()
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm1/mks_ticket", 200, &Task{ID: "mks-task", State: "QUEUED"})
+			server.SetResponseJsonForPath(rootUrl+"/tasks/mks-task", 200, &Task{
+				ID:    "mks-task",
+				State: "COMPLETED",
+				ResourceProperties: map[string]interface{}{
+					"host":          "esx-1",
+					"port":          902,
+					"cfgFile":       "/vmfs/volumes/ds1/vm1/vm1.vmx",
+					"ticket":        "52a1",
+					"sslThumbprint": "AB:CD",
+				},
+			})
+
+			ticket, err := client.VMs.GetMksTicketInfo("vm1")
+			Expect(err).Should(BeNil())
+			Expect(ticket).Should(Equal(&MksTicket{
+				Host:          "esx-1",
+				Port:          902,
+				CfgFile:       "/vmfs/volumes/ds1/vm1/vm1.vmx",
+				Ticket:        "52a1",
+				SslThumbprint: "AB:CD",
+			}))
+		})
+	})
+})
@@ -0,0 +1,312 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Environment variables read by EnvCredentialProvider.
+const (
+	UsernameEnvVar     string = "PHOTON_USERNAME"
+	PasswordEnvVar     string = "PHOTON_PASSWORD"
+	RefreshTokenEnvVar string = "PHOTON_REFRESH_TOKEN"
+	ClientIDEnvVar     string = "PHOTON_CLIENT_ID"
+)
+
+// Credentials used to get tokens from the auth server. Either Username and
+// Password, or RefreshToken must be set. If both are set the password is used.
+type Credentials struct {
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// Optional client ID to request the tokens for.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+func (c *Credentials) validate() error {
+	if c.Username != "" && c.Password != "" {
+		return nil
+	}
+	if c.Username == "" && c.Password == "" && c.RefreshToken != "" {
+		return nil
+	}
+	if c.Username != "" || c.Password != "" {
+		return errors.New("both username and password must be set")
+	}
+	return errors.New("no username, password or refresh token set")
+}
+
+// Provides credentials to log in to the auth server, see ClientOptions.CredentialProvider.
+type CredentialProvider interface {
+	// Returns a short description of the provider used in error messages.
+	Name() string
+
+	// Returns the credentials, or an error saying why there are none.
+	Retrieve() (*Credentials, error)
+}
+
+// Tries a list of providers in order and returns the credentials of the first
+// one that has any.
+type CredentialChain struct {
+	Providers []CredentialProvider
+}
+
+// Returned by CredentialChain when none of the providers had credentials.
+type CredentialChainError struct {
+	// Provider names and the reason each of them failed, in the order tried.
+	Providers []string
+	Errors    []error
+}
+
+func (e CredentialChainError) Error() string {
+	tried := make([]string, len(e.Providers))
+	for idx, name := range e.Providers {
+		tried[idx] = fmt.Sprintf("%s: %v", name, e.Errors[idx])
+	}
+	return fmt.Sprintf("photon: No credentials found, tried: [%s]", strings.Join(tried, "; "))
+}
+
+func NewCredentialChain(providers ...CredentialProvider) *CredentialChain {
+	return &CredentialChain{Providers: providers}
+}
+
+// Returns a chain of an EnvCredentialProvider followed by a FileCredentialProvider
+// for each of the paths.
+func NewDefaultCredentialChain(paths ...string) *CredentialChain {
+	chain := NewCredentialChain(&EnvCredentialProvider{})
+	for _, path := range paths {
+		chain.Providers = append(chain.Providers, &FileCredentialProvider{Path: path})
+	}
+	return chain
+}
+
+func (chain *CredentialChain) Name() string {
+	names := make([]string, len(chain.Providers))
+	for idx, provider := range chain.Providers {
+		names[idx] = provider.Name()
+	}
+	return fmt.Sprintf("chain [%s]", strings.Join(names, ", "))
+}
+
+func (chain *CredentialChain) Retrieve() (credentials *Credentials, err error) {
+	chainErr := CredentialChainError{}
+	for _, provider := range chain.Providers {
+		credentials, err = provider.Retrieve()
+		if err == nil {
+			err = credentials.validate()
+		}
+		if err == nil {
+			return credentials, nil
+		}
+		chainErr.Providers = append(chainErr.Providers, provider.Name())
+		chainErr.Errors = append(chainErr.Errors, err)
+	}
+	return nil, chainErr
+}
+
+// Reads the credentials from the PHOTON_USERNAME, PHOTON_PASSWORD,
+// PHOTON_REFRESH_TOKEN and PHOTON_CLIENT_ID environment variables.
+type EnvCredentialProvider struct{}
+
+func (p *EnvCredentialProvider) Name() string {
+	return "environment"
+}
+
+func (p *EnvCredentialProvider) Retrieve() (credentials *Credentials, err error) {
+	credentials = &Credentials{
+		Username:     os.Getenv(UsernameEnvVar),
+		Password:     os.Getenv(PasswordEnvVar),
+		RefreshToken: os.Getenv(RefreshTokenEnvVar),
+		ClientID:     os.Getenv(ClientIDEnvVar),
+	}
+	err = credentials.validate()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Reads the credentials from a file. The path can be either a JSON file with
+// the fields of Credentials, or a directory, e.g. a mounted secret, with one
+// file per field named username, password, refresh_token and client_id.
+type FileCredentialProvider struct {
+	Path string
+}
+
+func (p *FileCredentialProvider) Name() string {
+	return fmt.Sprintf("file '%s'", p.Path)
+}
+
+func (p *FileCredentialProvider) Retrieve() (credentials *Credentials, err error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return
+	}
+
+	credentials = &Credentials{}
+	if info.IsDir() {
+		fields := map[string]*string{
+			"username":      &credentials.Username,
+			"password":      &credentials.Password,
+			"refresh_token": &credentials.RefreshToken,
+			"client_id":     &credentials.ClientID,
+		}
+		for name, field := range fields {
+			data, err := ioutil.ReadFile(filepath.Join(p.Path, name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			*field = strings.TrimSpace(string(data))
+		}
+	} else {
+		data, err := ioutil.ReadFile(p.Path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, credentials)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = credentials.validate()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Returns fixed credentials.
+type StaticCredentialProvider struct {
+	Credentials Credentials
+}
+
+func (p *StaticCredentialProvider) Name() string {
+	return "static"
+}
+
+func (p *StaticCredentialProvider) Retrieve() (credentials *Credentials, err error) {
+	credentials = &Credentials{}
+	*credentials = p.Credentials
+	return
+}
+
+// Function returning credentials, e.g. by prompting the user.
+type CredentialCallback func() (*Credentials, error)
+
+// Gets the credentials from a callback.
+type CallbackCredentialProvider struct {
+	Callback CredentialCallback
+}
+
+func (p *CallbackCredentialProvider) Name() string {
+	return "callback"
+}
+
+func (p *CallbackCredentialProvider) Retrieve() (credentials *Credentials, err error) {
+	credentials, err = p.Callback()
+	if err == nil && credentials == nil {
+		err = errors.New("no credentials returned")
+	}
+	return
+}
+
+// Login with ClientOptions.CredentialProvider
+
+// Serializes logins so that concurrent requests do not all log in at once.
+// The lock also guards the client's tokens while they are replaced.
+type credentialLogin struct {
+	lock sync.Mutex
+
+	// Set by AuthAPI.Logout, cleared by AuthAPI.Login. While set, requests
+	// are not logged in automatically.
+	loggedOut bool
+}
+
+// Refreshes the access token, or logs in with the credentials from
+// ClientOptions.CredentialProvider if that fails, unless the access token
+// changed since staleToken was read, in which case another request already
+// logged in. Returns the current access token, none after a logout. This is
+// the only place the tokens are replaced while automatic login is on.
+func (c *Client) loginWithCredentials(staleToken string) (accessToken string, err error) {
+	c.login.lock.Lock()
+	defer c.login.lock.Unlock()
+
+	if c.login.loggedOut {
+		return "", nil
+	}
+	current := c.options.TokenOptions
+	if current.AccessToken != staleToken {
+		return current.AccessToken, nil
+	}
+
+	var tokens *TokenOptions
+	if current.RefreshToken != "" {
+		tokens, err = c.Auth.GetTokensByRefreshToken(current.RefreshToken)
+		if err == nil && tokens.RefreshToken == "" {
+			tokens.RefreshToken = current.RefreshToken
+		}
+	}
+	if tokens == nil || err != nil {
+		tokens, err = c.getTokensWithCredentials()
+		if err != nil {
+			return
+		}
+	}
+
+	*current = *tokens
+	if c.options.UpdateAccessTokenCallback != nil {
+		c.options.UpdateAccessTokenCallback(tokens.AccessToken)
+	}
+	return tokens.AccessToken, nil
+}
+
+// Gets tokens with the credentials from ClientOptions.CredentialProvider.
+func (c *Client) getTokensWithCredentials() (tokens *TokenOptions, err error) {
+	provider := c.options.CredentialProvider
+	credentials, err := provider.Retrieve()
+	if err == nil {
+		err = credentials.validate()
+	}
+	if _, ok := err.(CredentialChainError); err != nil && !ok {
+		err = CredentialChainError{[]string{provider.Name()}, []error{err}}
+	}
+	if err != nil {
+		return
+	}
+
+	switch {
+	case credentials.Username == "":
+		tokens, err = c.Auth.GetTokensByRefreshToken(credentials.RefreshToken)
+	case credentials.ClientID != "":
+		tokens, err = c.Auth.GetClientTokensByPassword(credentials.Username, credentials.Password, credentials.ClientID)
+	default:
+		tokens, err = c.Auth.GetTokensByPassword(credentials.Username, credentials.Password)
+	}
+	if err != nil {
+		return
+	}
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = credentials.RefreshToken
+	}
+	return
+}
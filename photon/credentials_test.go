@@ -0,0 +1,267 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
+)
+
+var _ = Describe("Credentials", func() {
+	var tempDir string
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "photon-credentials")
+		Expect(err).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	Describe("EnvCredentialProvider", func() {
+		var saved map[string]string
+
+		BeforeEach(func() {
+			saved = map[string]string{}
+			for _, name := range []string{UsernameEnvVar, PasswordEnvVar, RefreshTokenEnvVar, ClientIDEnvVar} {
+				saved[name] = os.Getenv(name)
+				os.Setenv(name, "")
+			}
+		})
+
+		AfterEach(func() {
+			for name, value := range saved {
+				os.Setenv(name, value)
+			}
+		})
+
+		It("reads username and password", func() {
+			os.Setenv(UsernameEnvVar, "joe")
+			os.Setenv(PasswordEnvVar, "secret")
+			credentials, err := (&EnvCredentialProvider{}).Retrieve()
+			Expect(err).Should(BeNil())
+			Expect(credentials).Should(Equal(&Credentials{Username: "joe", Password: "secret"}))
+		})
+
+		It("reads a refresh token", func() {
+			os.Setenv(RefreshTokenEnvVar, "refresh_token")
+			credentials, err := (&EnvCredentialProvider{}).Retrieve()
+			Expect(err).Should(BeNil())
+			Expect(credentials.RefreshToken).Should(Equal("refresh_token"))
+		})
+
+		It("fails without credentials", func() {
+			credentials, err := (&EnvCredentialProvider{}).Retrieve()
+			Expect(credentials).Should(BeNil())
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Describe("FileCredentialProvider", func() {
+		It("reads a JSON file", func() {
+			path := filepath.Join(tempDir, "credentials.json")
+			err := ioutil.WriteFile(path, []byte(`{"username": "joe", "password": "secret"}`), 0600)
+			Expect(err).Should(BeNil())
+
+			credentials, err := (&FileCredentialProvider{Path: path}).Retrieve()
+			Expect(err).Should(BeNil())
+			Expect(credentials).Should(Equal(&Credentials{Username: "joe", Password: "secret"}))
+		})
+
+		It("reads a secret mount directory", func() {
+			err := ioutil.WriteFile(filepath.Join(tempDir, "refresh_token"), []byte("refresh_token\n"), 0600)
+			Expect(err).Should(BeNil())
+
+			credentials, err := (&FileCredentialProvider{Path: tempDir}).Retrieve()
+			Expect(err).Should(BeNil())
+			Expect(credentials).Should(Equal(&Credentials{RefreshToken: "refresh_token"}))
+		})
+
+		It("fails when the file is missing", func() {
+			credentials, err := (&FileCredentialProvider{Path: filepath.Join(tempDir, "missing")}).Retrieve()
+			Expect(credentials).Should(BeNil())
+			Expect(os.IsNotExist(err)).Should(BeTrue())
+		})
+	})
+
+	Describe("CredentialChain", func() {
+		It("returns the credentials of the first provider that has any", func() {
+			chain := NewCredentialChain(
+				&FileCredentialProvider{Path: filepath.Join(tempDir, "missing")},
+				&StaticCredentialProvider{Credentials{Username: "joe", Password: "secret"}},
+				&CallbackCredentialProvider{func() (*Credentials, error) {
+					Fail("callback should not be called")
+					return nil, nil
+				}})
+
+			credentials, err := chain.Retrieve()
+			Expect(err).Should(BeNil())
+			Expect(credentials.Username).Should(Equal("joe"))
+		})
+
+		It("says which providers were tried", func() {
+			chain := NewCredentialChain(
+				&StaticCredentialProvider{Credentials{Username: "joe"}},
+				&CallbackCredentialProvider{func() (*Credentials, error) {
+					return nil, errors.New("no terminal")
+				}})
+
+			credentials, err := chain.Retrieve()
+			Expect(credentials).Should(BeNil())
+			chainErr, ok := err.(CredentialChainError)
+			Expect(ok).Should(BeTrue())
+			Expect(chainErr.Providers).Should(Equal([]string{"static", "callback"}))
+			Expect(err).Should(MatchError(
+				"photon: No credentials found, tried: [static: both username and password must be set; callback: no terminal]"))
+		})
+	})
+
+	Describe("Client login", func() {
+		var (
+			server       *mocks.Server
+			updatedToken string
+		)
+
+		// The grant types of the token requests, in order.
+		grantTypes := func() (grantTypes []string) {
+			for _, request := range server.RequestsFor("POST", "/openidconnect/token") {
+				form, err := url.ParseQuery(request.Body)
+				Expect(err).Should(BeNil())
+				grantTypes = append(grantTypes, form.Get("grant_type"))
+			}
+			return
+		}
+
+		BeforeEach(func() {
+			if isIntegrationTest() {
+				Skip("Skipping credential login test on integration mode.")
+			}
+
+			updatedToken = ""
+			// The same server plays both photon and the auth server.
+			server = mocks.NewTlsTestServer()
+			server.SetResponseJsonForPath(rootUrl+"/system/auth", 200, createMockAuthInfo(server))
+			server.SetResponseJsonForPath("/openidconnect/token", 200,
+				&TokenOptions{AccessToken: "password_token", RefreshToken: "new_refresh_token"})
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		newClient := func(tokens *TokenOptions) *Client {
+			return NewClient(server.HttpServer.URL, &ClientOptions{
+				IgnoreCertificate:  true,
+				TokenOptions:       tokens,
+				CredentialProvider: &StaticCredentialProvider{Credentials{Username: "joe", Password: "secret"}},
+				UpdateAccessTokenCallback: func(token string) {
+					updatedToken = token
+				},
+			}, nil)
+		}
+
+		It("logs in before the first request", func() {
+			server.SetResponseJson(200, &Status{Status: "READY"})
+			client := newClient(nil)
+
+			status, err := client.System.GetSystemStatus()
+			Expect(err).Should(BeNil())
+			Expect(status.Status).Should(Equal("READY"))
+			Expect(grantTypes()).Should(Equal([]string{"password"}))
+			Expect(client.options.TokenOptions.AccessToken).Should(Equal("password_token"))
+			Expect(client.options.TokenOptions.RefreshToken).Should(Equal("new_refresh_token"))
+			Expect(updatedToken).Should(Equal("password_token"))
+
+			_, err = client.System.GetSystemStatus()
+			Expect(err).Should(BeNil())
+			Expect(grantTypes()).Should(HaveLen(1))
+		})
+
+		It("logs in again when the refresh token is rejected", func() {
+			server.SetResponseJson(401, &ApiError{Code: "ExpiredAuthToken"})
+			server.SetJsonResponsesForPath("/openidconnect/token",
+				mocks.JsonResponse{StatusCode: 400, Value: &lightwave.OIDCError{Code: "invalid_grant", Message: "expired"}},
+				mocks.JsonResponse{StatusCode: 200, Value: &TokenOptions{AccessToken: "password_token", RefreshToken: "new_refresh_token"}})
+			client := newClient(&TokenOptions{AccessToken: "expired_token", RefreshToken: "refresh_token"})
+
+			_, err := client.System.GetSystemStatus()
+			Expect(err).ShouldNot(BeNil())
+			Expect(grantTypes()).Should(Equal([]string{"refresh_token", "password"}))
+			Expect(client.options.TokenOptions.AccessToken).Should(Equal("password_token"))
+			Expect(updatedToken).Should(Equal("password_token"))
+		})
+
+		It("keeps the stored token when logging in again fails", func() {
+			server.SetResponseJson(401, &ApiError{Code: "ExpiredAuthToken"})
+			server.SetResponseJsonForPath("/openidconnect/token", 400,
+				&lightwave.OIDCError{Code: "invalid_grant", Message: "expired"})
+			client := NewClient(server.HttpServer.URL, &ClientOptions{
+				IgnoreCertificate:  true,
+				TokenOptions:       &TokenOptions{AccessToken: "expired_token", RefreshToken: "refresh_token"},
+				CredentialProvider: NewCredentialChain(&FileCredentialProvider{Path: filepath.Join(tempDir, "missing")}),
+			}, nil)
+
+			_, err := client.System.GetSystemStatus()
+			_, ok := err.(CredentialChainError)
+			Expect(ok).Should(BeTrue())
+			Expect(client.options.TokenOptions.AccessToken).Should(Equal("expired_token"))
+			Expect(client.options.TokenOptions.RefreshToken).Should(Equal("refresh_token"))
+		})
+
+		It("does not log in again after logout until asked to", func() {
+			server.SetResponseJson(200, &Status{Status: "READY"})
+			client := newClient(nil)
+
+			_, err := client.System.GetSystemStatus()
+			Expect(err).Should(BeNil())
+			client.Auth.Logout()
+			Expect(client.options.TokenOptions.AccessToken).Should(BeEmpty())
+			Expect(updatedToken).Should(BeEmpty())
+
+			_, err = client.System.GetSystemStatus()
+			Expect(err).Should(BeNil())
+			Expect(grantTypes()).Should(HaveLen(1))
+			Expect(client.options.TokenOptions.AccessToken).Should(BeEmpty())
+
+			err = client.Auth.Login()
+			Expect(err).Should(BeNil())
+			Expect(grantTypes()).Should(Equal([]string{"password", "password"}))
+			Expect(client.options.TokenOptions.AccessToken).Should(Equal("password_token"))
+			Expect(updatedToken).Should(Equal("password_token"))
+		})
+
+		It("fails to log in without a credential provider", func() {
+			client := NewClient(server.HttpServer.URL, &ClientOptions{}, nil)
+			Expect(client.Auth.Login()).ShouldNot(BeNil())
+		})
+
+		It("reports the providers tried when there are no credentials", func() {
+			client := NewClient(server.HttpServer.URL, &ClientOptions{
+				CredentialProvider: NewCredentialChain(&FileCredentialProvider{Path: filepath.Join(tempDir, "missing")}),
+			}, nil)
+
+			_, err := client.System.GetSystemStatus()
+			chainErr, ok := err.(CredentialChainError)
+			Expect(ok).Should(BeTrue())
+			Expect(chainErr.Providers).Should(HaveLen(1))
+			Expect(grantTypes()).Should(BeEmpty())
+		})
+	})
+})
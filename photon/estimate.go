@@ -0,0 +1,281 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Units of quota line items.
+const (
+	QuotaUnitB     string = "B"
+	QuotaUnitKB    string = "KB"
+	QuotaUnitMB    string = "MB"
+	QuotaUnitGB    string = "GB"
+	QuotaUnitCount string = "COUNT"
+)
+
+// Bytes per unit of the size units.
+var quotaUnitBytes = map[string]float64{
+	QuotaUnitB:  1,
+	QuotaUnitKB: 1 << 10,
+	QuotaUnitMB: 1 << 20,
+	QuotaUnitGB: 1 << 30,
+}
+
+// Converts a quota value from one unit to another. Size units convert into
+// each other; COUNT only into itself.
+func ConvertQuotaUnit(value float64, from string, to string) (result float64, err error) {
+	if from == to {
+		return value, nil
+	}
+	fromBytes, fromOk := quotaUnitBytes[from]
+	toBytes, toOk := quotaUnitBytes[to]
+	if !fromOk || !toOk {
+		return 0, fmt.Errorf("photon: Cannot convert quota unit %s to %s", from, to)
+	}
+	return value * fromBytes / toBytes, nil
+}
+
+// One key of a quota check.
+type QuotaCheckItem struct {
+	Key string
+
+	// Unit of the values: that of the quota, or of the request if the quota
+	// has no item for the key.
+	Unit string
+
+	// What the request would consume, and what is consumed already.
+	Requested float64
+	Usage     float64
+
+	// Limit of the key, if Limited.
+	Limit   float64
+	Limited bool
+
+	// Whether usage plus request is over the limit.
+	Exceeds bool
+}
+
+// Result of checking the consumption of a request against a project's quota.
+type QuotaReport struct {
+	ProjectID string
+
+	// By key.
+	Items []QuotaCheckItem
+}
+
+// Whether the request fits into the quota.
+func (report *QuotaReport) Fits() bool {
+	return len(report.Exceeded()) == 0
+}
+
+// Returns the items over their limit.
+func (report *QuotaReport) Exceeded() (items []QuotaCheckItem) {
+	for _, item := range report.Items {
+		if item.Exceeds {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// Formats the report as a table, one row per key.
+func (report *QuotaReport) String() string {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KEY\tUNIT\tREQUESTED\tUSAGE\tLIMIT\tSTATUS")
+	for _, item := range report.Items {
+		limit, status := "-", "ok"
+		if item.Limited {
+			limit = formatQuotaValue(item.Limit)
+		}
+		if item.Exceeds {
+			status = fmt.Sprintf("exceeds by %s", formatQuotaValue(item.Usage+item.Requested-item.Limit))
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", item.Key, item.Unit,
+			formatQuotaValue(item.Requested), formatQuotaValue(item.Usage), limit, status)
+	}
+	writer.Flush()
+	return buf.String()
+}
+
+func formatQuotaValue(value float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", value), "0"), ".")
+}
+
+// Estimates what creating VMs and disks consumes of a project's quota. Flavors
+// are looked up once and kept.
+type QuotaEstimator struct {
+	client *Client
+
+	lock    sync.Mutex
+	flavors flavorIndex
+}
+
+// Creates an estimator that looks flavors, images and quotas up with the client.
+func NewQuotaEstimator(client *Client) *QuotaEstimator {
+	return &QuotaEstimator{client: client}
+}
+
+// Returns the quota a VM would consume: the cost of its flavor, the cost of
+// its disks' flavors and their capacity. A boot disk without a capacity is
+// sized as the image.
+func (e *QuotaEstimator) EstimateVM(spec *VmCreateSpec) (items []QuotaLineItem, err error) {
+	flavor, err := e.getFlavor(VmFlavorKind, spec.Flavor)
+	if err != nil {
+		return
+	}
+	items = append(items, flavor.Cost...)
+
+	for _, disk := range spec.AttachedDisks {
+		capacityGB := float64(disk.CapacityGB)
+		if disk.BootDisk && disk.CapacityGB == 0 && spec.SourceImageID != "" {
+			var image *Image
+			image, err = e.client.Images.Get(spec.SourceImageID)
+			if err != nil {
+				return nil, err
+			}
+			capacityGB = math.Ceil(float64(image.Size) / quotaUnitBytes[QuotaUnitGB])
+		}
+
+		var diskItems []QuotaLineItem
+		diskItems, err = e.estimateDisk(disk.Kind, disk.Flavor, capacityGB)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, diskItems...)
+	}
+	return sumQuotaLineItems(items)
+}
+
+// Returns the quota a persistent disk would consume: the cost of its flavor
+// and its capacity.
+func (e *QuotaEstimator) EstimateDisk(spec *DiskCreateSpec) (items []QuotaLineItem, err error) {
+	kind := spec.Kind
+	if kind == "" {
+		kind = PersistentDiskKind
+	}
+	items, err = e.estimateDisk(kind, spec.Flavor, float64(spec.CapacityGB))
+	if err != nil {
+		return
+	}
+	return sumQuotaLineItems(items)
+}
+
+func (e *QuotaEstimator) estimateDisk(kind string, flavorName string, capacityGB float64) (items []QuotaLineItem, err error) {
+	flavor, err := e.getFlavor(kind, flavorName)
+	if err != nil {
+		return
+	}
+	items = append(items, flavor.Cost...)
+	if capacityGB > 0 {
+		items = append(items, QuotaLineItem{Key: kind + ".capacity", Value: capacityGB, Unit: QuotaUnitGB})
+	}
+	return
+}
+
+// Checks whether a VM fits into the quota of a project.
+func (e *QuotaEstimator) CheckVM(projectID string, spec *VmCreateSpec) (report *QuotaReport, err error) {
+	items, err := e.EstimateVM(spec)
+	if err != nil {
+		return
+	}
+	return e.Check(projectID, items)
+}
+
+// Checks whether a disk fits into the quota of a project.
+func (e *QuotaEstimator) CheckDisk(projectID string, spec *DiskCreateSpec) (report *QuotaReport, err error) {
+	items, err := e.EstimateDisk(spec)
+	if err != nil {
+		return
+	}
+	return e.Check(projectID, items)
+}
+
+// Checks estimated consumption against the quota of a project. Keys the quota
+// has no item for are reported as not limited.
+func (e *QuotaEstimator) Check(projectID string, items []QuotaLineItem) (report *QuotaReport, err error) {
+	items, err = sumQuotaLineItems(items)
+	if err != nil {
+		return
+	}
+	quota, err := e.client.Projects.GetQuota(projectID)
+	if err != nil {
+		return
+	}
+
+	report = &QuotaReport{ProjectID: projectID}
+	for _, item := range items {
+		check := QuotaCheckItem{Key: item.Key, Unit: item.Unit, Requested: item.Value}
+		if status, ok := quota.QuotaLineItems[item.Key]; ok {
+			check.Requested, err = ConvertQuotaUnit(item.Value, item.Unit, status.Unit)
+			if err != nil {
+				return nil, fmt.Errorf("photon: Quota key '%s': %s", item.Key, err)
+			}
+			check.Unit = status.Unit
+			check.Usage = status.Usage
+			check.Limit = status.Limit
+			check.Limited = true
+			check.Exceeds = check.Usage+check.Requested > check.Limit
+		}
+		report.Items = append(report.Items, check)
+	}
+	return
+}
+
+func (e *QuotaEstimator) getFlavor(kind string, name string) (flavor *Flavor, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.flavors == nil {
+		e.flavors, err = getFlavorIndex(e.client)
+		if err != nil {
+			return
+		}
+	}
+	flavor = e.flavors[kind][name]
+	if flavor == nil {
+		return nil, fmt.Errorf("photon: No %s flavor named '%s'", kind, name)
+	}
+	return
+}
+
+// Adds up line items by key, in the unit first seen for each key, and returns
+// them sorted by key.
+func sumQuotaLineItems(items []QuotaLineItem) (sums []QuotaLineItem, err error) {
+	byKey := map[string]*QuotaLineItem{}
+	keys := []string{}
+	for _, item := range items {
+		sum := byKey[item.Key]
+		if sum == nil {
+			sum = &QuotaLineItem{Key: item.Key, Unit: item.Unit}
+			byKey[item.Key] = sum
+			keys = append(keys, item.Key)
+		}
+		var value float64
+		value, err = ConvertQuotaUnit(item.Value, item.Unit, sum.Unit)
+		if err != nil {
+			return nil, fmt.Errorf("photon: Quota key '%s': %s", item.Key, err)
+		}
+		sum.Value += value
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		sums = append(sums, *byKey[key])
+	}
+	return
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("QuotaEstimator", func() {
+	var (
+		server    *mocks.Server
+		client    *Client
+		estimator *QuotaEstimator
+		vmSpec    *VmCreateSpec
+	)
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping quota estimator test on integration mode.")
+		}
+		server, client = mockServerClient()
+		server.SetResponseJson(404, createMockApiError("NotFound", "Not found", 404))
+		server.SetResponseJsonForPath(rootUrl+"/flavors", 200, &FlavorList{Items: []Flavor{
+			{Name: "vm-small", Kind: VmFlavorKind, Cost: []QuotaLineItem{
+				{"COUNT", 1, "vm"}, {"COUNT", 2, "vm.cpu"}, {"GB", 2, "vm.memory"}, {"COUNT", 1, "vm.cost"}}},
+			{Name: "disk-ssd", Kind: EphemeralDiskKind, Cost: []QuotaLineItem{
+				{"COUNT", 1, "ephemeral-disk"}, {"COUNT", 1, "ephemeral-disk.cost"}}},
+			{Name: "disk-ssd", Kind: PersistentDiskKind, Cost: []QuotaLineItem{
+				{"COUNT", 1, "persistent-disk"}}},
+		}})
+		server.SetResponseJsonForPath(rootUrl+"/images/img1", 200, &Image{ID: "img1", Size: 3584 << 20})
+		server.SetResponseJsonForPath(rootUrl+"/projects/p1/quota", 200, &Quota{QuotaLineItems: map[string]QuotaStatusLineItem{
+			"vm":                      {Unit: "COUNT", Limit: 10, Usage: 3},
+			"vm.cpu":                  {Unit: "COUNT", Limit: 4, Usage: 3},
+			"vm.memory":               {Unit: "MB", Limit: 8192, Usage: 6144},
+			"ephemeral-disk.capacity": {Unit: "GB", Limit: 50, Usage: 30},
+		}})
+		estimator = NewQuotaEstimator(client)
+		vmSpec = &VmCreateSpec{
+			Name:          "web-1",
+			Flavor:        "vm-small",
+			SourceImageID: "img1",
+			AttachedDisks: []AttachedDisk{
+				{Name: "boot", Kind: EphemeralDiskKind, Flavor: "disk-ssd", BootDisk: true},
+				{Name: "data", Kind: EphemeralDiskKind, Flavor: "disk-ssd", CapacityGB: 20},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("expands the flavors and disk capacities of a VM", func() {
+		items, err := estimator.EstimateVM(vmSpec)
+		Expect(err).Should(BeNil())
+		Expect(items).Should(Equal([]QuotaLineItem{
+			{"COUNT", 2, "ephemeral-disk"},
+			{"GB", 24, "ephemeral-disk.capacity"},
+			{"COUNT", 2, "ephemeral-disk.cost"},
+			{"COUNT", 1, "vm"},
+			{"COUNT", 1, "vm.cost"},
+			{"COUNT", 2, "vm.cpu"},
+			{"GB", 2, "vm.memory"},
+		}))
+	})
+
+	It("reports per key what exceeds the quota", func() {
+		report, err := estimator.CheckVM("p1", vmSpec)
+		Expect(err).Should(BeNil())
+		Expect(report.Fits()).Should(BeFalse())
+		Expect(report.Exceeded()).Should(Equal([]QuotaCheckItem{
+			{Key: "ephemeral-disk.capacity", Unit: "GB", Requested: 24, Usage: 30, Limit: 50, Limited: true, Exceeds: true},
+			{Key: "vm.cpu", Unit: "COUNT", Requested: 2, Usage: 3, Limit: 4, Limited: true, Exceeds: true},
+		}))
+
+		Expect(report.Items).Should(ContainElement(QuotaCheckItem{
+			Key: "vm.memory", Unit: "MB", Requested: 2048, Usage: 6144, Limit: 8192, Limited: true}))
+		Expect(report.Items).Should(ContainElement(QuotaCheckItem{Key: "vm.cost", Unit: "COUNT", Requested: 1}))
+
+		table := report.String()
+		Expect(table).Should(ContainSubstring("vm.cpu"))
+		Expect(table).Should(MatchRegexp(`vm\.cpu\s+COUNT\s+2\s+3\s+4\s+exceeds by 1`))
+		Expect(table).Should(MatchRegexp(`vm\.cost\s+COUNT\s+1\s+0\s+-\s+ok`))
+		Expect(server.RequestsFor("GET", rootUrl+"/flavors")).Should(HaveLen(1))
+	})
+
+	It("checks a persistent disk", func() {
+		report, err := estimator.CheckDisk("p1", &DiskCreateSpec{Name: "d1", Flavor: "disk-ssd", CapacityGB: 10})
+		Expect(err).Should(BeNil())
+		Expect(report.Items).Should(Equal([]QuotaCheckItem{
+			{Key: "persistent-disk", Unit: "COUNT", Requested: 1},
+			{Key: "persistent-disk.capacity", Unit: "GB", Requested: 10},
+		}))
+		Expect(report.Fits()).Should(BeTrue())
+	})
+
+	It("fails on a unit that does not match the quota", func() {
+		_, err := estimator.Check("p1", []QuotaLineItem{{"COUNT", 1, "vm.memory"}})
+		Expect(err).ShouldNot(BeNil())
+		Expect(err.Error()).Should(ContainSubstring("vm.memory"))
+	})
+
+	It("fails on an unknown flavor", func() {
+		vmSpec.Flavor = "vm-huge"
+		_, err := estimator.EstimateVM(vmSpec)
+		Expect(err).ShouldNot(BeNil())
+		Expect(err.Error()).Should(ContainSubstring("vm-huge"))
+	})
+
+	It("converts between size units", func() {
+		value, err := ConvertQuotaUnit(1.5, QuotaUnitGB, QuotaUnitMB)
+		Expect(err).Should(BeNil())
+		Expect(value).Should(Equal(1536.0))
+		value, err = ConvertQuotaUnit(512, QuotaUnitKB, QuotaUnitMB)
+		Expect(err).Should(BeNil())
+		Expect(value).Should(Equal(0.5))
+		_, err = ConvertQuotaUnit(1, QuotaUnitCount, QuotaUnitGB)
+		Expect(err).ShouldNot(BeNil())
+	})
+})
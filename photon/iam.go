@@ -0,0 +1,208 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of entities with an IAM policy, as used in Entity.Kind.
+const (
+	EntityKindTenant  string = "tenant"
+	EntityKindProject string = "project"
+	EntityKindVm      string = "vm"
+	EntityKindDisk    string = "disk"
+	EntityKindImage   string = "image"
+)
+
+// Actions of a RoleBindingDelta.
+const (
+	IamActionAdd    string = "ADD"
+	IamActionRemove string = "REMOVE"
+)
+
+// Contains functionality for the IAM policies of all kinds of entities.
+type IamAPI struct {
+	client *Client
+}
+
+// The changes needed to turn the current IAM policy of an entity into the desired one.
+type IamPolicyDiff struct {
+	Entity Entity              `json:"entity"`
+	Deltas []*RoleBindingDelta `json:"deltas"`
+}
+
+// Returns true if the policy is already as desired.
+func (diff *IamPolicyDiff) IsEmpty() bool {
+	return len(diff.Deltas) == 0
+}
+
+// Returns the diff with one line per change, "+" for added and "-" for removed
+// role bindings.
+func (diff *IamPolicyDiff) String() string {
+	lines := []string{fmt.Sprintf("%s %s", diff.Entity.Kind, diff.Entity.ID)}
+	for _, delta := range diff.Deltas {
+		sign := "+"
+		if delta.Action == IamActionRemove {
+			sign = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", sign, delta.Role, delta.Subject))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Gets the IAM policy of an entity.
+func (api *IamAPI) Get(entity Entity) (policy []*RoleBinding, err error) {
+	switch entity.Kind {
+	case EntityKindTenant:
+		return api.client.Tenants.GetIam(entity.ID)
+	case EntityKindProject:
+		return api.client.Projects.GetIam(entity.ID)
+	case EntityKindVm:
+		return api.client.VMs.GetIam(entity.ID)
+	case EntityKindDisk:
+		return api.client.Disks.GetIam(entity.ID)
+	case EntityKindImage:
+		return api.client.Images.GetIam(entity.ID)
+	}
+	return nil, unknownEntityKind(entity)
+}
+
+// Replaces the IAM policy of an entity.
+func (api *IamAPI) Set(entity Entity, policy []*RoleBinding) (task *Task, err error) {
+	switch entity.Kind {
+	case EntityKindTenant:
+		return api.client.Tenants.SetIam(entity.ID, policy)
+	case EntityKindProject:
+		return api.client.Projects.SetIam(entity.ID, policy)
+	case EntityKindVm:
+		return api.client.VMs.SetIam(entity.ID, policy)
+	case EntityKindDisk:
+		return api.client.Disks.SetIam(entity.ID, policy)
+	case EntityKindImage:
+		return api.client.Images.SetIam(entity.ID, policy)
+	}
+	return nil, unknownEntityKind(entity)
+}
+
+// Modifies the IAM policy of an entity.
+func (api *IamAPI) Modify(entity Entity, policyDelta []*RoleBindingDelta) (task *Task, err error) {
+	switch entity.Kind {
+	case EntityKindTenant:
+		return api.client.Tenants.ModifyIam(entity.ID, policyDelta)
+	case EntityKindProject:
+		return api.client.Projects.ModifyIam(entity.ID, policyDelta)
+	case EntityKindVm:
+		return api.client.VMs.ModifyIam(entity.ID, policyDelta)
+	case EntityKindDisk:
+		return api.client.Disks.ModifyIam(entity.ID, policyDelta)
+	case EntityKindImage:
+		return api.client.Images.ModifyIam(entity.ID, policyDelta)
+	}
+	return nil, unknownEntityKind(entity)
+}
+
+// Compares the current IAM policy of an entity with the desired one, without
+// changing anything.
+func (api *IamAPI) Diff(entity Entity, desired []*RoleBinding) (diff *IamPolicyDiff, err error) {
+	current, err := api.Get(entity)
+	if err != nil {
+		return
+	}
+	return &IamPolicyDiff{Entity: entity, Deltas: DiffIamPolicies(current, desired)}, nil
+}
+
+// Applies a diff, e.g. one returned by Diff and reviewed since. Returns a nil
+// task if the diff is empty.
+func (api *IamAPI) ApplyDiff(diff *IamPolicyDiff) (task *Task, err error) {
+	if diff.IsEmpty() {
+		return
+	}
+	return api.Modify(diff.Entity, diff.Deltas)
+}
+
+// Changes the IAM policy of an entity to the desired one with the fewest role
+// binding changes. Returns the diff that was applied, and a nil task if the
+// policy was already as desired.
+func (api *IamAPI) Apply(entity Entity, desired []*RoleBinding) (diff *IamPolicyDiff, task *Task, err error) {
+	diff, err = api.Diff(entity, desired)
+	if err != nil {
+		return
+	}
+	task, err = api.ApplyDiff(diff)
+	return
+}
+
+// Returns the role binding changes that turn the current policy into the
+// desired one, removals first, each sorted by role and subject. Subjects are
+// compared the way Lightwave does, ignoring case and whether they are written
+// as name@domain or domain\name.
+func DiffIamPolicies(current []*RoleBinding, desired []*RoleBinding) (deltas []*RoleBindingDelta) {
+	currentBindings := flattenPolicy(current)
+	desiredBindings := flattenPolicy(desired)
+
+	deltas = []*RoleBindingDelta{}
+	for _, key := range sortedBindingKeys(currentBindings) {
+		if _, ok := desiredBindings[key]; !ok {
+			deltas = append(deltas, &RoleBindingDelta{IamActionRemove, key.role, currentBindings[key]})
+		}
+	}
+	for _, key := range sortedBindingKeys(desiredBindings) {
+		if _, ok := currentBindings[key]; !ok {
+			deltas = append(deltas, &RoleBindingDelta{IamActionAdd, key.role, desiredBindings[key]})
+		}
+	}
+	return
+}
+
+type bindingKey struct {
+	role    string
+	subject string
+}
+
+// Maps each role and normalized subject of the policy to the subject as written.
+func flattenPolicy(policy []*RoleBinding) map[bindingKey]string {
+	bindings := map[bindingKey]string{}
+	for _, binding := range policy {
+		for _, subject := range binding.Subjects {
+			key := bindingKey{binding.Role, normalizePrincipal(subject)}
+			if _, ok := bindings[key]; !ok {
+				bindings[key] = subject
+			}
+		}
+	}
+	return bindings
+}
+
+type bindingKeys []bindingKey
+
+func (keys bindingKeys) Len() int      { return len(keys) }
+func (keys bindingKeys) Swap(i, j int) { keys[i], keys[j] = keys[j], keys[i] }
+func (keys bindingKeys) Less(i, j int) bool {
+	if keys[i].role != keys[j].role {
+		return keys[i].role < keys[j].role
+	}
+	return keys[i].subject < keys[j].subject
+}
+
+func sortedBindingKeys(bindings map[bindingKey]string) []bindingKey {
+	keys := make(bindingKeys, 0, len(bindings))
+	for key := range bindings {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	return keys
+}
+
+func unknownEntityKind(entity Entity) error {
+	return fmt.Errorf("photon: Unknown entity kind '%s'", entity.Kind)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("Iam", func() {
+	Describe("DiffIamPolicies", func() {
+		It("returns the minimal changes", func() {
+			current := []*RoleBinding{
+				{Role: "owner", Subjects: []string{"joe@photon.local", "ann@photon.local"}},
+				{Role: "viewer", Subjects: []string{"photon.local\\Developers"}},
+			}
+			desired := []*RoleBinding{
+				{Role: "owner", Subjects: []string{"JOE@photon.local"}},
+				{Role: "viewer", Subjects: []string{"developers@photon.local", "ann@photon.local"}},
+				{Role: "viewer", Subjects: []string{"ann@photon.local"}},
+			}
+			Expect(DiffIamPolicies(current, desired)).Should(Equal([]*RoleBindingDelta{
+				{IamActionRemove, "owner", "ann@photon.local"},
+				{IamActionAdd, "viewer", "ann@photon.local"},
+			}))
+		})
+
+		It("returns no changes for equal policies", func() {
+			policy := []*RoleBinding{{Role: "owner", Subjects: []string{"joe@photon.local"}}}
+			Expect(DiffIamPolicies(policy, policy)).Should(BeEmpty())
+		})
+	})
+
+	Describe("IamAPI", func() {
+		var (
+			server  *mocks.Server
+			client  *Client
+			current []*RoleBinding
+			vm      Entity
+		)
+
+		// Deltas of each ModifyIam request, in order.
+		patches := func() (patches [][]*RoleBindingDelta) {
+			for _, r := range server.RequestsFor("PATCH", rootUrl+"/vms/vm1/iam") {
+				var deltas []*RoleBindingDelta
+				Expect(json.Unmarshal([]byte(r.Body), &deltas)).Should(Succeed())
+				patches = append(patches, deltas)
+			}
+			return
+		}
+
+		BeforeEach(func() {
+			if isIntegrationTest() {
+				Skip("Skipping IAM API test on integration mode.")
+			}
+			current = []*RoleBinding{{Role: "owner", Subjects: []string{"joe@photon.local"}}}
+			vm = Entity{ID: "vm1", Kind: EntityKindVm}
+
+			server, client = mockServerClient()
+			server.SetResponseJson(404, createMockApiError("NotFound", "Not found", 404))
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm1/iam", 200, current)
+			server.SetResponseJsonForMethodPath("PATCH", rootUrl+"/vms/vm1/iam", 200,
+				createMockTask("MODIFY_IAM_POLICY", "QUEUED"))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("applies the diff with ModifyIam", func() {
+			desired := []*RoleBinding{{Role: "viewer", Subjects: []string{"joe@photon.local"}}}
+			diff, task, err := client.Iam.Apply(vm, desired)
+			Expect(err).Should(BeNil())
+			Expect(task.Operation).Should(Equal("MODIFY_IAM_POLICY"))
+			Expect(diff.Entity).Should(Equal(vm))
+			Expect(diff.String()).Should(Equal("vm vm1\n- owner joe@photon.local\n+ viewer joe@photon.local"))
+			Expect(patches()).Should(Equal([][]*RoleBindingDelta{diff.Deltas}))
+		})
+
+		It("does not modify policies that are as desired", func() {
+			diff, task, err := client.Iam.Apply(vm, current)
+			Expect(err).Should(BeNil())
+			Expect(diff.IsEmpty()).Should(BeTrue())
+			Expect(task).Should(BeNil())
+			Expect(patches()).Should(BeEmpty())
+		})
+
+		It("applies a reviewed diff", func() {
+			diff, err := client.Iam.Diff(vm, nil)
+			Expect(err).Should(BeNil())
+			Expect(patches()).Should(BeEmpty())
+
+			task, err := client.Iam.ApplyDiff(diff)
+			Expect(err).Should(BeNil())
+			Expect(task).ShouldNot(BeNil())
+			Expect(patches()).Should(Equal([][]*RoleBindingDelta{{{IamActionRemove, "owner", "joe@photon.local"}}}))
+		})
+
+		It("rejects unknown entity kinds", func() {
+			_, err := client.Iam.Get(Entity{ID: "net1", Kind: "network"})
+			Expect(err).Should(MatchError("photon: Unknown entity kind 'network'"))
+		})
+	})
+})
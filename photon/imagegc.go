@@ -0,0 +1,342 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Images with this tag are never collected, unless ImageGCOptions.ProtectTags
+// names other tags.
+const ImageProtectTag string = "gc:protected"
+
+// What the collector did, or in a dry run would do, with an unused image.
+const (
+	ImageGCStatusUnused    string = "unused"
+	ImageGCStatusDeleted   string = "deleted"
+	ImageGCStatusFailed    string = "failed"
+	ImageGCStatusProtected string = "protected"
+	ImageGCStatusTooYoung  string = "too-young"
+	ImageGCStatusBusy      string = "busy"
+)
+
+// Options for CollectUnusedImages.
+type ImageGCOptions struct {
+	// Delete the unused images. Without it the collector only reports them.
+	Apply bool
+
+	// Images with any of these tags are kept; ImageProtectTag if not set.
+	ProtectTags []string
+
+	// Images younger than this are kept. The age of an image is that of its
+	// oldest task; if it has none, it is kept.
+	MinAge time.Duration
+
+	// Maximum number of API requests in flight, 4 if not set.
+	Concurrency int
+}
+
+// An image no VM or service references.
+type ImageGCCandidate struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	State string   `json:"state"`
+	Size  int64    `json:"size"`
+	Tags  []string `json:"tags,omitempty"`
+
+	// When the oldest task of the image was queued, zero if it has none.
+	CreatedAt time.Time     `json:"createdAt"`
+	Age       time.Duration `json:"age"`
+
+	// One of the ImageGCStatus constants, and why a delete failed.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Result of CollectUnusedImages.
+type ImageGCReport struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	Applied     bool      `json:"applied"`
+
+	// Referenced images, with what references them, e.g. "vm:<id>",
+	// "service:<id>" or "service-type:<type>".
+	Referenced map[string][]string `json:"referenced"`
+
+	// Unused images, oldest first.
+	Unused []ImageGCCandidate `json:"unused"`
+}
+
+// Returns the bytes of the images deleted, or in a dry run, that would be.
+func (report *ImageGCReport) Reclaimable() (size int64) {
+	for _, candidate := range report.Unused {
+		if candidate.Status == ImageGCStatusUnused || candidate.Status == ImageGCStatusDeleted {
+			size += candidate.Size
+		}
+	}
+	return
+}
+
+// Formats the unused images as a table.
+func (report *ImageGCReport) String() string {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNAME\tSIZE (MB)\tAGE (DAYS)\tSTATUS")
+	for _, candidate := range report.Unused {
+		age := "-"
+		if !candidate.CreatedAt.IsZero() {
+			age = formatQuotaValue(float64(candidate.Age/time.Hour) / 24)
+		}
+		status := candidate.Status
+		if candidate.Error != "" {
+			status += ": " + candidate.Error
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", candidate.ID, candidate.Name,
+			formatQuotaValue(float64(candidate.Size)/quotaUnitBytes[QuotaUnitMB]), age, status)
+	}
+	writer.Flush()
+	return buf.String()
+}
+
+// Finds the images no VM of any project, no service and no service type
+// references, and with Apply deletes those not protected by tag, age or their
+// state, waiting for each delete. Failed deletes are recorded in the report;
+// other errors stop the collection before anything is deleted.
+func CollectUnusedImages(client *Client, options *ImageGCOptions) (report *ImageGCReport, err error) {
+	if options == nil {
+		options = &ImageGCOptions{}
+	}
+	concurrency := defaultAuditConcurrency
+	if options.Concurrency > 0 {
+		concurrency = options.Concurrency
+	}
+	protectTags := options.ProtectTags
+	if len(protectTags) == 0 {
+		protectTags = []string{ImageProtectTag}
+	}
+
+	images, err := client.Images.GetAll(nil)
+	if err != nil {
+		return
+	}
+	walker := &imageRefWalker{jobRunner: newJobRunner(concurrency), client: client, refs: map[string][]string{}}
+	walker.spawn(walker.walkServiceTypes)
+	walker.spawn(walker.walkTenants)
+	err = walker.wait()
+	if err != nil {
+		return
+	}
+	for _, refs := range walker.refs {
+		sort.Strings(refs)
+	}
+
+	report = &ImageGCReport{GeneratedAt: time.Now().UTC(), Applied: options.Apply, Referenced: map[string][]string{}}
+	for _, image := range images.Items {
+		if refs := walker.refs[image.ID]; len(refs) > 0 {
+			report.Referenced[image.ID] = refs
+			continue
+		}
+		report.Unused = append(report.Unused, ImageGCCandidate{
+			ID:    image.ID,
+			Name:  image.Name,
+			State: image.State,
+			Size:  image.Size,
+			Tags:  image.Tags,
+		})
+	}
+
+	runner := newJobRunner(concurrency)
+	for idx := range report.Unused {
+		candidate := &report.Unused[idx]
+		runner.spawn(func() error {
+			return ageImage(client, candidate, report.GeneratedAt)
+		})
+	}
+	err = runner.wait()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range report.Unused {
+		candidate := &report.Unused[idx]
+		switch {
+		case hasAnyTag(candidate.Tags, protectTags):
+			candidate.Status = ImageGCStatusProtected
+		case candidate.State != ImageStateReady && candidate.State != ImageStateError:
+			candidate.Status = ImageGCStatusBusy
+		case options.MinAge > 0 && (candidate.CreatedAt.IsZero() || candidate.Age < options.MinAge):
+			candidate.Status = ImageGCStatusTooYoung
+		default:
+			candidate.Status = ImageGCStatusUnused
+		}
+	}
+	sort.Sort(imageGCCandidates(report.Unused))
+
+	if options.Apply {
+		runner = newJobRunner(concurrency)
+		for idx := range report.Unused {
+			candidate := &report.Unused[idx]
+			if candidate.Status != ImageGCStatusUnused {
+				continue
+			}
+			runner.spawn(func() error {
+				deleteImage(client, candidate)
+				return nil
+			})
+		}
+		runner.wait()
+	}
+	return
+}
+
+// Sets the creation time and age of an image from its oldest task.
+func ageImage(client *Client, candidate *ImageGCCandidate, now time.Time) error {
+	tasks, err := client.Images.GetTasks(candidate.ID, nil)
+	if err != nil {
+		return err
+	}
+	var oldest int64
+	for _, task := range tasks.Items {
+		queued := task.QueuedTime
+		if queued == 0 {
+			queued = task.StartedTime
+		}
+		if queued > 0 && (oldest == 0 || queued < oldest) {
+			oldest = queued
+		}
+	}
+	if oldest > 0 {
+		// Task times are in milliseconds.
+		candidate.CreatedAt = time.Unix(0, oldest*int64(time.Millisecond)).UTC()
+		candidate.Age = now.Sub(candidate.CreatedAt)
+	}
+	return nil
+}
+
+func deleteImage(client *Client, candidate *ImageGCCandidate) {
+	task, err := client.Images.Delete(candidate.ID)
+	if err == nil {
+		_, err = client.Tasks.Wait(task.ID)
+	}
+	if err != nil {
+		candidate.Status = ImageGCStatusFailed
+		candidate.Error = err.Error()
+		return
+	}
+	candidate.Status = ImageGCStatusDeleted
+}
+
+func hasAnyTag(tags []string, wanted []string) bool {
+	for _, tag := range tags {
+		for _, want := range wanted {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Oldest first, images of unknown age last, then by ID.
+type imageGCCandidates []ImageGCCandidate
+
+func (c imageGCCandidates) Len() int      { return len(c) }
+func (c imageGCCandidates) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c imageGCCandidates) Less(i, j int) bool {
+	if c[i].CreatedAt.Equal(c[j].CreatedAt) {
+		return c[i].ID < c[j].ID
+	}
+	if c[i].CreatedAt.IsZero() || c[j].CreatedAt.IsZero() {
+		return c[j].CreatedAt.IsZero()
+	}
+	return c[i].CreatedAt.Before(c[j].CreatedAt)
+}
+
+// Collects what references images: the VMs and services of all projects, and
+// the service types of the system.
+type imageRefWalker struct {
+	*jobRunner
+	client *Client
+
+	lock sync.Mutex
+	refs map[string][]string
+}
+
+func (w *imageRefWalker) add(imageID string, ref string) {
+	if imageID == "" {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.refs[imageID] = append(w.refs[imageID], ref)
+}
+
+func (w *imageRefWalker) walkServiceTypes() error {
+	info, err := w.client.System.GetSystemInfo()
+	if err != nil {
+		return err
+	}
+	for _, config := range info.ServiceConfigurations {
+		w.add(config.ImageID, "service-type:"+strings.ToLower(config.Type))
+	}
+	return nil
+}
+
+func (w *imageRefWalker) walkTenants() error {
+	tenants, err := w.client.Tenants.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants.Items {
+		tenantID := tenant.ID
+		w.spawn(func() error { return w.walkProjects(tenantID) })
+	}
+	return nil
+}
+
+func (w *imageRefWalker) walkProjects(tenantID string) error {
+	projects, err := w.client.Tenants.GetProjects(tenantID, nil)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects.Items {
+		projectID := project.ID
+		w.spawn(func() error { return w.walkVMs(projectID) })
+		w.spawn(func() error { return w.walkServices(projectID) })
+	}
+	return nil
+}
+
+func (w *imageRefWalker) walkVMs(projectID string) error {
+	vms, err := w.client.Projects.GetVMs(projectID, nil)
+	if err != nil {
+		return err
+	}
+	for _, vm := range vms.Items {
+		w.add(vm.SourceImageID, "vm:"+vm.ID)
+	}
+	return nil
+}
+
+func (w *imageRefWalker) walkServices(projectID string) error {
+	services, err := w.client.Projects.GetServices(projectID)
+	if err != nil {
+		return err
+	}
+	for _, service := range services.Items {
+		w.add(service.ImageID, "service:"+service.ID)
+	}
+	return nil
+}
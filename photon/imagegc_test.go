@@ -0,0 +1,183 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("Image GC", func() {
+	var (
+		server *mocks.Server
+		client *Client
+	)
+
+	// IDs of the images deleted.
+	deleted := func() (ids []string) {
+		for _, r := range server.RequestsFor("DELETE", rootUrl+"/images/") {
+			ids = append(ids, strings.TrimPrefix(r.Path, rootUrl+"/images/"))
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping image GC test on integration mode.")
+		}
+		server, client = mockServerClient()
+		server.SetResponseJson(404, createMockApiError("NotFound", "Not found", 404))
+
+		daysAgo := func(days int) int64 {
+			return time.Now().Add(-time.Duration(days)*24*time.Hour).UnixNano() / int64(time.Millisecond)
+		}
+		responses := map[string]interface{}{
+			"/images": &Images{Items: []Image{
+				{ID: "img-vm", Name: "photon", State: ImageStateReady, Size: 1 << 30},
+				{ID: "img-svc", Name: "kube", State: ImageStateReady},
+				{ID: "img-type", Name: "harbor", State: ImageStateReady},
+				{ID: "img-old", Name: "ubuntu-14", State: ImageStateReady, Size: 512 << 20},
+				{ID: "img-kept", Name: "golden", State: ImageStateReady, Tags: []string{"os:photon", ImageProtectTag}},
+				{ID: "img-new", Name: "ubuntu-16", State: ImageStateReady, Size: 1 << 20},
+				{ID: "img-creating", Name: "upload", State: ImageStateCreating},
+				{ID: "img-broken", Name: "broken", State: ImageStateError, Size: 2 << 20},
+				{ID: "img-untracked", Name: "untracked", State: ImageStateReady},
+			}},
+			"/images/img-old/tasks": &TaskList{Items: []Task{
+				{ID: "t1", Operation: "CREATE_IMAGE", QueuedTime: daysAgo(30)},
+				{ID: "t2", Operation: "REPLICATE_IMAGE", QueuedTime: daysAgo(29)},
+			}},
+			"/images/img-kept/tasks":      &TaskList{Items: []Task{{QueuedTime: daysAgo(60)}}},
+			"/images/img-new/tasks":       &TaskList{Items: []Task{{StartedTime: daysAgo(1)}}},
+			"/images/img-creating/tasks":  &TaskList{Items: []Task{{QueuedTime: daysAgo(0)}}},
+			"/images/img-broken/tasks":    &TaskList{Items: []Task{{QueuedTime: daysAgo(10)}}},
+			"/images/img-untracked/tasks": &TaskList{},
+			"/system/info": &SystemInfo{ServiceConfigurations: []ServiceConfiguration{
+				{Type: "HARBOR", ImageID: "img-type"},
+				{Type: "KUBERNETES"},
+			}},
+			"/tenants":             &Tenants{Items: []Tenant{{ID: "t1"}}},
+			"/tenants/t1/projects": &ProjectList{Items: []ProjectCompact{{ID: "p1"}, {ID: "p2"}}},
+			"/projects/p1/vms": &VMs{Items: []VM{
+				{ID: "vm1", SourceImageID: "img-vm"},
+				{ID: "vm2", SourceImageID: "img-vm"},
+			}},
+			"/projects/p2/vms":            &VMs{Items: []VM{{ID: "vm3", SourceImageID: "img-svc"}}},
+			"/projects/p1/services":       &Services{},
+			"/projects/p2/services":       &Services{Items: []Service{{ID: "svc1", ImageID: "img-svc"}}},
+			"/tasks/delete-img-old":       &Task{ID: "delete-img-old", State: "COMPLETED"},
+			"/tasks/delete-img-new":       &Task{ID: "delete-img-new", State: "COMPLETED"},
+			"/tasks/delete-img-untracked": &Task{ID: "delete-img-untracked", State: "COMPLETED"},
+			"/tasks/delete-img-broken": &Task{ID: "delete-img-broken", State: "ERROR",
+				Steps: []Step{{State: "ERROR", Errors: []ApiError{{Code: "ImageInUse"}}}}},
+		}
+		for path, response := range responses {
+			server.SetResponseJsonForPath(rootUrl+path, 200, response)
+		}
+		for _, image := range responses["/images"].(*Images).Items {
+			server.SetResponseJsonForMethodPath("DELETE", rootUrl+"/images/"+image.ID, 201,
+				&Task{ID: "delete-" + image.ID, State: "QUEUED"})
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	statuses := func(report *ImageGCReport) map[string]string {
+		result := map[string]string{}
+		for _, candidate := range report.Unused {
+			result[candidate.ID] = candidate.Status
+		}
+		return result
+	}
+
+	It("reports unused images without deleting them", func() {
+		report, err := CollectUnusedImages(client, &ImageGCOptions{MinAge: 7 * 24 * time.Hour})
+		Expect(err).Should(BeNil())
+		Expect(report.Applied).Should(BeFalse())
+		Expect(report.Referenced).Should(Equal(map[string][]string{
+			"img-vm":   {"vm:vm1", "vm:vm2"},
+			"img-svc":  {"service:svc1", "vm:vm3"},
+			"img-type": {"service-type:harbor"},
+		}))
+		Expect(statuses(report)).Should(Equal(map[string]string{
+			"img-old":       ImageGCStatusUnused,
+			"img-kept":      ImageGCStatusProtected,
+			"img-new":       ImageGCStatusTooYoung,
+			"img-creating":  ImageGCStatusBusy,
+			"img-broken":    ImageGCStatusUnused,
+			"img-untracked": ImageGCStatusTooYoung,
+		}))
+
+		ids := []string{}
+		for _, candidate := range report.Unused {
+			ids = append(ids, candidate.ID)
+		}
+		Expect(ids).Should(Equal([]string{"img-kept", "img-old", "img-broken", "img-new", "img-creating", "img-untracked"}))
+
+		old := report.Unused[1]
+		Expect(old.Size).Should(Equal(int64(512 << 20)))
+		Expect(old.Age).Should(BeNumerically("~", 30*24*time.Hour, time.Minute))
+		Expect(report.Reclaimable()).Should(Equal(int64(514 << 20)))
+		Expect(report.String()).Should(MatchRegexp(`img-old\s+ubuntu-14\s+512\s+30\s+unused`))
+		Expect(deleted()).Should(BeEmpty())
+	})
+
+	It("deletes unused images and records failed deletes", func() {
+		report, err := CollectUnusedImages(client, &ImageGCOptions{Apply: true, Concurrency: 2})
+		Expect(err).Should(BeNil())
+		Expect(report.Applied).Should(BeTrue())
+
+		ids := deleted()
+		sort.Strings(ids)
+		Expect(ids).Should(Equal([]string{"img-broken", "img-new", "img-old", "img-untracked"}))
+		Expect(statuses(report)).Should(Equal(map[string]string{
+			"img-old":       ImageGCStatusDeleted,
+			"img-kept":      ImageGCStatusProtected,
+			"img-new":       ImageGCStatusDeleted,
+			"img-creating":  ImageGCStatusBusy,
+			"img-broken":    ImageGCStatusFailed,
+			"img-untracked": ImageGCStatusDeleted,
+		}))
+		for _, candidate := range report.Unused {
+			if candidate.ID == "img-broken" {
+				Expect(candidate.Error).Should(ContainSubstring("delete-img-broken"))
+			}
+		}
+		Expect(report.Reclaimable()).Should(Equal(int64(513 << 20)))
+	})
+
+	It("protects images by the tags given", func() {
+		report, err := CollectUnusedImages(client, &ImageGCOptions{ProtectTags: []string{"keep", "os:photon"}})
+		Expect(err).Should(BeNil())
+		Expect(statuses(report)["img-kept"]).Should(Equal(ImageGCStatusProtected))
+
+		// The tags given replace the default one.
+		report, err = CollectUnusedImages(client, &ImageGCOptions{ProtectTags: []string{"keep"}})
+		Expect(err).Should(BeNil())
+		Expect(statuses(report)["img-kept"]).Should(Equal(ImageGCStatusUnused))
+	})
+
+	It("deletes nothing if it cannot tell what is in use", func() {
+		server.Close()
+		server, client = mockServerClient()
+		server.SetResponseJson(403, createMockApiError("AccessForbidden", "Access forbidden", 403))
+		server.SetResponseJsonForPath(rootUrl+"/images", 200, &Images{Items: []Image{{ID: "img-old", State: ImageStateReady}}})
+		_, err := CollectUnusedImages(client, &ImageGCOptions{Apply: true})
+		Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+		Expect(server.RequestsFor("DELETE", "")).Should(BeEmpty())
+	})
+})
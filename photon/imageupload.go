@@ -0,0 +1,325 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of the image tag holding the SHA-256 digest of the uploaded file.
+const ImageChecksumTagPrefix string = "sha256:"
+
+const (
+	defaultUploadAttempts   int           = 3
+	defaultUploadRetryDelay time.Duration = time.Second
+)
+
+// Options for ImagesAPI.Upload.
+type ImageUploadOptions struct {
+	ReplicationType string
+
+	// Send the checksum tag ImageChecksumTagPrefix+digest, followed by Tags,
+	// in an ImageTags form field. The server must support tagging images on
+	// upload; servers that do not ignore the field. The digest is in the
+	// result either way.
+	SendTags bool
+
+	// Tags to set on the image besides the checksum tag, if SendTags is set.
+	Tags []string
+
+	// Called as the image is sent, with the bytes of the image sent so far
+	// and its size. A retried upload starts counting from zero again. Called
+	// from the goroutine sending the request.
+	Progress func(sent int64, total int64)
+
+	// Maximum bytes per second to send, no limit if zero.
+	BytesPerSecond int64
+
+	// Most attempts at the upload, 3 if not set. Uploads are retried when the
+	// connection fails or the server fails with a 5xx status.
+	MaxAttempts int
+
+	// How long to wait before retrying, 1 second if not set.
+	RetryDelay time.Duration
+
+	// Directory to spool readers that cannot seek to, so they can be sent
+	// again. The system's temporary directory if not set.
+	SpoolDir string
+}
+
+// Outcome of an image upload.
+type ImageUploadResult struct {
+	Task *Task
+
+	// Hex SHA-256 digest and size of the uploaded file.
+	SHA256 string
+	Size   int64
+
+	Attempts int
+}
+
+// Uploads an image from a file, as Upload does.
+func (api *ImagesAPI) UploadFile(imagePath string, options *ImageUploadOptions) (result *ImageUploadResult, err error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return api.Upload(file, filepath.Base(imagePath), options)
+}
+
+// Uploads an image, reporting progress and retrying failed attempts. The
+// SHA-256 digest of the image is computed while sending it and returned in the
+// result; with ImageUploadOptions.SendTags it is also recorded as an image tag.
+// Readers that cannot seek, such as pipes, are spooled to disk first.
+// The API takes an image in a single request, so a retry sends the whole
+// image again. Once the server has returned a task for the image, it is not
+// sent again; if the image then looks incomplete, the result holds the task
+// along with the error.
+func (api *ImagesAPI) Upload(reader io.Reader, name string, options *ImageUploadOptions) (result *ImageUploadResult, err error) {
+	if options == nil {
+		options = &ImageUploadOptions{}
+	}
+	maxAttempts := options.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultUploadAttempts
+	}
+	retryDelay := options.RetryDelay
+	if retryDelay == 0 {
+		retryDelay = defaultUploadRetryDelay
+	}
+
+	source, start, size, cleanup, err := seekableSource(reader, options.SpoolDir)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	result = &ImageUploadResult{Size: size}
+	for {
+		result.Attempts++
+		result.Task, result.SHA256, err = api.uploadOnce(source, start, name, size, options)
+		if err == nil || result.Attempts >= maxAttempts || !isRetryableUploadError(err) {
+			break
+		}
+		api.client.restClient.logger.Printf("Upload of image %s failed, retrying. Error: %s", name, err)
+		time.Sleep(retryDelay)
+	}
+	if err != nil && result.Task == nil {
+		return nil, err
+	}
+	return
+}
+
+func (api *ImagesAPI) uploadOnce(source io.ReadSeeker, start int64, name string, size int64, options *ImageUploadOptions) (task *Task, digest string, err error) {
+	restClient := api.client.restClient
+	boundary := restClient.randomBoundary()
+	var body *uploadBody
+	newBody := func() io.Reader {
+		body = &uploadBody{}
+		_, err := source.Seek(start, 0)
+		if err != nil {
+			return &failingReader{err}
+		}
+		return body.multipart(restClient, source, name, size, boundary, options)
+	}
+
+	res, err := restClient.SendRequest(&request{
+		"POST",
+		api.client.Endpoint + imageUrl,
+		fmt.Sprintf("multipart/form-data; boundary=%s", boundary),
+		newBody(),
+		api.client.options.TokenOptions,
+	}, newBody)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	task, err = getTask(getError(res))
+	if err != nil {
+		return
+	}
+	sent, digest := body.result()
+	if sent != size || digest == "" {
+		return task, digest, fmt.Errorf("photon: Sent %d of %d bytes of image %s", sent, size, name)
+	}
+	return
+}
+
+// The multipart request body of an upload: the replication type, the file
+// and, if sent, the tags. The tags follow the file, since they hold its digest.
+type uploadBody struct {
+	lock   sync.Mutex
+	sent   int64
+	digest string
+}
+
+func (body *uploadBody) multipart(restClient *restClient, source io.Reader, name string, size int64, boundary string, options *ImageUploadOptions) io.Reader {
+	digester := sha256.New()
+	file := &uploadReader{
+		reader:   io.TeeReader(io.LimitReader(source, size), digester),
+		total:    size,
+		progress: options.Progress,
+		rate:     options.BytesPerSecond,
+		counted: func(sent int64) {
+			body.lock.Lock()
+			body.sent = sent
+			body.lock.Unlock()
+		},
+	}
+
+	// Read once all of the file is sent.
+	tags := &deferredReader{create: func() io.Reader {
+		digest := hex.EncodeToString(digester.Sum(nil))
+		body.lock.Lock()
+		body.digest = digest
+		body.lock.Unlock()
+		if !options.SendTags {
+			return strings.NewReader("")
+		}
+		tags := append([]string{ImageChecksumTagPrefix + digest}, options.Tags...)
+		return restClient.createFieldPart("ImageTags", strings.Join(tags, ","), boundary)
+	}}
+
+	params := map[string]string{"ImageReplication": options.ReplicationType}
+	multiReader, _ := restClient.createMultiReader(file, name, params, boundary, tags)
+	return multiReader
+}
+
+// Returns the bytes of the file sent, and its digest once all is sent.
+func (body *uploadBody) result() (sent int64, digest string) {
+	body.lock.Lock()
+	defer body.lock.Unlock()
+	return body.sent, body.digest
+}
+
+// Connection failures and server errors are worth another attempt. Anything
+// else is not: client errors, and errors after the server accepted the image,
+// where another attempt would create the image twice.
+func isRetryableUploadError(err error) bool {
+	switch err := err.(type) {
+	case ApiError:
+		return err.HttpStatusCode >= 500
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
+
+// Returns a seekable source for the reader, its start offset and the size
+// from there. Readers that cannot seek are copied to a temporary file, which
+// cleanup removes.
+func seekableSource(reader io.Reader, spoolDir string) (source io.ReadSeeker, start int64, size int64, cleanup func(), err error) {
+	cleanup = func() {}
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		start, err = seeker.Seek(0, 1)
+		if err == nil {
+			var end int64
+			end, err = seeker.Seek(0, 2)
+			if err == nil {
+				return seeker, start, end - start, cleanup, nil
+			}
+		}
+	}
+
+	spool, err := ioutil.TempFile(spoolDir, "photon-image-")
+	if err != nil {
+		return
+	}
+	cleanup = func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	size, err = io.Copy(spool, reader)
+	if err != nil {
+		cleanup()
+		return nil, 0, 0, func() {}, err
+	}
+	return spool, 0, size, cleanup, nil
+}
+
+// Counts, reports and throttles the bytes read.
+type uploadReader struct {
+	reader   io.Reader
+	total    int64
+	progress func(sent int64, total int64)
+	counted  func(sent int64)
+
+	// Bytes per second, no limit if zero.
+	rate    int64
+	started time.Time
+
+	sent int64
+}
+
+func (r *uploadReader) Read(p []byte) (n int, err error) {
+	if r.rate > 0 {
+		if r.started.IsZero() {
+			r.started = time.Now()
+		}
+		// Read in slices of a tenth of a second, so the rate stays even.
+		chunk := r.rate / 10
+		if chunk < 1 {
+			chunk = 1
+		}
+		if int64(len(p)) > chunk {
+			p = p[:chunk]
+		}
+	}
+
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.counted(r.sent)
+		if r.progress != nil {
+			r.progress(r.sent, r.total)
+		}
+		if r.rate > 0 {
+			due := r.started.Add(time.Duration(float64(r.sent) / float64(r.rate) * float64(time.Second)))
+			if wait := due.Sub(time.Now()); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+	return
+}
+
+// Creates its reader on the first read.
+type deferredReader struct {
+	create func() io.Reader
+	reader io.Reader
+}
+
+func (r *deferredReader) Read(p []byte) (n int, err error) {
+	if r.reader == nil {
+		r.reader = r.create()
+	}
+	return r.reader.Read(p)
+}
+
+// Fails every read.
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (n int, err error) {
+	return 0, r.err
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+// Fails the upload attempts in turn: "hangup" drops the connection after part
+// of the body, "503" answers with a server error. Later attempts go through.
+type failingUploadTransport struct {
+	lock     sync.Mutex
+	failures []string
+	attempts int
+}
+
+func (t *failingUploadTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	t.attempts++
+	failure := ""
+	if len(t.failures) > 0 {
+		failure, t.failures = t.failures[0], t.failures[1:]
+	}
+	t.lock.Unlock()
+
+	switch failure {
+	case "hangup":
+		io.CopyN(ioutil.Discard, r.Body, 1000)
+		r.Body.Close()
+		return nil, io.ErrUnexpectedEOF
+	case "503":
+		io.Copy(ioutil.Discard, r.Body)
+		r.Body.Close()
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: 503,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"code": "ServiceUnavailable"}`)),
+			Request:    r,
+		}, nil
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+var _ = Describe("Image upload", func() {
+	var (
+		server    *mocks.Server
+		client    *Client
+		transport *failingUploadTransport
+		image     []byte
+		digest    string
+	)
+
+	// The files and form fields of the uploads the server received.
+	uploads := func() (files [][]byte, fields []map[string]string) {
+		for _, request := range server.RequestsFor("POST", imageUrl) {
+			file, values, err := readMultipartUpload(request)
+			Expect(err).Should(BeNil())
+			files = append(files, file)
+			fields = append(fields, values)
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping image upload test on integration mode.")
+		}
+		image = bytes.Repeat([]byte("photon image "), 10000)
+		sum := sha256.Sum256(image)
+		digest = hex.EncodeToString(sum[:])
+
+		server = mocks.NewTestServer()
+		server.SetResponseJson(200, &Task{ID: "task-1", State: "QUEUED"})
+		transport = &failingUploadTransport{}
+		client = NewTestClient(server.HttpServer.URL, &ClientOptions{TaskPollDelay: time.Millisecond},
+			&http.Client{Transport: transport})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("uploads the image with its checksum as a tag", func() {
+		var sent, total int64
+		result, err := client.Images.Upload(bytes.NewReader(image), "tty.ova", &ImageUploadOptions{
+			ReplicationType: ImageReplicationOnDemand,
+			SendTags:        true,
+			Tags:            []string{"os:photon"},
+			Progress: func(s int64, t int64) {
+				sent, total = s, t
+			},
+		})
+		Expect(err).Should(BeNil())
+		Expect(result.Task.ID).Should(Equal("task-1"))
+		Expect(result.SHA256).Should(Equal(digest))
+		Expect(result.Size).Should(Equal(int64(len(image))))
+		Expect(result.Attempts).Should(Equal(1))
+		Expect(sent).Should(Equal(int64(len(image))))
+		Expect(total).Should(Equal(int64(len(image))))
+
+		files, fields := uploads()
+		Expect(files).Should(Equal([][]byte{image}))
+		Expect(fields).Should(Equal([]map[string]string{{
+			"filename":         "tty.ova",
+			"ImageReplication": ImageReplicationOnDemand,
+			"ImageTags":        "sha256:" + digest + ",os:photon",
+		}}))
+	})
+
+	It("uploads from where a seekable reader is", func() {
+		reader := bytes.NewReader(append([]byte("header"), image...))
+		reader.Seek(6, 0)
+		transport.failures = []string{"503"}
+		result, err := client.Images.Upload(reader, "tty.ova", &ImageUploadOptions{RetryDelay: time.Millisecond})
+		Expect(err).Should(BeNil())
+		Expect(result.SHA256).Should(Equal(digest))
+		Expect(result.Attempts).Should(Equal(2))
+		files, _ := uploads()
+		Expect(files).Should(Equal([][]byte{image}))
+	})
+
+	It("spools readers that cannot seek and retries interrupted uploads", func() {
+		spoolDir, err := ioutil.TempDir("", "photon-spool")
+		Expect(err).Should(BeNil())
+		defer os.RemoveAll(spoolDir)
+
+		transport.failures = []string{"hangup", "503"}
+		pipeReader, pipeWriter := io.Pipe()
+		go func() {
+			pipeWriter.Write(image)
+			pipeWriter.Close()
+		}()
+		result, err := client.Images.Upload(pipeReader, "tty.ova", &ImageUploadOptions{
+			RetryDelay: time.Millisecond,
+			SpoolDir:   spoolDir,
+		})
+		Expect(err).Should(BeNil())
+		Expect(result.SHA256).Should(Equal(digest))
+		Expect(result.Attempts).Should(Equal(3))
+		Expect(transport.attempts).Should(Equal(3))
+		files, _ := uploads()
+		Expect(files).Should(Equal([][]byte{image}))
+
+		spooled, err := ioutil.ReadDir(spoolDir)
+		Expect(err).Should(BeNil())
+		Expect(spooled).Should(BeEmpty())
+	})
+
+	It("gives up after the last attempt", func() {
+		server.SetResponseJson(503, &ApiError{Code: "ServiceUnavailable"})
+		_, err := client.Images.Upload(bytes.NewReader(image), "tty.ova", &ImageUploadOptions{
+			MaxAttempts: 2,
+			RetryDelay:  time.Millisecond,
+		})
+		Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+		Expect(err.(ApiError).HttpStatusCode).Should(Equal(503))
+		Expect(server.RequestsFor("POST", imageUrl)).Should(HaveLen(2))
+	})
+
+	It("does not retry client errors", func() {
+		server.SetResponseJson(400, &ApiError{Code: "InvalidImage"})
+		_, err := client.Images.Upload(bytes.NewReader(image), "tty.ova", &ImageUploadOptions{RetryDelay: time.Millisecond})
+		Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+		Expect(server.RequestsFor("POST", imageUrl)).Should(HaveLen(1))
+	})
+
+	It("does not upload again once the server returned a task", func() {
+		server.SetResponse(200, "{\"id\": ")
+		_, err := client.Images.Upload(bytes.NewReader(image), "tty.ova", &ImageUploadOptions{RetryDelay: time.Millisecond})
+		Expect(err).ShouldNot(BeNil())
+		Expect(server.RequestsFor("POST", imageUrl)).Should(HaveLen(1))
+	})
+
+	It("retries transport errors and server errors only", func() {
+		Expect(isRetryableUploadError(ApiError{HttpStatusCode: 503})).Should(BeTrue())
+		Expect(isRetryableUploadError(ApiError{HttpStatusCode: 404})).Should(BeFalse())
+		Expect(isRetryableUploadError(&url.Error{Op: "Post", URL: "http://photon", Err: io.ErrUnexpectedEOF})).Should(BeTrue())
+		Expect(isRetryableUploadError(fmt.Errorf("photon: Sent 1 of 2 bytes of image tty.ova"))).Should(BeFalse())
+	})
+
+	It("limits the bandwidth", func() {
+		image = image[:20000]
+		started := time.Now()
+		_, err := client.Images.Upload(bytes.NewReader(image), "tty.ova", &ImageUploadOptions{BytesPerSecond: 50000})
+		Expect(err).Should(BeNil())
+		Expect(time.Since(started)).Should(BeNumerically(">=", 350*time.Millisecond))
+		files, _ := uploads()
+		Expect(files).Should(Equal([][]byte{image}))
+	})
+
+	It("uploads files by name", func() {
+		dir, err := ioutil.TempDir("", "photon-image")
+		Expect(err).Should(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "tty.ova")
+		Expect(ioutil.WriteFile(path, image, 0644)).Should(BeNil())
+
+		result, err := client.Images.UploadFile(path, nil)
+		Expect(err).Should(BeNil())
+		Expect(result.SHA256).Should(Equal(digest))
+		_, fields := uploads()
+		Expect(fields[0]["filename"]).Should(Equal("tty.ova"))
+	})
+
+	It("sends tags only if asked to", func() {
+		result, err := client.Images.Upload(bytes.NewReader(image), "tty.ova", &ImageUploadOptions{
+			ReplicationType: ImageReplicationEager,
+			Tags:            []string{"os:photon"},
+		})
+		Expect(err).Should(BeNil())
+		Expect(result.SHA256).Should(Equal(digest))
+		files, fields := uploads()
+		Expect(files).Should(Equal([][]byte{image}))
+		Expect(fields).Should(Equal([]map[string]string{{
+			"filename":         "tty.ova",
+			"ImageReplication": ImageReplicationEager,
+		}}))
+	})
+})
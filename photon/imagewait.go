@@ -0,0 +1,176 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options for ImagesAPI.WaitForReady.
+type ImageWaitOptions struct {
+	// Also wait for the image to be seeded to all datastores, not only
+	// replicated to the image datastores.
+	WaitForSeeding bool
+
+	// How long to wait, ClientOptions.TaskPollTimeout if not set.
+	Timeout time.Duration
+
+	// Called with the progress after every poll of the image.
+	Progress func(progress *ImageProgress)
+
+	// Closing the channel stops the wait.
+	Cancel <-chan struct{}
+}
+
+// Progress of an image towards being ready for use.
+type ImageProgress struct {
+	ID              string
+	State           string
+	ReplicationType string
+
+	// Percent of the image datastores the image is replicated to, and of all
+	// datastores it is seeded to.
+	Replication float64
+	Seeding     float64
+
+	Elapsed time.Duration
+}
+
+// Returned by WaitForReady when the image is not ready in time.
+type ImageWaitTimeoutError struct {
+	ID       string
+	Progress ImageProgress
+}
+
+// Implement Go error interface for ImageWaitTimeoutError.
+func (e ImageWaitTimeoutError) Error() string {
+	return fmt.Sprintf("photon: Timed out waiting for image '%s', state %s, replication %s%%, seeding %s%%",
+		e.ID, e.Progress.State, formatQuotaValue(e.Progress.Replication), formatQuotaValue(e.Progress.Seeding))
+}
+
+// Returned by WaitForReady when the wait is canceled.
+type ImageWaitCanceledError struct {
+	ID string
+}
+
+// Implement Go error interface for ImageWaitCanceledError.
+func (e ImageWaitCanceledError) Error() string {
+	return fmt.Sprintf("photon: Canceled waiting for image '%s'", e.ID)
+}
+
+// Parses the replication or seeding progress of an image, in percent: "50%",
+// "50.0%" or a fraction like "1/2". No progress reported counts as none made.
+func ParseImageProgress(progress string) (percent float64, err error) {
+	progress = strings.TrimSpace(progress)
+	if progress == "" {
+		return 0, nil
+	}
+	if slash := strings.Index(progress, "/"); slash >= 0 {
+		var done, total float64
+		done, err = strconv.ParseFloat(strings.TrimSpace(progress[:slash]), 64)
+		if err == nil {
+			total, err = strconv.ParseFloat(strings.TrimSpace(progress[slash+1:]), 64)
+		}
+		if err != nil || total <= 0 {
+			return 0, fmt.Errorf("photon: Invalid image progress '%s'", progress)
+		}
+		return 100 * done / total, nil
+	}
+	percent, err = strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(progress, "%")), 64)
+	if err != nil {
+		return 0, fmt.Errorf("photon: Invalid image progress '%s'", progress)
+	}
+	return
+}
+
+// Waits until an image is READY and, if eager, replicated to all image
+// datastores, and with WaitForSeeding also seeded to all datastores. On demand
+// images are replicated as they are used, so they need not be replicated
+// before they are ready for use. The API does not list the datastores an image
+// is on, so its replication and seeding progress are the only signal: 100% is
+// taken as on all image datastores, and progress that cannot be parsed as none
+// made yet. Returns an
+// ImageNotReadyError if the image fails, ImageWaitTimeoutError or
+// ImageWaitCanceledError if the wait ends first. Errors other than API errors
+// are retried ClientOptions.TaskRetryCount times, as waiting for tasks does.
+func (api *ImagesAPI) WaitForReady(id string, options *ImageWaitOptions) (image *Image, err error) {
+	if options == nil {
+		options = &ImageWaitOptions{}
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = api.client.options.TaskPollTimeout
+	}
+
+	start := time.Now()
+	numErrors := 0
+	progress := ImageProgress{ID: id}
+	for {
+		select {
+		case <-options.Cancel:
+			return nil, ImageWaitCanceledError{id}
+		default:
+		}
+
+		image, err = api.Get(id)
+		if err != nil {
+			if _, ok := err.(ApiError); ok {
+				return nil, err
+			}
+			numErrors++
+			if numErrors > api.client.options.TaskRetryCount {
+				return nil, err
+			}
+		} else {
+			numErrors = 0
+			progress = getImageProgress(image, time.Since(start))
+			if options.Progress != nil {
+				options.Progress(&progress)
+			}
+
+			switch image.State {
+			case ImageStateReady:
+				replicated := image.ReplicationType == ImageReplicationOnDemand || progress.Replication >= 100
+				if replicated && (!options.WaitForSeeding || progress.Seeding >= 100) {
+					return image, nil
+				}
+			case ImageStateError, ImageStatePendingDelete:
+				return nil, ImageNotReadyError{id, image.State}
+			}
+		}
+
+		if time.Since(start) >= timeout {
+			return nil, ImageWaitTimeoutError{id, progress}
+		}
+		select {
+		case <-options.Cancel:
+			return nil, ImageWaitCanceledError{id}
+		case <-time.After(api.client.options.TaskPollDelay):
+		}
+	}
+}
+
+// Progress that cannot be parsed counts as none, so the wait goes on until the
+// image reports progress it can tell is complete.
+func getImageProgress(image *Image, elapsed time.Duration) ImageProgress {
+	replication, _ := ParseImageProgress(image.ReplicationProgress)
+	seeding, _ := ParseImageProgress(image.SeedingProgress)
+	return ImageProgress{
+		ID:              image.ID,
+		State:           image.State,
+		ReplicationType: image.ReplicationType,
+		Replication:     replication,
+		Seeding:         seeding,
+		Elapsed:         elapsed,
+	}
+}
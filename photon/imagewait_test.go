@@ -0,0 +1,148 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("Image wait", func() {
+	var (
+		server *mocks.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping image wait test on integration mode.")
+		}
+		server, client = mockServerClient()
+		server.SetResponseJson(404, createMockApiError("NotFound", "Not found", 404))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	image := func(state string, replication string, seeding string) *Image {
+		return &Image{ID: "img-1", State: state, ReplicationType: ImageReplicationEager,
+			ReplicationProgress: replication, SeedingProgress: seeding}
+	}
+
+	// Image returned by each poll; the last one is repeated.
+	polls := func(images ...interface{}) {
+		server.SetResponsesJsonForPath(rootUrl+"/images/img-1", 200, images...)
+	}
+
+	It("parses progress", func() {
+		for progress, percent := range map[string]float64{"": 0, "50%": 50, " 33.5% ": 33.5, "100.0%": 100, "1/4": 25, "2 / 2": 100} {
+			parsed, err := ParseImageProgress(progress)
+			Expect(err).Should(BeNil())
+			Expect(parsed).Should(Equal(percent))
+		}
+		for _, progress := range []string{"half", "1/0", "%"} {
+			_, err := ParseImageProgress(progress)
+			Expect(err).ShouldNot(BeNil())
+		}
+	})
+
+	It("waits for the image to be ready and replicated, reporting progress", func() {
+		polls(
+			image(ImageStateCreating, "", ""),
+			image(ImageStateReady, "50%", "10%"),
+			image(ImageStateReady, "100%", "20%"),
+		)
+		reported := []float64{}
+		result, err := client.Images.WaitForReady("img-1", &ImageWaitOptions{
+			Progress: func(progress *ImageProgress) {
+				Expect(progress.ID).Should(Equal("img-1"))
+				reported = append(reported, progress.Replication)
+			},
+		})
+		Expect(err).Should(BeNil())
+		Expect(result.ReplicationProgress).Should(Equal("100%"))
+		Expect(reported).Should(Equal([]float64{0, 50, 100}))
+	})
+
+	It("does not wait for on demand images to be replicated", func() {
+		onDemand := image(ImageStateReady, "0%", "")
+		onDemand.ReplicationType = ImageReplicationOnDemand
+		polls(image(ImageStateCreating, "", ""), onDemand)
+		result, err := client.Images.WaitForReady("img-1", nil)
+		Expect(err).Should(BeNil())
+		Expect(result.ReplicationType).Should(Equal(ImageReplicationOnDemand))
+	})
+
+	It("waits for seeding if asked to", func() {
+		polls(
+			image(ImageStateReady, "100%", "1/3"),
+			image(ImageStateReady, "100%", "3/3"),
+		)
+		result, err := client.Images.WaitForReady("img-1", &ImageWaitOptions{WaitForSeeding: true})
+		Expect(err).Should(BeNil())
+		Expect(result.SeedingProgress).Should(Equal("3/3"))
+		Expect(server.RequestsFor("GET", rootUrl+"/images/img-1")).Should(HaveLen(2))
+	})
+
+	It("fails when the image fails", func() {
+		polls(image(ImageStateCreating, "", ""), image(ImageStateError, "", ""))
+		_, err := client.Images.WaitForReady("img-1", nil)
+		Expect(err).Should(Equal(ImageNotReadyError{"img-1", ImageStateError}))
+
+		_, err = client.Images.WaitForReady("img-2", nil)
+		Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+	})
+
+	It("times out with the last progress", func() {
+		polls(image(ImageStateReady, "2/3", ""))
+		_, err := client.Images.WaitForReady("img-1", &ImageWaitOptions{Timeout: 20 * time.Millisecond})
+		Expect(err).Should(BeAssignableToTypeOf(ImageWaitTimeoutError{}))
+		progress := err.(ImageWaitTimeoutError).Progress
+		Expect(progress.State).Should(Equal(ImageStateReady))
+		Expect(progress.Replication).Should(BeNumerically("~", 66.67, 0.01))
+		Expect(err.Error()).Should(ContainSubstring("replication 66.667%"))
+	})
+
+	It("stops when canceled", func() {
+		polls(image(ImageStateCreating, "", ""))
+		cancel := make(chan struct{})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			close(cancel)
+		}()
+		_, err := client.Images.WaitForReady("img-1", &ImageWaitOptions{Cancel: cancel})
+		Expect(err).Should(Equal(ImageWaitCanceledError{"img-1"}))
+	})
+
+	It("takes progress it cannot parse as not replicated yet", func() {
+		polls(image(ImageStateReady, "most", ""), image(ImageStateReady, "100%", ""))
+		result, err := client.Images.WaitForReady("img-1", nil)
+		Expect(err).Should(BeNil())
+		Expect(result.ReplicationProgress).Should(Equal("100%"))
+		Expect(server.RequestsFor("GET", rootUrl+"/images/img-1")).Should(HaveLen(2))
+
+		polls(image(ImageStateReady, "most", ""))
+		_, err = client.Images.WaitForReady("img-1", &ImageWaitOptions{Timeout: 20 * time.Millisecond})
+		Expect(err).Should(BeAssignableToTypeOf(ImageWaitTimeoutError{}))
+		Expect(err.(ImageWaitTimeoutError).Progress.Replication).Should(BeZero())
+	})
+
+	It("takes only full replication as replicated to all image datastores", func() {
+		polls(image(ImageStateReady, "2/3", ""), image(ImageStateReady, "99.9%", ""), image(ImageStateReady, "3/3", ""))
+		result, err := client.Images.WaitForReady("img-1", nil)
+		Expect(err).Should(BeNil())
+		Expect(result.ReplicationProgress).Should(Equal("3/3"))
+		Expect(server.RequestsFor("GET", rootUrl+"/images/img-1")).Should(HaveLen(3))
+	})
+})
@@ -12,22 +12,50 @@ package mocks
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type ServerResponseData struct {
 	StatuCode *int
 	Body      *string
+
+	// Bodies for the following requests, one each; the last one is repeated.
+	// Their status codes are in NextStatus, if they differ.
+	Next       []string
+	NextStatus []int
+
+	// Computes the response from the request instead, if set.
+	Func func(request Request) (status int, v interface{})
+}
+
+// A status code and the value to answer with as JSON.
+type JsonResponse struct {
+	StatusCode int
+	Value      interface{}
+}
+
+// A request the server received.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
 }
 
 type Server struct {
 	HttpServer      *httptest.Server
 	DefaultResponse *ServerResponseData
 	Responses       map[string]*ServerResponseData
+
+	lock     sync.Mutex
+	requests []Request
 }
 
 func newUnstartedTestServer() (server *Server) {
@@ -35,20 +63,36 @@ func newUnstartedTestServer() (server *Server) {
 	body := ""
 
 	server = &Server{
-		nil,
-		&ServerResponseData{&status, &body},
-		make(map[string]*ServerResponseData),
+		DefaultResponse: &ServerResponseData{StatuCode: &status, Body: &body},
+		Responses:       make(map[string]*ServerResponseData),
 	}
 
 	server.HttpServer = httptest.NewUnstartedServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
+			requestBody, _ := ioutil.ReadAll(r.Body)
+
+			request := Request{r.Method, r.URL.Path, r.URL.Query(), r.Header, string(requestBody)}
+			server.lock.Lock()
+			server.requests = append(server.requests, request)
 
+			// The longest matching path wins, so that a path and the paths
+			// under it can be given different responses, and a response for
+			// the method wins over one for any method.
 			var response *ServerResponseData
+			best := -1
 			for k, v := range server.Responses {
-				if strings.HasPrefix(r.URL.Path, k) {
+				path, score := k, 0
+				if i := strings.Index(k, " "); i >= 0 {
+					if k[:i] != r.Method {
+						continue
+					}
+					path, score = k[i+1:], 1
+				}
+				score += 2 * len(path)
+				if strings.HasPrefix(r.URL.Path, path) && score > best {
 					response = v
-					break
+					best = score
 				}
 			}
 
@@ -56,8 +100,30 @@ func newUnstartedTestServer() (server *Server) {
 				response = server.DefaultResponse
 			}
 
-			w.WriteHeader(*response.StatuCode)
-			fmt.Fprintln(w, *response.Body)
+			respond := response.Func
+			status, body := 0, ""
+			if respond == nil {
+				status, body = *response.StatuCode, *response.Body
+			}
+			if len(response.Next) > 0 {
+				next := response.Next[0]
+				response.Body = &next
+				response.Next = response.Next[1:]
+			}
+			if len(response.NextStatus) > 0 {
+				next := response.NextStatus[0]
+				response.StatuCode = &next
+				response.NextStatus = response.NextStatus[1:]
+			}
+			server.lock.Unlock()
+
+			if respond != nil {
+				var v interface{}
+				status, v = respond(request)
+				body = server.toJson(v)
+			}
+			w.WriteHeader(status)
+			fmt.Fprintln(w, body)
 		}))
 	return
 }
@@ -81,6 +147,8 @@ func (s *Server) Close() {
 }
 
 func (s *Server) SetResponse(status int, body string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.DefaultResponse = &ServerResponseData{StatuCode: &status, Body: &body}
 }
 
@@ -89,13 +157,77 @@ func (s *Server) SetResponseJson(status int, v interface{}) {
 }
 
 func (s *Server) SetResponseForPath(path string, status int, body string) {
-	s.Responses[path] = &ServerResponseData{&status, &body}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Responses[path] = &ServerResponseData{StatuCode: &status, Body: &body}
 }
 
 func (s *Server) SetResponseJsonForPath(path string, status int, v interface{}) {
 	s.SetResponseForPath(path, status, s.toJson(v))
 }
 
+// Sets the response to requests with the method for path, which wins over
+// a response for the path set with SetResponseJsonForPath.
+func (s *Server) SetResponseJsonForMethodPath(method string, path string, status int, v interface{}) {
+	s.SetResponseForPath(method+" "+path, status, s.toJson(v))
+}
+
+// Answers the requests for path with each of vs in turn, repeating the last.
+func (s *Server) SetResponsesJsonForPath(path string, status int, vs ...interface{}) {
+	bodies := make([]string, len(vs))
+	for i, v := range vs {
+		bodies[i] = s.toJson(v)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Responses[path] = &ServerResponseData{StatuCode: &status, Body: &bodies[0], Next: bodies[1:]}
+}
+
+// Answers the requests for path with each of the responses in turn,
+// repeating the last.
+func (s *Server) SetJsonResponsesForPath(path string, responses ...JsonResponse) {
+	bodies := make([]string, len(responses))
+	statuses := make([]int, len(responses))
+	for i, response := range responses {
+		bodies[i] = s.toJson(response.Value)
+		statuses[i] = response.StatusCode
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Responses[path] = &ServerResponseData{
+		StatuCode:  &statuses[0],
+		Body:       &bodies[0],
+		Next:       bodies[1:],
+		NextStatus: statuses[1:],
+	}
+}
+
+// Answers the requests for path with what respond returns for each, e.g. to
+// echo a value the client sent.
+func (s *Server) SetResponseFuncForPath(path string, respond func(request Request) (status int, v interface{})) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Responses[path] = &ServerResponseData{Func: respond}
+}
+
+// Returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Returns the requests received so far with the method and a path starting
+// with prefix.
+func (s *Server) RequestsFor(method string, prefix string) (requests []Request) {
+	for _, r := range s.Requests() {
+		if r.Method == method && strings.HasPrefix(r.Path, prefix) {
+			requests = append(requests, r)
+		}
+	}
+	return
+}
+
 func (s *Server) GetAddressAndPort() (address string, port int, err error) {
 	serverURL, err := url.Parse(s.HttpServer.URL)
 	if err != nil {
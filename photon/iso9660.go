@@ -0,0 +1,341 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Writer for small ISO 9660 images with a single directory, as needed for
+// seed images. Names are kept by both Joliet and Rock Ridge extensions, so the
+// image reads the same on Windows and Linux.
+
+const isoSectorSize int = 2048
+
+// Sectors of the fixed part of the image: 16 reserved sectors, primary and
+// Joliet volume descriptors, terminator, then the path tables.
+const (
+	isoPrimaryDescriptorSector uint32 = 16
+	isoJolietDescriptorSector  uint32 = 17
+	isoTerminatorSector        uint32 = 18
+	isoPathTablesSector        uint32 = 19
+	isoRootSector              uint32 = 23
+)
+
+// POSIX modes recorded in the Rock Ridge PX entries.
+const (
+	isoFileMode uint32 = 0100444
+	isoDirMode  uint32 = 040555
+)
+
+type isoFile struct {
+	name string
+	data []byte
+}
+
+// Builds an image with the given volume label and files in the root directory.
+func buildISO(label string, files []isoFile, modTime time.Time) (image []byte, err error) {
+	if len(label) > 16 {
+		return nil, fmt.Errorf("photon: ISO volume label '%s' is longer than 16 characters", label)
+	}
+	for _, file := range files {
+		if len(file.name) == 0 || len(file.name) > 64 || strings.ContainsAny(file.name, "/\\;") {
+			return nil, fmt.Errorf("photon: Invalid ISO file name '%s'", file.name)
+		}
+	}
+
+	primaryNames := isoPrimaryNames(files)
+	modTime = modTime.UTC()
+
+	// Directory sizes do not depend on where things are, so lay out the
+	// directories with dummy locations first.
+	extents := make([]uint32, len(files))
+	primaryRoot := isoPrimaryDirectory(files, primaryNames, extents, 0, 0, modTime)
+	jolietRoot := isoJolietDirectory(files, extents, 0, 0, modTime)
+
+	primaryRootSector := isoRootSector
+	jolietRootSector := primaryRootSector + isoSectors(len(primaryRoot))
+	next := jolietRootSector + isoSectors(len(jolietRoot))
+	for idx, file := range files {
+		extents[idx] = next
+		next += isoSectors(len(file.data))
+	}
+	totalSectors := next
+
+	primaryRoot = isoPrimaryDirectory(files, primaryNames, extents, primaryRootSector, len(primaryRoot), modTime)
+	jolietRoot = isoJolietDirectory(files, extents, jolietRootSector, len(jolietRoot), modTime)
+
+	image = make([]byte, int(totalSectors)*isoSectorSize)
+	sector := func(n uint32) []byte {
+		return image[int(n)*isoSectorSize:]
+	}
+
+	isoVolumeDescriptor(sector(isoPrimaryDescriptorSector), 1, []byte(label), isoPadding(' '),
+		totalSectors, isoPathTablesSector, isoPathTablesSector+1,
+		isoDirRecord([]byte{0}, primaryRootSector, uint32(len(primaryRoot)), true, modTime, nil), modTime)
+	isoVolumeDescriptor(sector(isoJolietDescriptorSector), 2, isoUCS2(label), isoPadding(0, ' '),
+		totalSectors, isoPathTablesSector+2, isoPathTablesSector+3,
+		isoDirRecord([]byte{0}, jolietRootSector, uint32(len(jolietRoot)), true, modTime, nil), modTime)
+
+	terminator := sector(isoTerminatorSector)
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	isoPathTables(sector(isoPathTablesSector), sector(isoPathTablesSector+1), primaryRootSector)
+	isoPathTables(sector(isoPathTablesSector+2), sector(isoPathTablesSector+3), jolietRootSector)
+
+	copy(sector(primaryRootSector), primaryRoot)
+	copy(sector(jolietRootSector), jolietRoot)
+	for idx, file := range files {
+		copy(sector(extents[idx]), file.data)
+	}
+	return
+}
+
+// Returns the 8.3 upper case names of the files in the primary volume, the long
+// names are kept in Rock Ridge NM entries.
+func isoPrimaryNames(files []isoFile) []string {
+	names := make([]string, len(files))
+	used := map[string]bool{}
+	for idx, file := range files {
+		base, ext := file.name, ""
+		if dot := strings.LastIndex(base, "."); dot > 0 {
+			base, ext = base[:dot], base[dot+1:]
+		}
+		base, ext = isoDChars(base, 8), isoDChars(ext, 3)
+
+		name := base + "." + ext
+		for n := 1; used[name]; n++ {
+			suffix := fmt.Sprintf("%d", n)
+			trimmed := base
+			if len(trimmed)+len(suffix) > 8 {
+				trimmed = trimmed[:8-len(suffix)]
+			}
+			name = trimmed + suffix + "." + ext
+		}
+		used[name] = true
+		names[idx] = name + ";1"
+	}
+	return names
+}
+
+// Maps a name to upper case letters, digits and underscores.
+func isoDChars(name string, maxLen int) string {
+	chars := []byte(strings.ToUpper(name))
+	for idx, c := range chars {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			chars[idx] = '_'
+		}
+	}
+	if len(chars) > maxLen {
+		chars = chars[:maxLen]
+	}
+	return string(chars)
+}
+
+func isoUCS2(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for idx, unit := range units {
+		binary.BigEndian.PutUint16(b[2*idx:], unit)
+	}
+	return b
+}
+
+func isoSectors(size int) uint32 {
+	return uint32((size + isoSectorSize - 1) / isoSectorSize)
+}
+
+func isoPadding(pattern ...byte) func([]byte) {
+	return func(b []byte) {
+		for idx := range b {
+			b[idx] = pattern[idx%len(pattern)]
+		}
+	}
+}
+
+func isoPutBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func isoPutBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func isoVolumeDescriptor(
+	b []byte, descriptorType byte, label []byte, pad func([]byte),
+	totalSectors uint32, lPathTable uint32, mPathTable uint32, rootRecord []byte, modTime time.Time) {
+
+	b[0] = descriptorType
+	copy(b[1:], "CD001")
+	b[6] = 1
+
+	// Identifiers are padded with spaces, UCS-2 spaces for Joliet.
+	for _, field := range [][2]int{{8, 32}, {40, 32}, {190, 128}, {318, 128}, {446, 128}, {574, 128}, {702, 37}, {739, 37}, {776, 37}} {
+		pad(b[field[0] : field[0]+field[1]])
+	}
+	copy(b[40:72], label)
+
+	isoPutBoth32(b[80:], totalSectors)
+	if descriptorType == 2 {
+		// Joliet UCS-2 level 3.
+		copy(b[88:], "%/E")
+	}
+	isoPutBoth16(b[120:], 1)
+	isoPutBoth16(b[124:], 1)
+	isoPutBoth16(b[128:], uint16(isoSectorSize))
+	isoPutBoth32(b[132:], isoPathTableSize)
+	binary.LittleEndian.PutUint32(b[140:], lPathTable)
+	binary.BigEndian.PutUint32(b[148:], mPathTable)
+	copy(b[156:190], rootRecord)
+
+	date := []byte(modTime.Format("20060102150405") + "00\x00")
+	copy(b[813:], date)
+	copy(b[830:], date)
+	copy(b[847:], "0000000000000000\x00")
+	copy(b[864:], date)
+	b[881] = 1
+}
+
+// Size of a path table holding only the root directory.
+const isoPathTableSize uint32 = 10
+
+func isoPathTables(l []byte, m []byte, rootSector uint32) {
+	// The identifier of the root is a single zero byte.
+	l[0] = 1
+	m[0] = 1
+	binary.LittleEndian.PutUint32(l[2:], rootSector)
+	binary.LittleEndian.PutUint16(l[6:], 1)
+	binary.BigEndian.PutUint32(m[2:], rootSector)
+	binary.BigEndian.PutUint16(m[6:], 1)
+}
+
+func isoDirRecord(ident []byte, extent uint32, size uint32, dir bool, modTime time.Time, systemUse []byte) []byte {
+	identEnd := 33 + len(ident)
+	if len(ident)%2 == 0 {
+		identEnd++
+	}
+	length := identEnd + len(systemUse)
+	if length%2 == 1 {
+		length++
+	}
+
+	r := make([]byte, length)
+	r[0] = byte(length)
+	isoPutBoth32(r[2:], extent)
+	isoPutBoth32(r[10:], size)
+	r[18] = byte(modTime.Year() - 1900)
+	r[19] = byte(modTime.Month())
+	r[20] = byte(modTime.Day())
+	r[21] = byte(modTime.Hour())
+	r[22] = byte(modTime.Minute())
+	r[23] = byte(modTime.Second())
+	if dir {
+		r[25] = 2
+	}
+	isoPutBoth16(r[28:], 1)
+	r[32] = byte(len(ident))
+	copy(r[33:], ident)
+	copy(r[identEnd:], systemUse)
+	return r
+}
+
+// Packs directory records into sectors; records may not cross a sector boundary.
+func isoPackRecords(records [][]byte) []byte {
+	var dir []byte
+	for _, r := range records {
+		if used := len(dir) % isoSectorSize; used+len(r) > isoSectorSize {
+			dir = append(dir, make([]byte, isoSectorSize-used)...)
+		}
+		dir = append(dir, r...)
+	}
+	return append(dir, make([]byte, int(isoSectors(len(dir)))*isoSectorSize-len(dir))...)
+}
+
+// Rock Ridge entries
+
+// SUSP SP entry, marking the use of Rock Ridge in the first record of the root.
+var isoSPEntry = []byte{'S', 'P', 7, 1, 0xBE, 0xEF, 0}
+
+func isoPXEntry(mode uint32, links uint32) []byte {
+	e := make([]byte, 36)
+	copy(e, "PX")
+	e[2] = 36
+	e[3] = 1
+	isoPutBoth32(e[4:], mode)
+	isoPutBoth32(e[12:], links)
+	return e
+}
+
+func isoNMEntry(name string) []byte {
+	return append([]byte{'N', 'M', byte(5 + len(name)), 1, 0}, name...)
+}
+
+type isoRecordsByName struct {
+	names   [][]byte
+	records [][]byte
+}
+
+func (r isoRecordsByName) Len() int { return len(r.names) }
+func (r isoRecordsByName) Swap(i, j int) {
+	r.names[i], r.names[j] = r.names[j], r.names[i]
+	r.records[i], r.records[j] = r.records[j], r.records[i]
+}
+func (r isoRecordsByName) Less(i, j int) bool { return string(r.names[i]) < string(r.names[j]) }
+
+// Builds the root directory of a volume, its "." and ".." records followed by
+// the file records sorted by identifier.
+func isoDirectory(
+	idents [][]byte, systemUses [][]byte, dotSystemUse []byte, dotDotSystemUse []byte,
+	files []isoFile, extents []uint32, self uint32, selfSize int, modTime time.Time) []byte {
+
+	sorted := isoRecordsByName{names: idents, records: make([][]byte, len(files))}
+	for idx, file := range files {
+		sorted.records[idx] = isoDirRecord(idents[idx], extents[idx], uint32(len(file.data)), false, modTime, systemUses[idx])
+	}
+	sort.Sort(sorted)
+
+	records := [][]byte{
+		isoDirRecord([]byte{0}, self, uint32(selfSize), true, modTime, dotSystemUse),
+		isoDirRecord([]byte{1}, self, uint32(selfSize), true, modTime, dotDotSystemUse),
+	}
+	return isoPackRecords(append(records, sorted.records...))
+}
+
+func isoPrimaryDirectory(
+	files []isoFile, names []string, extents []uint32, self uint32, selfSize int, modTime time.Time) []byte {
+
+	idents := make([][]byte, len(files))
+	systemUses := make([][]byte, len(files))
+	for idx, file := range files {
+		idents[idx] = []byte(names[idx])
+		systemUses[idx] = append(isoPXEntry(isoFileMode, 1), isoNMEntry(file.name)...)
+	}
+	dirPX := isoPXEntry(isoDirMode, 2)
+	return isoDirectory(idents, systemUses, append(append([]byte{}, isoSPEntry...), dirPX...), dirPX,
+		files, extents, self, selfSize, modTime)
+}
+
+func isoJolietDirectory(files []isoFile, extents []uint32, self uint32, selfSize int, modTime time.Time) []byte {
+	idents := make([][]byte, len(files))
+	systemUses := make([][]byte, len(files))
+	for idx, file := range files {
+		idents[idx] = isoUCS2(file.name)
+	}
+	return isoDirectory(idents, systemUses, nil, nil, files, extents, self, selfSize, modTime)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"sync"
+)
+
+// Runs jobs such as the API requests of a walk over the deployment, at most
+// cap(slots) at a time. Jobs may spawn further jobs, e.g. for the entities
+// they list. Once a job fails, jobs not yet started are skipped.
+type jobRunner struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	errLock sync.Mutex
+	err     error
+}
+
+func newJobRunner(concurrency int) *jobRunner {
+	return &jobRunner{slots: make(chan struct{}, concurrency)}
+}
+
+func (r *jobRunner) spawn(job func() error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.slots <- struct{}{}
+		defer func() { <-r.slots }()
+
+		if r.failed() {
+			return
+		}
+		if err := job(); err != nil {
+			r.errLock.Lock()
+			if r.err == nil {
+				r.err = err
+			}
+			r.errLock.Unlock()
+		}
+	}()
+}
+
+func (r *jobRunner) failed() bool {
+	r.errLock.Lock()
+	defer r.errLock.Unlock()
+	return r.err != nil
+}
+
+// Waits for all jobs and returns the first error.
+func (r *jobRunner) wait() error {
+	r.wg.Wait()
+	return r.err
+}
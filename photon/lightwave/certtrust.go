@@ -0,0 +1,324 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options deciding which root certificates downloaded from Lightwave are trusted.
+// At least one of Fingerprints or TOFUStorePath must be set.
+type CertTrustOptions struct {
+	// SHA-256 fingerprints of the expected root certificates. Fingerprints are
+	// hex encoded; colons, spaces and letter case are ignored.
+	Fingerprints []string
+
+	// Path of a trust-on-first-use store. The first time an endpoint is seen
+	// the fingerprints of its certificates are recorded in this file, and later
+	// downloads must match the recorded fingerprints.
+	TOFUStorePath string
+}
+
+// Returned when a root certificate downloaded from Lightwave is not trusted.
+type CertTrustError struct {
+	Endpoint    string
+	Subject     string
+	Fingerprint string
+}
+
+func (e CertTrustError) Error() string {
+	return fmt.Sprintf(
+		"Root certificate '%s' from '%s' with SHA-256 fingerprint %s does not match any trusted fingerprint",
+		e.Subject, e.Endpoint, e.Fingerprint)
+}
+
+// Returns the SHA-256 fingerprint of the certificate in the usual
+// colon separated upper case hex format, e.g. "AB:CD:...".
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for idx, b := range sum {
+		parts[idx] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Builds a cert pool out of the given certificates. The result can be used
+// for ClientOptions.RootCAs and OIDCClientOptions.RootCAs.
+func NewCertPool(certList []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certList {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// Downloads the root certificates from Lightwave and verifies each of them against
+// the trust options. Since there is no trusted certificate yet, the download itself
+// is made without TLS verification; the fingerprints are what makes the result safe.
+func (client *OIDCClient) GetTrustedRootCerts(trust *CertTrustOptions) (certList []*x509.Certificate, err error) {
+	err = checkTrustOptions(trust)
+	if err != nil {
+		return
+	}
+
+	certList, err = client.GetRootCerts()
+	if err != nil {
+		return
+	}
+
+	err = client.verifyRootCerts(certList, trust, false)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func checkTrustOptions(trust *CertTrustOptions) error {
+	if trust == nil || (len(trust.Fingerprints) == 0 && trust.TOFUStorePath == "") {
+		return errors.New("No trusted fingerprints or TOFU store configured")
+	}
+	return nil
+}
+
+// Verifies the certificates against the trust options. If authenticated is true the
+// certificates were downloaded over a connection verified with already trusted roots,
+// and with a TOFU store, newly published certificates are accepted and recorded.
+// Explicitly configured fingerprints are never extended this way.
+func (client *OIDCClient) verifyRootCerts(certList []*x509.Certificate, trust *CertTrustOptions, authenticated bool) (err error) {
+	if len(certList) == 0 {
+		return fmt.Errorf("No root certificates returned by '%s'", client.Endpoint)
+	}
+
+	trusted := map[string]bool{}
+	for _, fingerprint := range trust.Fingerprints {
+		trusted[normalizeFingerprint(fingerprint)] = true
+	}
+
+	var store tofuStore
+	if trust.TOFUStorePath != "" {
+		store, err = loadTOFUStore(trust.TOFUStorePath)
+		if err != nil {
+			return
+		}
+
+		entries := store[client.Endpoint]
+		if len(entries) == 0 && len(trust.Fingerprints) == 0 {
+			// First use, trust whatever the server has.
+			store[client.Endpoint] = newTOFUEntries(certList)
+			return store.save(trust.TOFUStorePath)
+		}
+		for _, entry := range entries {
+			trusted[normalizeFingerprint(entry.Fingerprint)] = true
+		}
+	}
+
+	changed := false
+	for _, cert := range certList {
+		fingerprint := CertFingerprint(cert)
+		if trusted[normalizeFingerprint(fingerprint)] {
+			continue
+		}
+		if authenticated && store != nil && len(trust.Fingerprints) == 0 {
+			store[client.Endpoint] = append(store[client.Endpoint], newTOFUEntries([]*x509.Certificate{cert})...)
+			changed = true
+			continue
+		}
+		return CertTrustError{client.Endpoint, cert.Subject.String(), fingerprint}
+	}
+
+	if changed {
+		err = store.save(trust.TOFUStorePath)
+	}
+	return
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.Replace(fingerprint, ":", "", -1)
+	fingerprint = strings.Replace(fingerprint, " ", "", -1)
+	return strings.ToLower(fingerprint)
+}
+
+// TOFU store helpers
+
+// Maps a Lightwave endpoint to the certificates trusted for it.
+type tofuStore map[string][]tofuEntry
+
+type tofuEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Subject     string `json:"subject"`
+}
+
+func newTOFUEntries(certList []*x509.Certificate) (entries []tofuEntry) {
+	for _, cert := range certList {
+		entries = append(entries, tofuEntry{CertFingerprint(cert), cert.Subject.String()})
+	}
+	return
+}
+
+func loadTOFUStore(path string) (store tofuStore, err error) {
+	store = tofuStore{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return
+	}
+
+	err = json.Unmarshal(data, &store)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TOFU store '%s': %v", path, err)
+	}
+	return
+}
+
+func (store tofuStore) save(path string) (err error) {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Root cert rotation
+
+// Called by RootCertWatcher with the new certificates when the set of trusted root
+// certificates changes, or with an error when a check fails.
+type RootCertCallback func(certList []*x509.Certificate, err error)
+
+// Periodically downloads the root certificates from Lightwave to pick up rotated
+// certificates. Downloads are made over a connection verified with the currently
+// trusted certificates, and the result goes through the same trust options.
+type RootCertWatcher struct {
+	client   *OIDCClient
+	trust    *CertTrustOptions
+	callback RootCertCallback
+
+	lock     sync.RWMutex
+	certList []*x509.Certificate
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Starts watching for rotated root certificates every interval, starting from the
+// given trusted certificates, usually the result of GetTrustedRootCerts.
+// The callback may be nil.
+func (client *OIDCClient) WatchRootCerts(
+	trust *CertTrustOptions,
+	certList []*x509.Certificate,
+	interval time.Duration,
+	callback RootCertCallback) (watcher *RootCertWatcher) {
+
+	watcher = &RootCertWatcher{
+		client:   client,
+		trust:    trust,
+		callback: callback,
+		certList: certList,
+		stop:     make(chan struct{}),
+	}
+
+	go watcher.run(interval)
+	return
+}
+
+func (watcher *RootCertWatcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-watcher.stop:
+			return
+		case <-ticker.C:
+			changed, err := watcher.Refresh()
+			if watcher.callback != nil && (changed || err != nil) {
+				watcher.callback(watcher.RootCerts(), err)
+			}
+		}
+	}
+}
+
+// Stops the watcher. It is safe to call Stop more than once.
+func (watcher *RootCertWatcher) Stop() {
+	watcher.stopOnce.Do(func() { close(watcher.stop) })
+}
+
+// Returns the currently trusted root certificates.
+func (watcher *RootCertWatcher) RootCerts() []*x509.Certificate {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+	return watcher.certList
+}
+
+// Returns a cert pool with the currently trusted root certificates.
+func (watcher *RootCertWatcher) CertPool() *x509.CertPool {
+	return NewCertPool(watcher.RootCerts())
+}
+
+// Downloads the root certificates once and reports whether the trusted set changed.
+// On error the currently trusted certificates are kept.
+func (watcher *RootCertWatcher) Refresh() (changed bool, err error) {
+	err = checkTrustOptions(watcher.trust)
+	if err != nil {
+		return
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs: watcher.CertPool(),
+		},
+	}
+	certList, err := watcher.client.downloadRootCerts(tr)
+	if err != nil {
+		return
+	}
+
+	err = watcher.client.verifyRootCerts(certList, watcher.trust, true)
+	if err != nil {
+		return
+	}
+
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	changed = !sameCerts(watcher.certList, certList)
+	watcher.certList = certList
+	return
+}
+
+func sameCerts(a []*x509.Certificate, b []*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	fingerprints := map[string]bool{}
+	for _, cert := range a {
+		fingerprints[CertFingerprint(cert)] = true
+	}
+	for _, cert := range b {
+		if !fingerprints[CertFingerprint(cert)] {
+			return false
+		}
+	}
+	return true
+}
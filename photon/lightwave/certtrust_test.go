@@ -0,0 +1,235 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("CertTrust", func() {
+	var (
+		client     *OIDCClient
+		server     *mocks.Server
+		serverCert *x509.Certificate
+		tempDir    string
+	)
+
+	BeforeEach(func() {
+		client, server = testSetupFakeServer()
+		serverCert = server.HttpServer.Certificate()
+		server.SetResponseJsonForPath(certDownloadPath, 200, toLightWaveCerts(serverCert))
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "lightwave-certtrust")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	Describe("CertFingerprint", func() {
+		It("returns colon separated SHA-256 hex", func() {
+			fingerprint := CertFingerprint(serverCert)
+			Expect(fingerprint).To(HaveLen(32*3 - 1))
+			Expect(fingerprint).To(MatchRegexp("^([0-9A-F]{2}:){31}[0-9A-F]{2}$"))
+		})
+	})
+
+	Describe("GetTrustedRootCerts", func() {
+		It("fails without trust options", func() {
+			certList, err := client.GetTrustedRootCerts(nil)
+			Expect(certList).To(BeNil())
+			Expect(err).To(MatchError("No trusted fingerprints or TOFU store configured"))
+		})
+
+		Context("with pinned fingerprints", func() {
+			It("accepts matching certificates", func() {
+				fingerprint := normalizeFingerprint(CertFingerprint(serverCert))
+				certList, err := client.GetTrustedRootCerts(&CertTrustOptions{Fingerprints: []string{fingerprint}})
+				Expect(err).To(BeNil())
+				Expect(certList).To(HaveLen(1))
+
+				pool := NewCertPool(certList)
+				Expect(pool.Subjects()).To(HaveLen(1))
+			})
+
+			It("rejects mismatching certificates", func() {
+				certList, err := client.GetTrustedRootCerts(&CertTrustOptions{Fingerprints: []string{"00:11"}})
+				Expect(certList).To(BeNil())
+				trustErr, ok := err.(CertTrustError)
+				Expect(ok).To(BeTrue())
+				Expect(trustErr.Fingerprint).To(Equal(CertFingerprint(serverCert)))
+				Expect(trustErr.Endpoint).To(Equal(client.Endpoint))
+			})
+		})
+
+		Context("with a TOFU store", func() {
+			var trust *CertTrustOptions
+
+			BeforeEach(func() {
+				trust = &CertTrustOptions{TOFUStorePath: filepath.Join(tempDir, "tofu.json")}
+			})
+
+			It("records certificates on first use and accepts them afterwards", func() {
+				certList, err := client.GetTrustedRootCerts(trust)
+				Expect(err).To(BeNil())
+				Expect(certList).To(HaveLen(1))
+
+				store, err := loadTOFUStore(trust.TOFUStorePath)
+				Expect(err).To(BeNil())
+				Expect(store[client.Endpoint]).To(HaveLen(1))
+				Expect(store[client.Endpoint][0].Fingerprint).To(Equal(CertFingerprint(serverCert)))
+
+				certList, err = client.GetTrustedRootCerts(trust)
+				Expect(err).To(BeNil())
+				Expect(certList).To(HaveLen(1))
+			})
+
+			It("rejects certificates that changed since first use", func() {
+				_, err := client.GetTrustedRootCerts(trust)
+				Expect(err).To(BeNil())
+
+				server.SetResponseJsonForPath(certDownloadPath, 200, toLightWaveCerts(createTestCACert()))
+				certList, err := client.GetTrustedRootCerts(trust)
+				Expect(certList).To(BeNil())
+				_, ok := err.(CertTrustError)
+				Expect(ok).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("RootCertWatcher", func() {
+		var rotatedCert *x509.Certificate
+
+		BeforeEach(func() {
+			rotatedCert = createTestCACert()
+		})
+
+		It("accepts rotated certificates over a verified connection with a TOFU store", func() {
+			trust := &CertTrustOptions{TOFUStorePath: filepath.Join(tempDir, "tofu.json")}
+			certList, err := client.GetTrustedRootCerts(trust)
+			Expect(err).To(BeNil())
+
+			watcher := client.WatchRootCerts(trust, certList, time.Hour, nil)
+			defer watcher.Stop()
+
+			changed, err := watcher.Refresh()
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeFalse())
+
+			server.SetResponseJsonForPath(certDownloadPath, 200, toLightWaveCerts(serverCert, rotatedCert))
+			changed, err = watcher.Refresh()
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeTrue())
+			Expect(watcher.RootCerts()).To(HaveLen(2))
+
+			store, err := loadTOFUStore(trust.TOFUStorePath)
+			Expect(err).To(BeNil())
+			Expect(store[client.Endpoint]).To(HaveLen(2))
+		})
+
+		It("rejects rotated certificates that are not pinned", func() {
+			trust := &CertTrustOptions{Fingerprints: []string{CertFingerprint(serverCert)}}
+			certList, err := client.GetTrustedRootCerts(trust)
+			Expect(err).To(BeNil())
+
+			watcher := client.WatchRootCerts(trust, certList, time.Hour, nil)
+			defer watcher.Stop()
+
+			server.SetResponseJsonForPath(certDownloadPath, 200, toLightWaveCerts(serverCert, rotatedCert))
+			changed, err := watcher.Refresh()
+			Expect(changed).To(BeFalse())
+			_, ok := err.(CertTrustError)
+			Expect(ok).To(BeTrue())
+			Expect(watcher.RootCerts()).To(HaveLen(1))
+		})
+
+		It("reports changes through the callback", func() {
+			trust := &CertTrustOptions{TOFUStorePath: filepath.Join(tempDir, "tofu.json")}
+			certList, err := client.GetTrustedRootCerts(trust)
+			Expect(err).To(BeNil())
+
+			server.SetResponseJsonForPath(certDownloadPath, 200, toLightWaveCerts(serverCert, rotatedCert))
+			updates := make(chan []*x509.Certificate, 1)
+			watcher := client.WatchRootCerts(trust, certList, 10*time.Millisecond,
+				func(certList []*x509.Certificate, err error) {
+					if err == nil {
+						select {
+						case updates <- certList:
+						default:
+						}
+					}
+				})
+			defer watcher.Stop()
+
+			Eventually(updates).Should(Receive(HaveLen(2)))
+		})
+
+		It("fails to refresh when the server certificate is not trusted", func() {
+			trust := &CertTrustOptions{Fingerprints: []string{CertFingerprint(rotatedCert)}}
+			watcher := client.WatchRootCerts(trust, []*x509.Certificate{rotatedCert}, time.Hour, nil)
+			defer watcher.Stop()
+
+			changed, err := watcher.Refresh()
+			Expect(changed).To(BeFalse())
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
+
+func toLightWaveCerts(certList ...*x509.Certificate) (certs []lightWaveCert) {
+	for _, cert := range certList {
+		certOut := new(bytes.Buffer)
+		err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		Expect(err).To(BeNil())
+		certs = append(certs, lightWaveCert{Value: certOut.String()})
+	}
+	return
+}
+
+func createTestCACert() *x509.Certificate {
+	template := &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		SerialNumber:          big.NewInt(4321),
+		Subject: pkix.Name{
+			Organization: []string{"Rotated CA"},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().AddDate(1, 0, 0),
+		KeyUsage:  x509.KeyUsageCertSign,
+	}
+
+	privatekey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).To(BeNil())
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privatekey.PublicKey, privatekey)
+	Expect(err).To(BeNil())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+	return cert
+}
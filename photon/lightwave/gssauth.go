@@ -0,0 +1,125 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const gssTicketGrantFormatString = "grant_type=urn:vmware:grant_type:gss_ticket&gss_ticket=%s&context_id=%s&scope=%s"
+
+// Upper bound on the number of gss_continue_needed rounds before giving up on the server.
+const maxGSSRounds = 10
+
+// Produces the GSS-API tokens exchanged with Lightwave in the gss_ticket grant.
+// The SSPI package implements it on Windows, and the kerberos package provides
+// a pure Go Kerberos implementation for other platforms.
+type GSSAuthenticator interface {
+	// Returns the first token to send to the server.
+	InitialBytes() ([]byte, error)
+
+	// Returns the token to send in reply to a token from the server.
+	NextBytes([]byte) ([]byte, error)
+
+	// Releases the credentials and security context held by the authenticator.
+	Free()
+}
+
+// GetTokensByGSSAuthenticator gets tokens using the GSS tokens produced by the authenticator.
+// Here is how it works:
+//  1. Get the initial token from the authenticator
+//  2. Encode the token and send it to OIDC server over HTTP (using POST)
+//  3. OIDC server can send either access tokens, which are returned to the client,
+//     or an error in the format: invalid_grant: gss_continue_needed:'context id':'token from server'
+//  4. In case you get a GSSContinueNeededError, take the token from server out of it
+//  5. Feed this token to the authenticator and repeat steps till you get the access tokens from server
+func (client *OIDCClient) GetTokensByGSSAuthenticator(auth GSSAuthenticator) (tokens *OIDCTokenResponse, err error) {
+	userContext, err := auth.InitialBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	// In case of multiple req/res between client and server (as explained in above comment),
+	// server needs to maintain the mapping of context id -> token
+	// So we need to generate random string as a context id
+	// If we use same context id for all the requests, results can be erroneous
+	contextId := client.generateRandomString()
+
+	for round := 0; round < maxGSSRounds; round++ {
+		body := fmt.Sprintf(gssTicketGrantFormatString,
+			url.QueryEscape(base64.StdEncoding.EncodeToString(userContext)), contextId, client.Options.TokenScope)
+		tokens, err = client.getToken(body)
+		if err == nil {
+			return tokens, nil
+		}
+
+		continueErr, ok := err.(GSSContinueNeededError)
+		if !ok || continueErr.ContextID != contextId {
+			return nil, err
+		}
+
+		var data []byte
+		data, err = base64.StdEncoding.DecodeString(continueErr.ServerToken)
+		if err != nil {
+			return nil, err
+		}
+
+		userContext, err = auth.NextBytes(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("GSS negotiation did not complete after %d rounds", maxGSSRounds)
+}
+
+// ServicePrincipalName gets the SPN (Service Principal Name) in the format host/FQDN of lightwave.
+// This is needed for SSPI/Kerberos protocol.
+func (client *OIDCClient) ServicePrincipalName() (spn string, err error) {
+	u, err := url.Parse(client.Endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := net.LookupAddr(host)
+	if err != nil {
+		return "", err
+	}
+
+	var s = strings.TrimSuffix(addr[0], ".")
+	return "host/" + s, nil
+}
+
+func (client *OIDCClient) generateRandomString() string {
+	const length = 10
+	const asciiA = 65
+	const asciiZ = 90
+	rand.Seed(time.Now().UTC().UnixNano())
+	bytes := make([]byte, length)
+	for i := 0; i < length; i++ {
+		bytes[i] = byte(randInt(asciiA, asciiZ))
+	}
+	return string(bytes)
+}
+
+func randInt(min int, max int) int {
+	return min + rand.Intn(max-min)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+// Authenticator that sends "token-<n>" where n is the number of server tokens received so far.
+type fakeGSSAuthenticator struct {
+	serverTokens []string
+	nextErr      error
+	freed        bool
+}
+
+func (auth *fakeGSSAuthenticator) InitialBytes() ([]byte, error) {
+	return []byte("token-0"), nil
+}
+
+func (auth *fakeGSSAuthenticator) NextBytes(serverToken []byte) ([]byte, error) {
+	if auth.nextErr != nil {
+		return nil, auth.nextErr
+	}
+	auth.serverTokens = append(auth.serverTokens, string(serverToken))
+	return []byte(fmt.Sprintf("token-%d", len(auth.serverTokens))), nil
+}
+
+func (auth *fakeGSSAuthenticator) Free() {
+	auth.freed = true
+}
+
+// Token endpoint that asks for rounds continuations before issuing tokens.
+type fakeGSSServer struct {
+	server      *mocks.Server
+	rounds      int
+	overrideCtx string
+}
+
+func newFakeGSSServer(server *mocks.Server) *fakeGSSServer {
+	s := &fakeGSSServer{server: server}
+	server.SetResponseFuncForPath(tokenPath, s.respond)
+	return s
+}
+
+func (s *fakeGSSServer) respond(request mocks.Request) (int, interface{}) {
+	form, err := url.ParseQuery(request.Body)
+	Expect(err).To(BeNil())
+	Expect(form.Get("grant_type")).To(Equal("urn:vmware:grant_type:gss_ticket"))
+
+	round := len(s.server.RequestsFor("POST", tokenPath))
+	if round <= s.rounds {
+		contextID := form.Get("context_id")
+		if s.overrideCtx != "" {
+			contextID = s.overrideCtx
+		}
+		serverToken := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("server-%d", round)))
+		return 400, &OIDCError{
+			Code:    "invalid_grant",
+			Message: "gss_continue_needed:" + contextID + ":" + serverToken,
+		}
+	}
+	return 200, &OIDCTokenResponse{AccessToken: "fake_access_token", TokenType: "Bearer"}
+}
+
+// The decoded GSS tokens and the context IDs the client sent, in order.
+func (s *fakeGSSServer) requests() (clientTokens []string, contextIDs []string) {
+	for _, request := range s.server.RequestsFor("POST", tokenPath) {
+		form, err := url.ParseQuery(request.Body)
+		Expect(err).To(BeNil())
+		token, err := base64.StdEncoding.DecodeString(form.Get("gss_ticket"))
+		Expect(err).To(BeNil())
+		clientTokens = append(clientTokens, string(token))
+		contextIDs = append(contextIDs, form.Get("context_id"))
+	}
+	return
+}
+
+var _ = Describe("GSSAuthenticator", func() {
+	var (
+		client    *OIDCClient
+		server    *mocks.Server
+		gssServer *fakeGSSServer
+		auth      *fakeGSSAuthenticator
+	)
+
+	BeforeEach(func() {
+		client, server = testSetupFakeServer()
+		gssServer = newFakeGSSServer(server)
+		auth = &fakeGSSAuthenticator{}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("retrieves tokens in a single round", func() {
+		tokens, err := client.GetTokensByGSSAuthenticator(auth)
+		Expect(err).To(BeNil())
+		Expect(tokens.AccessToken).To(Equal("fake_access_token"))
+		clientTokens, _ := gssServer.requests()
+		Expect(clientTokens).To(Equal([]string{"token-0"}))
+	})
+
+	It("continues the negotiation with the same context id", func() {
+		gssServer.rounds = 2
+		tokens, err := client.GetTokensByGSSAuthenticator(auth)
+		Expect(err).To(BeNil())
+		Expect(tokens.AccessToken).To(Equal("fake_access_token"))
+		Expect(auth.serverTokens).To(Equal([]string{"server-1", "server-2"}))
+		clientTokens, contextIDs := gssServer.requests()
+		Expect(clientTokens).To(Equal([]string{"token-0", "token-1", "token-2"}))
+		Expect(contextIDs).To(HaveLen(3))
+		Expect(contextIDs[1]).To(Equal(contextIDs[0]))
+		Expect(contextIDs[2]).To(Equal(contextIDs[0]))
+	})
+
+	It("gives up when the server never completes the negotiation", func() {
+		gssServer.rounds = maxGSSRounds + 1
+		tokens, err := client.GetTokensByGSSAuthenticator(auth)
+		Expect(tokens).To(BeNil())
+		Expect(err).To(MatchError(fmt.Sprintf("GSS negotiation did not complete after %d rounds", maxGSSRounds)))
+	})
+
+	It("returns the server error for a different context id", func() {
+		gssServer.rounds = 1
+		gssServer.overrideCtx = "OTHER"
+		tokens, err := client.GetTokensByGSSAuthenticator(auth)
+		Expect(tokens).To(BeNil())
+		Expect(err.(GSSContinueNeededError).ContextID).To(Equal("OTHER"))
+		Expect(auth.serverTokens).To(BeEmpty())
+	})
+
+	It("returns the authenticator error", func() {
+		gssServer.rounds = 1
+		auth.nextErr = errors.New("bad token")
+		tokens, err := client.GetTokensByGSSAuthenticator(auth)
+		Expect(tokens).To(BeNil())
+		Expect(err).To(MatchError("bad token"))
+	})
+})
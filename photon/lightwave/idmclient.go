@@ -0,0 +1,421 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Scope to request tokens with in order to use the IDM API.
+const IDMTokenScope string = "openid offline_access rs_admin_server"
+
+// Member types used by the IDM API.
+const (
+	MemberTypeUser         string = "USER"
+	MemberTypeGroup        string = "GROUP"
+	MemberTypeSolutionUser string = "SOLUTIONUSER"
+	MemberTypeAll          string = "ALL"
+)
+
+// Client for the Lightwave identity management (IDM) API of a tenant.
+type IDMClient struct {
+	httpClient *http.Client
+	logger     *log.Logger
+
+	Endpoint string
+	Tenant   string
+
+	// Access token sent with each request, see IDMTokenScope.
+	AccessToken string
+}
+
+type IDMUserDetails struct {
+	Email       string `json:"email,omitempty"`
+	UPN         string `json:"upn,omitempty"`
+	FirstName   string `json:"firstName,omitempty"`
+	LastName    string `json:"lastName,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type IDMPasswordDetails struct {
+	Password string `json:"password,omitempty"`
+}
+
+type IDMUser struct {
+	Name            string              `json:"name"`
+	Domain          string              `json:"domain"`
+	Details         *IDMUserDetails     `json:"details,omitempty"`
+	PasswordDetails *IDMPasswordDetails `json:"passwordDetails,omitempty"`
+	Disabled        bool                `json:"disabled,omitempty"`
+	Locked          bool                `json:"locked,omitempty"`
+}
+
+type IDMGroupDetails struct {
+	Description string `json:"description,omitempty"`
+}
+
+type IDMGroup struct {
+	Name     string           `json:"name"`
+	Domain   string           `json:"domain"`
+	Details  *IDMGroupDetails `json:"details,omitempty"`
+	ObjectId string           `json:"objectId,omitempty"`
+}
+
+type IDMCertificate struct {
+	Encoded string `json:"encoded"`
+}
+
+type IDMSolutionUser struct {
+	Name        string          `json:"name"`
+	Domain      string          `json:"domain"`
+	Description string          `json:"description,omitempty"`
+	Certificate *IDMCertificate `json:"certificate,omitempty"`
+	Disabled    bool            `json:"disabled,omitempty"`
+	ObjectId    string          `json:"objectId,omitempty"`
+}
+
+type IDMSearchResult struct {
+	Users         []IDMUser         `json:"users"`
+	Groups        []IDMGroup        `json:"groups"`
+	SolutionUsers []IDMSolutionUser `json:"solutionUsers"`
+}
+
+// A principal name split into its parts, and the type of principal it was found to be.
+type IDMPrincipal struct {
+	Name   string
+	Domain string
+	Type   string
+}
+
+// Returns the principal in name@domain format.
+func (p *IDMPrincipal) UPN() string {
+	return p.Name + "@" + p.Domain
+}
+
+// Represents an error returned by the IDM API.
+type IDMError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"error"`
+	Details    string `json:"details"`
+	Cause      string `json:"cause"`
+}
+
+func (e IDMError) Error() string {
+	return fmt.Sprintf("lightwave: IDM HTTP %d: %v: %v", e.StatusCode, e.Code, e.Details)
+}
+
+// Returned by ResolvePrincipal when no user, group or solution user has the given name.
+type PrincipalNotFoundError struct {
+	Principal string
+}
+
+func (e PrincipalNotFoundError) Error() string {
+	return fmt.Sprintf("lightwave: Principal '%s' not found", e.Principal)
+}
+
+func NewIDMClient(endpoint string, tenant string, accessToken string, options *OIDCClientOptions, logger *log.Logger) (c *IDMClient) {
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", log.LstdFlags)
+	}
+
+	options = buildOptions(options)
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: options.IgnoreCertificate,
+			RootCAs:            options.RootCAs},
+	}
+
+	c = &IDMClient{
+		httpClient: &http.Client{Transport: tr},
+		logger:     logger,
+
+		Endpoint:    strings.TrimRight(endpoint, "/"),
+		Tenant:      tenant,
+		AccessToken: accessToken,
+	}
+	return
+}
+
+// Users
+
+// Lists the users of the domain.
+func (client *IDMClient) ListUsers(domain string) (users []IDMUser, err error) {
+	result, err := client.search(domain, MemberTypeUser)
+	if err != nil {
+		return
+	}
+	return result.Users, nil
+}
+
+// Gets a user by name@domain.
+func (client *IDMClient) GetUser(name string) (user *IDMUser, err error) {
+	user = &IDMUser{}
+	err = client.doRequest("GET", client.tenantPath("users", name), nil, user)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (client *IDMClient) CreateUser(spec *IDMUser) (user *IDMUser, err error) {
+	user = &IDMUser{}
+	err = client.doRequest("POST", client.tenantPath("users"), spec, user)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Deletes a user by name@domain.
+func (client *IDMClient) DeleteUser(name string) (err error) {
+	return client.doRequest("DELETE", client.tenantPath("users", name), nil, nil)
+}
+
+// Gets the groups the user, given as name@domain, is a direct member of.
+func (client *IDMClient) GetUserGroups(name string) (groups []IDMGroup, err error) {
+	err = client.doRequest("GET", client.tenantPath("users", name, "groups"), nil, &groups)
+	return
+}
+
+// Groups
+
+// Lists the groups of the domain.
+func (client *IDMClient) ListGroups(domain string) (groups []IDMGroup, err error) {
+	result, err := client.search(domain, MemberTypeGroup)
+	if err != nil {
+		return
+	}
+	return result.Groups, nil
+}
+
+// Gets a group by name@domain.
+func (client *IDMClient) GetGroup(name string) (group *IDMGroup, err error) {
+	group = &IDMGroup{}
+	err = client.doRequest("GET", client.tenantPath("groups", name), nil, group)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (client *IDMClient) CreateGroup(spec *IDMGroup) (group *IDMGroup, err error) {
+	group = &IDMGroup{}
+	err = client.doRequest("POST", client.tenantPath("groups"), spec, group)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Deletes a group by name@domain.
+func (client *IDMClient) DeleteGroup(name string) (err error) {
+	return client.doRequest("DELETE", client.tenantPath("groups", name), nil, nil)
+}
+
+// Gets the members of the group of the given member type, one of the MemberType constants.
+func (client *IDMClient) GetGroupMembers(group string, memberType string) (members *IDMSearchResult, err error) {
+	members = &IDMSearchResult{}
+	path := client.tenantPath("groups", group, "members") + "?type=" + url.QueryEscape(memberType)
+	err = client.doRequest("GET", path, nil, members)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Adds the members, given as name@domain, of the given member type to the group.
+func (client *IDMClient) AddGroupMembers(group string, memberType string, members []string) (err error) {
+	return client.doRequest("PUT", client.membersPath(group, memberType, members), nil, nil)
+}
+
+// Removes the members, given as name@domain, of the given member type from the group.
+func (client *IDMClient) RemoveGroupMembers(group string, memberType string, members []string) (err error) {
+	return client.doRequest("DELETE", client.membersPath(group, memberType, members), nil, nil)
+}
+
+func (client *IDMClient) membersPath(group string, memberType string, members []string) string {
+	query := url.Values{}
+	query.Set("type", memberType)
+	for _, member := range members {
+		query.Add("members", member)
+	}
+	return client.tenantPath("groups", group, "members") + "?" + query.Encode()
+}
+
+// Solution users
+
+// Lists the solution users of the domain.
+func (client *IDMClient) ListSolutionUsers(domain string) (solutionUsers []IDMSolutionUser, err error) {
+	result, err := client.search(domain, MemberTypeSolutionUser)
+	if err != nil {
+		return
+	}
+	return result.SolutionUsers, nil
+}
+
+// Gets a solution user by name.
+func (client *IDMClient) GetSolutionUser(name string) (solutionUser *IDMSolutionUser, err error) {
+	solutionUser = &IDMSolutionUser{}
+	err = client.doRequest("GET", client.tenantPath("solutionusers", name), nil, solutionUser)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (client *IDMClient) CreateSolutionUser(spec *IDMSolutionUser) (solutionUser *IDMSolutionUser, err error) {
+	solutionUser = &IDMSolutionUser{}
+	err = client.doRequest("POST", client.tenantPath("solutionusers"), spec, solutionUser)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Deletes a solution user by name.
+func (client *IDMClient) DeleteSolutionUser(name string) (err error) {
+	return client.doRequest("DELETE", client.tenantPath("solutionusers", name), nil, nil)
+}
+
+// Principals
+
+// Splits a principal name into name and domain. Both name@domain and domain\name
+// are accepted; names without a domain are taken to be in the tenant's domain.
+func (client *IDMClient) ParsePrincipal(principal string) (name string, domain string) {
+	if idx := strings.LastIndex(principal, "@"); idx >= 0 {
+		return principal[:idx], principal[idx+1:]
+	}
+	if idx := strings.Index(principal, "\\"); idx >= 0 {
+		return principal[idx+1:], principal[:idx]
+	}
+	return principal, client.Tenant
+}
+
+// Looks up the principal as a user, a group and a solution user, in that order.
+// Returns PrincipalNotFoundError if none of them exist.
+func (client *IDMClient) ResolvePrincipal(principal string) (resolved *IDMPrincipal, err error) {
+	name, domain := client.ParsePrincipal(principal)
+	upn := name + "@" + domain
+
+	user, err := client.GetUser(upn)
+	if err == nil {
+		return &IDMPrincipal{user.Name, user.Domain, MemberTypeUser}, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	group, err := client.GetGroup(upn)
+	if err == nil {
+		return &IDMPrincipal{group.Name, group.Domain, MemberTypeGroup}, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	solutionUser, err := client.GetSolutionUser(name)
+	if err == nil && strings.EqualFold(solutionUser.Domain, domain) {
+		return &IDMPrincipal{solutionUser.Name, solutionUser.Domain, MemberTypeSolutionUser}, nil
+	}
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+
+	return nil, PrincipalNotFoundError{principal}
+}
+
+func isNotFound(err error) bool {
+	idmErr, ok := err.(IDMError)
+	return ok && idmErr.StatusCode == http.StatusNotFound
+}
+
+// Request helpers
+
+const idmTenantPath string = "/idm/tenant/"
+
+func (client *IDMClient) tenantPath(parts ...string) string {
+	path := idmTenantPath + pathEscape(client.Tenant)
+	for _, part := range parts {
+		path += "/" + pathEscape(part)
+	}
+	return path
+}
+
+func pathEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func (client *IDMClient) search(domain string, memberType string) (result *IDMSearchResult, err error) {
+	query := url.Values{}
+	query.Set("domain", domain)
+	query.Set("type", memberType)
+	query.Set("searchBy", "NAME")
+	query.Set("query", "")
+
+	result = &IDMSearchResult{}
+	err = client.doRequest("GET", client.tenantPath("search")+"?"+query.Encode(), nil, result)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (client *IDMClient) doRequest(method string, path string, in interface{}, out interface{}) (err error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, client.Endpoint+path, body)
+	if err != nil {
+		return
+	}
+	if in != nil {
+		request.Header.Add("Content-Type", "application/json")
+	}
+	if client.AccessToken != "" {
+		request.Header.Add("Authorization", "Bearer "+client.AccessToken)
+	}
+
+	resp, err := client.httpClient.Do(request)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		idmErr := IDMError{}
+		if json.Unmarshal(respBody, &idmErr) != nil || idmErr.Code == "" {
+			idmErr.Code = http.StatusText(resp.StatusCode)
+			idmErr.Details = string(respBody)
+		}
+		idmErr.StatusCode = resp.StatusCode
+		return idmErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("IDMClient", func() {
+	var (
+		client *IDMClient
+		server *mocks.Server
+	)
+
+	// Answers requests with the method for the path with v, or with no
+	// content if v is nil.
+	respond := func(method string, path string, v interface{}) {
+		status := 200
+		if v == nil {
+			status = 204
+		}
+		server.SetResponseJsonForMethodPath(method, path, status, v)
+	}
+
+	lastRequest := func() mocks.Request {
+		requests := server.Requests()
+		return requests[len(requests)-1]
+	}
+
+	BeforeEach(func() {
+		_, server = testSetupFakeServer()
+		server.SetResponseJson(404, &IDMError{Code: "not_found", Details: "not found"})
+		client = NewIDMClient(server.HttpServer.URL, "photon.local", "fake_token", &OIDCClientOptions{IgnoreCertificate: true}, nil)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Users", func() {
+		It("gets a user with the access token", func() {
+			respond("GET", "/idm/tenant/photon.local/users/joe@photon.local", &IDMUser{Name: "joe", Domain: "photon.local"})
+
+			user, err := client.GetUser("joe@photon.local")
+			Expect(err).To(BeNil())
+			Expect(user).To(Equal(&IDMUser{Name: "joe", Domain: "photon.local"}))
+			Expect(lastRequest().Header.Get("Authorization")).To(Equal("Bearer fake_token"))
+		})
+
+		It("lists users through search", func() {
+			respond("GET", "/idm/tenant/photon.local/search",
+				&IDMSearchResult{Users: []IDMUser{{Name: "joe", Domain: "photon.local"}}})
+
+			users, err := client.ListUsers("photon.local")
+			Expect(err).To(BeNil())
+			Expect(users).To(HaveLen(1))
+			query := lastRequest().Query
+			Expect(query.Get("type")).To(Equal(MemberTypeUser))
+			Expect(query.Get("domain")).To(Equal("photon.local"))
+		})
+
+		It("creates and deletes users", func() {
+			spec := &IDMUser{
+				Name:            "joe",
+				Domain:          "photon.local",
+				PasswordDetails: &IDMPasswordDetails{Password: "secret"},
+			}
+			respond("POST", "/idm/tenant/photon.local/users", &IDMUser{Name: "joe", Domain: "photon.local"})
+			respond("DELETE", "/idm/tenant/photon.local/users/joe@photon.local", nil)
+
+			user, err := client.CreateUser(spec)
+			Expect(err).To(BeNil())
+			Expect(user.Name).To(Equal("joe"))
+			Expect(server.Requests()[0].Body).To(ContainSubstring(`"password":"secret"`))
+
+			err = client.DeleteUser("joe@photon.local")
+			Expect(err).To(BeNil())
+			Expect(lastRequest().Method).To(Equal("DELETE"))
+		})
+
+		It("returns IDMError for failures", func() {
+			user, err := client.GetUser("nobody@photon.local")
+			Expect(user).To(BeNil())
+			idmErr, ok := err.(IDMError)
+			Expect(ok).To(BeTrue())
+			Expect(idmErr.StatusCode).To(Equal(404))
+			Expect(idmErr.Code).To(Equal("not_found"))
+		})
+	})
+
+	Describe("Groups", func() {
+		It("adds and removes members", func() {
+			respond("PUT", "/idm/tenant/photon.local/groups/admins@photon.local/members", nil)
+			respond("DELETE", "/idm/tenant/photon.local/groups/admins@photon.local/members", nil)
+
+			members := []string{"joe@photon.local", "ann@photon.local"}
+			err := client.AddGroupMembers("admins@photon.local", MemberTypeUser, members)
+			Expect(err).To(BeNil())
+			query := lastRequest().Query
+			Expect(query["members"]).To(Equal(members))
+			Expect(query.Get("type")).To(Equal(MemberTypeUser))
+
+			err = client.RemoveGroupMembers("admins@photon.local", MemberTypeUser, members[:1])
+			Expect(err).To(BeNil())
+			Expect(lastRequest().Query["members"]).To(Equal(members[:1]))
+		})
+
+		It("gets members", func() {
+			respond("GET", "/idm/tenant/photon.local/groups/admins@photon.local/members",
+				&IDMSearchResult{Users: []IDMUser{{Name: "joe", Domain: "photon.local"}}})
+
+			members, err := client.GetGroupMembers("admins@photon.local", MemberTypeAll)
+			Expect(err).To(BeNil())
+			Expect(members.Users).To(HaveLen(1))
+			Expect(lastRequest().Query.Get("type")).To(Equal(MemberTypeAll))
+		})
+	})
+
+	Describe("ParsePrincipal", func() {
+		It("accepts both name@domain and domain\\name", func() {
+			name, domain := client.ParsePrincipal("joe@example.com")
+			Expect([]string{name, domain}).To(Equal([]string{"joe", "example.com"}))
+
+			name, domain = client.ParsePrincipal("example.com\\admins")
+			Expect([]string{name, domain}).To(Equal([]string{"admins", "example.com"}))
+
+			name, domain = client.ParsePrincipal("joe")
+			Expect([]string{name, domain}).To(Equal([]string{"joe", "photon.local"}))
+		})
+	})
+
+	Describe("ResolvePrincipal", func() {
+		It("resolves users", func() {
+			respond("GET", "/idm/tenant/photon.local/users/joe@photon.local", &IDMUser{Name: "joe", Domain: "photon.local"})
+
+			principal, err := client.ResolvePrincipal("joe@photon.local")
+			Expect(err).To(BeNil())
+			Expect(principal).To(Equal(&IDMPrincipal{"joe", "photon.local", MemberTypeUser}))
+		})
+
+		It("resolves groups given as domain\\name", func() {
+			respond("GET", "/idm/tenant/photon.local/groups/admins@photon.local", &IDMGroup{Name: "admins", Domain: "photon.local"})
+
+			principal, err := client.ResolvePrincipal("photon.local\\admins")
+			Expect(err).To(BeNil())
+			Expect(principal.Type).To(Equal(MemberTypeGroup))
+			Expect(principal.UPN()).To(Equal("admins@photon.local"))
+		})
+
+		It("resolves solution users", func() {
+			respond("GET", "/idm/tenant/photon.local/solutionusers/svc", &IDMSolutionUser{Name: "svc", Domain: "photon.local"})
+
+			principal, err := client.ResolvePrincipal("svc@photon.local")
+			Expect(err).To(BeNil())
+			Expect(principal.Type).To(Equal(MemberTypeSolutionUser))
+		})
+
+		It("returns PrincipalNotFoundError for unknown principals", func() {
+			principal, err := client.ResolvePrincipal("nobody@photon.local")
+			Expect(principal).To(BeNil())
+			Expect(err).To(Equal(PrincipalNotFoundError{"nobody@photon.local"}))
+		})
+	})
+})
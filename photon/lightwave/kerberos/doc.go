@@ -0,0 +1,18 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+// Package kerberos provides a pure Go GSS authenticator for Lightwave, for
+// platforms where the Windows SSPI package is not available.
+//
+// The package needs gokrb5 v8, which godep restore cannot fetch since it is
+// only published as a Go module, so it is only built with the kerberos build
+// tag, from a Go module that requires github.com/jcmturner/gokrb5/v8:
+//
+//	go build -tags kerberos github.com/vmware/photon-controller-go-sdk/photon/lightwave/kerberos
+package kerberos
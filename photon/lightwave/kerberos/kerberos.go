@@ -0,0 +1,203 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+//go:build kerberos
+// +build kerberos
+
+package kerberos
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const defaultKrb5ConfPath string = "/etc/krb5.conf"
+
+// Produces SPNEGO/Kerberos tokens for the Lightwave gss_ticket grant.
+// It implements lightwave.GSSAuthenticator.
+type Authenticator struct {
+	client *client.Client
+	spn    string
+
+	// Session key and authenticator time of the AP-REQ sent by InitialBytes,
+	// which the AP-REP of the server must match.
+	sessionKey types.EncryptionKey
+	ctime      time.Time
+	cusec      int
+}
+
+// Creates an authenticator that logs in to the KDC with the keys of the user from a keytab file.
+// If krb5ConfPath is empty, KRB5_CONFIG or /etc/krb5.conf is used.
+// The spn is the service principal name of Lightwave, see OIDCClient.ServicePrincipalName.
+func NewKeytabAuthenticator(username string, realm string, keytabPath string, krb5ConfPath string, spn string) (auth *Authenticator, err error) {
+	conf, err := loadConfig(krb5ConfPath)
+	if err != nil {
+		return
+	}
+
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		return
+	}
+
+	return &Authenticator{
+		client: client.NewWithKeytab(username, realm, kt, conf, client.DisablePAFXFAST(true)),
+		spn:    spn,
+	}, nil
+}
+
+// Creates an authenticator that uses the tickets from a credential cache, e.g. one filled by kinit.
+// If ccachePath is empty, KRB5CCNAME or /tmp/krb5cc_<uid> is used.
+// If krb5ConfPath is empty, KRB5_CONFIG or /etc/krb5.conf is used.
+// The spn is the service principal name of Lightwave, see OIDCClient.ServicePrincipalName.
+func NewCCacheAuthenticator(ccachePath string, krb5ConfPath string, spn string) (auth *Authenticator, err error) {
+	conf, err := loadConfig(krb5ConfPath)
+	if err != nil {
+		return
+	}
+
+	ccache, err := credentials.LoadCCache(getCCachePath(ccachePath))
+	if err != nil {
+		return
+	}
+
+	cl, err := client.NewFromCCache(ccache, conf, client.DisablePAFXFAST(true))
+	if err != nil {
+		return
+	}
+
+	return &Authenticator{client: cl, spn: spn}, nil
+}
+
+// Gets a service ticket for Lightwave and returns it as a SPNEGO token.
+func (auth *Authenticator) InitialBytes() ([]byte, error) {
+	err := auth.client.AffirmLogin()
+	if err != nil {
+		return nil, err
+	}
+
+	ticket, sessionKey, err := auth.client.GetServiceTicket(auth.spn)
+	if err != nil {
+		return nil, err
+	}
+	negTokenInit, err := spnego.NewNegTokenInitKRB5(auth.client, ticket, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep what the AP-REP of the server is verified against.
+	var mechToken spnego.KRB5Token
+	err = mechToken.Unmarshal(negTokenInit.MechTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = mechToken.APReq.DecryptAuthenticator(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	auth.sessionKey = sessionKey
+	auth.ctime = mechToken.APReq.Authenticator.CTime
+	auth.cusec = mechToken.APReq.Authenticator.Cusec
+
+	token := &spnego.SPNEGOToken{Init: true, NegTokenInit: negTokenInit}
+	return token.Marshal()
+}
+
+// Kerberos needs a single message from the client, the service ticket sent by
+// InitialBytes. A server doing mutual authentication replies with an AP-REP,
+// which is verified against the ticket; there is nothing more to send then.
+func (auth *Authenticator) NextBytes(serverToken []byte) ([]byte, error) {
+	var resp spnego.NegTokenResp
+	err := resp.Unmarshal(serverToken)
+	if err != nil {
+		return nil, err
+	}
+	if resp.State() == spnego.NegStateReject {
+		return nil, errors.New("Kerberos authentication rejected by server")
+	}
+	if len(resp.ResponseToken) == 0 {
+		return nil, errors.New("Unexpected GSS continuation from server for Kerberos authentication")
+	}
+
+	var mechToken spnego.KRB5Token
+	err = mechToken.Unmarshal(resp.ResponseToken)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case mechToken.IsKRBError():
+		return nil, mechToken.KRBError
+	case mechToken.IsAPRep():
+		err = auth.verifyAPRep(&mechToken.APRep)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	}
+	return nil, errors.New("Unexpected Kerberos token from server")
+}
+
+// Checks that the AP-REP is encrypted with the session key and echoes the time
+// of the authenticator sent, proving the server could read the ticket.
+func (auth *Authenticator) verifyAPRep(apRep *messages.APRep) error {
+	if auth.ctime.IsZero() {
+		return errors.New("Kerberos AP-REP received before the service ticket was sent")
+	}
+	data, err := crypto.DecryptEncPart(apRep.EncPart, auth.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("Invalid Kerberos AP-REP from server: %v", err)
+	}
+	var part messages.EncAPRepPart
+	err = part.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	if !part.CTime.Equal(auth.ctime) || part.Cusec != auth.cusec {
+		return errors.New("Kerberos AP-REP from server does not match the service ticket sent")
+	}
+	return nil
+}
+
+// Destroys the Kerberos session held by the authenticator.
+func (auth *Authenticator) Free() {
+	auth.client.Destroy()
+}
+
+func loadConfig(path string) (*config.Config, error) {
+	if path == "" {
+		path = os.Getenv("KRB5_CONFIG")
+	}
+	if path == "" {
+		path = defaultKrb5ConfPath
+	}
+	return config.Load(path)
+}
+
+func getCCachePath(path string) string {
+	if path == "" {
+		path = os.Getenv("KRB5CCNAME")
+	}
+	if path == "" {
+		return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+	return strings.TrimPrefix(path, "FILE:")
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+//go:build kerberos
+// +build kerberos
+
+package kerberos
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testKrb5Conf = `[libdefaults]
+  default_realm = EXAMPLE.COM
+
+[realms]
+  EXAMPLE.COM = {
+    kdc = kdc.example.com:88
+  }
+`
+
+var _ = Describe("Kerberos", func() {
+	var (
+		tempDir  string
+		confPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "kerberos")
+		Expect(err).To(BeNil())
+
+		confPath = filepath.Join(tempDir, "krb5.conf")
+		err = ioutil.WriteFile(confPath, []byte(testKrb5Conf), 0600)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	Describe("NewKeytabAuthenticator", func() {
+		It("fails when the config is missing", func() {
+			auth, err := NewKeytabAuthenticator("user", "EXAMPLE.COM",
+				filepath.Join(tempDir, "user.keytab"), filepath.Join(tempDir, "missing.conf"), "host/lightwave")
+			Expect(auth).To(BeNil())
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("fails when the keytab is missing", func() {
+			auth, err := NewKeytabAuthenticator("user", "EXAMPLE.COM",
+				filepath.Join(tempDir, "user.keytab"), confPath, "host/lightwave")
+			Expect(auth).To(BeNil())
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("NewCCacheAuthenticator", func() {
+		It("fails when the credential cache is missing", func() {
+			auth, err := NewCCacheAuthenticator(filepath.Join(tempDir, "krb5cc"), confPath, "host/lightwave")
+			Expect(auth).To(BeNil())
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("getCCachePath", func() {
+		var saved string
+
+		BeforeEach(func() {
+			saved = os.Getenv("KRB5CCNAME")
+		})
+
+		AfterEach(func() {
+			os.Setenv("KRB5CCNAME", saved)
+		})
+
+		It("prefers the given path", func() {
+			os.Setenv("KRB5CCNAME", "FILE:/tmp/env")
+			Expect(getCCachePath("/tmp/given")).To(Equal("/tmp/given"))
+		})
+
+		It("uses KRB5CCNAME without the FILE prefix", func() {
+			os.Setenv("KRB5CCNAME", "FILE:/tmp/env")
+			Expect(getCCachePath("")).To(Equal("/tmp/env"))
+		})
+
+		It("defaults to the per user cache", func() {
+			os.Setenv("KRB5CCNAME", "")
+			Expect(getCCachePath("")).To(Equal(fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())))
+		})
+	})
+
+	Describe("NextBytes", func() {
+		var (
+			auth *Authenticator
+			now  time.Time
+		)
+
+		BeforeEach(func() {
+			now = time.Now().UTC().Truncate(time.Second)
+			auth = &Authenticator{
+				sessionKey: types.EncryptionKey{
+					KeyType:  etypeID.AES256_CTS_HMAC_SHA1_96,
+					KeyValue: bytes.Repeat([]byte{7}, 32),
+				},
+				ctime: now,
+				cusec: 42,
+			}
+		})
+
+		// Builds the SPNEGO reply of a server doing mutual authentication.
+		apRepToken := func(key types.EncryptionKey, ctime time.Time, cusec int) []byte {
+			part, err := asn1.Marshal(messages.EncAPRepPart{CTime: ctime, Cusec: cusec})
+			Expect(err).To(BeNil())
+			encPart, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(part, asnAppTag.EncAPRepPart),
+				key, keyusage.AP_REP_ENCPART, 0)
+			Expect(err).To(BeNil())
+			apRep, err := asn1.Marshal(messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: encPart})
+			Expect(err).To(BeNil())
+
+			oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+			Expect(err).To(BeNil())
+			mechToken := append(oid, 0x02, 0x00)
+			mechToken = append(mechToken, asn1tools.AddASNAppTag(apRep, asnAppTag.APREP)...)
+
+			resp := spnego.NegTokenResp{
+				NegState:      asn1.Enumerated(spnego.NegStateAcceptCompleted),
+				SupportedMech: gssapi.OIDKRB5.OID(),
+				ResponseToken: asn1tools.AddASNAppTag(mechToken, 0),
+			}
+			token, err := resp.Marshal()
+			Expect(err).To(BeNil())
+			return token
+		}
+
+		It("verifies the AP-REP of mutual authentication", func() {
+			token, err := auth.NextBytes(apRepToken(auth.sessionKey, now, 42))
+			Expect(err).To(BeNil())
+			Expect(token).To(BeEmpty())
+		})
+
+		It("rejects AP-REPs that do not match the ticket sent", func() {
+			_, err := auth.NextBytes(apRepToken(auth.sessionKey, now.Add(time.Second), 42))
+			Expect(err).ToNot(BeNil())
+
+			otherKey := types.EncryptionKey{KeyType: auth.sessionKey.KeyType, KeyValue: bytes.Repeat([]byte{8}, 32)}
+			_, err = auth.NextBytes(apRepToken(otherKey, now, 42))
+			Expect(err).ToNot(BeNil())
+
+			_, err = (&Authenticator{}).NextBytes(apRepToken(auth.sessionKey, now, 42))
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("rejects other continuations", func() {
+			token, err := auth.NextBytes([]byte("server token"))
+			Expect(token).To(BeNil())
+			Expect(err).ToNot(BeNil())
+
+			resp := spnego.NegTokenResp{
+				NegState:      asn1.Enumerated(spnego.NegStateAcceptIncomplete),
+				SupportedMech: gssapi.OIDKRB5.OID(),
+			}
+			data, err := resp.Marshal()
+			Expect(err).To(BeNil())
+			token, err = auth.NextBytes(data)
+			Expect(token).To(BeNil())
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
@@ -103,18 +103,21 @@ type lightWaveCert struct {
 
 func (client *OIDCClient) GetRootCerts() (certList []*x509.Certificate, err error) {
 	// turn TLS verification off for
-	originalTr := client.httpClient.Transport
-	defer client.setTransport(originalTr)
-
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: true,
 		},
 	}
-	client.setTransport(tr)
+	return client.downloadRootCerts(tr)
+}
+
+func (client *OIDCClient) downloadRootCerts(tr http.RoundTripper) (certList []*x509.Certificate, err error) {
+	// Use a separate http client so that concurrent token requests
+	// keep using the client's own transport.
+	httpClient := &http.Client{Transport: tr}
 
 	// get the certs
-	resp, err := client.httpClient.Get(client.buildUrl(certDownloadPath))
+	resp, err := httpClient.Get(client.buildUrl(certDownloadPath))
 	if err != nil {
 		return
 	}
@@ -150,10 +153,6 @@ func (client *OIDCClient) GetRootCerts() (certList []*x509.Certificate, err erro
 	return
 }
 
-func (client *OIDCClient) setTransport(tr http.RoundTripper) {
-	client.httpClient.Transport = tr
-}
-
 // Toke request helpers
 
 const tokenPath string = "/openidconnect/token"
@@ -216,32 +215,77 @@ func (client *OIDCClient) getToken(body string) (tokens *OIDCTokenResponse, err
 	return
 }
 
-type OIDCError struct {
-	Code    string `json:"error"`
-	Message string `json:"error_description"`
-}
+// Logout helpers
 
-func (e OIDCError) Error() string {
-	return fmt.Sprintf("%v: %v", e.Code, e.Message)
-}
+const revokePath string = "/openidconnect/revoke"
+const logoutPath string = "/openidconnect/logout"
+const revokeTokenFormatString = "token=%s&token_type_hint=%s"
+
+// Token type hints accepted by the revocation endpoint.
+const (
+	AccessTokenTypeHint  string = "access_token"
+	RefreshTokenTypeHint string = "refresh_token"
+)
 
-func (client *OIDCClient) checkResponse(response *http.Response) (err error) {
-	if response.StatusCode/100 == 2 {
+// Revokes the given token so it can no longer be used to obtain new tokens.
+// The tokenTypeHint is optional and may be one of AccessTokenTypeHint or RefreshTokenTypeHint.
+func (client *OIDCClient) RevokeToken(token string, tokenTypeHint string) (err error) {
+	body := fmt.Sprintf(revokeTokenFormatString, url.QueryEscape(token), url.QueryEscape(tokenTypeHint))
+	request, err := http.NewRequest("POST", client.buildUrl(revokePath), strings.NewReader(body))
+	if err != nil {
 		return
 	}
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	respBody, readErr := ioutil.ReadAll(response.Body)
+	resp, err := client.httpClient.Do(request)
 	if err != nil {
-		return fmt.Errorf(
-			"Status: %v, Body: %v [%v]", response.Status, string(respBody[:]), readErr)
+		return
 	}
+	defer resp.Body.Close()
+
+	return client.checkResponse(resp)
+}
 
-	var oidcErr OIDCError
-	err = json.Unmarshal(respBody, &oidcErr)
+// Ends the Lightwave session the given id token was issued for.
+func (client *OIDCClient) EndSession(idToken string) (err error) {
+	request, err := http.NewRequest(
+		"GET", client.buildUrl(logoutPath)+"?id_token_hint="+url.QueryEscape(idToken), nil)
 	if err != nil {
-		return fmt.Errorf(
-			"Status: %v, Body: %v [%v]", response.Status, string(respBody[:]), readErr)
+		return
 	}
 
-	return oidcErr
+	// The end session endpoint answers with a redirect to the post logout URI,
+	// which is a successful logout as far as we are concerned.
+	httpClient := &http.Client{
+		Transport: client.httpClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 3 {
+		return
+	}
+	return client.checkResponse(resp)
+}
+
+// Revokes the refresh token and ends the session of the id token.
+// Either of the tokens may be empty, in which case the corresponding step is skipped.
+// The session is ended even if the token cannot be revoked; the first error is returned.
+func (client *OIDCClient) Logout(refreshToken string, idToken string) (err error) {
+	if refreshToken != "" {
+		err = client.RevokeToken(refreshToken, RefreshTokenTypeHint)
+	}
+
+	if idToken != "" {
+		if endErr := client.EndSession(idToken); err == nil {
+			err = endErr
+		}
+	}
+	return
 }
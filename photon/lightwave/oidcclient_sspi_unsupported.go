@@ -13,6 +13,9 @@ package lightwave
 
 import "errors"
 
+// GetTokensFromWindowsLogInContext is only supported on Windows.
+// On other platforms use GetTokensByGSSAuthenticator with a Kerberos
+// authenticator from the kerberos package.
 func (client *OIDCClient) GetTokensFromWindowsLogInContext() (tokens *OIDCTokenResponse, err error) {
 	return nil, errors.New("Not supported on this OS")
 }
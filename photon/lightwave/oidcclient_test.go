@@ -240,8 +240,8 @@ var _ = Describe("OIDCClient", func() {
 					resp, err := client.GetTokenByPasswordGrant("u", "p")
 					Expect(resp).To(BeNil())
 					Expect(err).ToNot(BeNil())
-					Expect(err.(OIDCError).Code).To(BeEquivalentTo("invalid_grant"))
-					Expect(err.(OIDCError).Message).ToNot(BeNil())
+					Expect(err.(InvalidGrantError).Code).To(BeEquivalentTo("invalid_grant"))
+					Expect(err.(InvalidGrantError).Message).ToNot(BeNil())
 				})
 			})
 		})
@@ -320,8 +320,8 @@ var _ = Describe("OIDCClient", func() {
 					resp, err := client.GetClientTokenByPasswordGrant("u", "p", "c")
 					Expect(resp).To(BeNil())
 					Expect(err).ToNot(BeNil())
-					Expect(err.(OIDCError).Code).To(BeEquivalentTo("invalid_grant"))
-					Expect(err.(OIDCError).Message).ToNot(BeNil())
+					Expect(err.(InvalidGrantError).Code).To(BeEquivalentTo("invalid_grant"))
+					Expect(err.(InvalidGrantError).Message).ToNot(BeNil())
 				})
 			})
 		})
@@ -460,8 +460,84 @@ var _ = Describe("OIDCClient", func() {
 					resp, err := client.GetTokenByRefreshTokenGrant("rt")
 					Expect(resp).To(BeNil())
 					Expect(err).ToNot(BeNil())
-					Expect(err.(OIDCError).Code).To(BeEquivalentTo("invalid_grant"))
-					Expect(err.(OIDCError).Message).ToNot(BeNil())
+					Expect(err.(InvalidGrantError).Code).To(BeEquivalentTo("invalid_grant"))
+					Expect(err.(InvalidGrantError).Message).ToNot(BeNil())
+				})
+			})
+		})
+	})
+
+	Describe("RevokeToken", func() {
+		Context("with fake server", func() {
+			BeforeEach(func() {
+				client, server = testSetupFakeServer()
+			})
+
+			Context("when server accepts the revocation", func() {
+				BeforeEach(func() {
+					server.SetResponseForPath(revokePath, 200, "")
+				})
+
+				It("succeeds", func() {
+					err := client.RevokeToken("rt", RefreshTokenTypeHint)
+					Expect(err).To(BeNil())
+				})
+			})
+
+			Context("when server responds with error", func() {
+				BeforeEach(func() {
+					server.SetResponseJsonForPath(revokePath, 400, OIDCError{Code: "invalid_request", Message: "bad token"})
+				})
+
+				It("returns an error", func() {
+					err := client.RevokeToken("rt", RefreshTokenTypeHint)
+					Expect(err).ToNot(BeNil())
+					Expect(err.(OIDCError).Code).To(Equal("invalid_request"))
+				})
+			})
+		})
+	})
+
+	Describe("Logout", func() {
+		Context("with fake server", func() {
+			BeforeEach(func() {
+				client, server = testSetupFakeServer()
+			})
+
+			Context("when server accepts the logout", func() {
+				BeforeEach(func() {
+					server.SetResponseForPath(revokePath, 200, "")
+					server.SetResponseForPath(logoutPath, 200, "")
+				})
+
+				It("succeeds", func() {
+					err := client.Logout("rt", "id")
+					Expect(err).To(BeNil())
+				})
+			})
+
+			Context("when end session responds with error", func() {
+				BeforeEach(func() {
+					server.SetResponseForPath(revokePath, 200, "")
+					server.SetResponseForPath(logoutPath, 400, "Error")
+				})
+
+				It("returns an error", func() {
+					err := client.Logout("rt", "id")
+					Expect(err).To(MatchError("Status: 400 Bad Request, Body: Error\n [<nil>]"))
+				})
+			})
+
+			Context("when revocation responds with error", func() {
+				BeforeEach(func() {
+					server.SetResponseForPath(revokePath, 400, "Error")
+					server.SetResponseForPath(logoutPath, 200, "")
+				})
+
+				It("returns an error and still ends the session", func() {
+					err := client.Logout("rt", "id")
+					Expect(err).To(MatchError("Status: 400 Bad Request, Body: Error\n [<nil>]"))
+					Expect(server.RequestsFor("GET", logoutPath)).To(HaveLen(1))
 				})
 			})
 		})
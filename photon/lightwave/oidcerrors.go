@@ -0,0 +1,219 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OIDC error codes returned by Lightwave.
+const (
+	invalidGrantCode           string = "invalid_grant"
+	invalidClientCode          string = "invalid_client"
+	serverErrorCode            string = "server_error"
+	temporarilyUnavailableCode string = "temporarily_unavailable"
+)
+
+const gssContinueNeededPrefix string = "gss_continue_needed:"
+
+// Represents an error document returned by the OIDC server.
+// Errors with a well known meaning are returned as one of the more specific
+// types below, which embed OIDCError.
+type OIDCError struct {
+	Code    string `json:"error"`
+	Message string `json:"error_description"`
+}
+
+func (e OIDCError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Message)
+}
+
+// The credentials, refresh token or GSS ticket were rejected.
+type InvalidGrantError struct {
+	OIDCError
+}
+
+// The client ID is unknown to the server or not allowed to use the grant.
+type InvalidClientError struct {
+	OIDCError
+}
+
+// The account is locked, e.g. after too many failed logins.
+type AccountLockedError struct {
+	OIDCError
+}
+
+// The refresh token has expired; a new login is needed.
+type ExpiredRefreshTokenError struct {
+	OIDCError
+}
+
+// The server needs another round of the GSS negotiation. ServerToken is the
+// base64 encoded token to feed to the GSS authenticator, and the reply must be
+// sent with the same ContextID.
+type GSSContinueNeededError struct {
+	OIDCError
+	ContextID   string
+	ServerToken string
+}
+
+// The server failed to process the request or is temporarily unavailable.
+// Code and Message are only set if the server returned an error document.
+type ServerError struct {
+	StatusCode int
+	Status     string
+	Code       string
+	Message    string
+	Body       string
+}
+
+func (e ServerError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("Status: %v, %v: %v", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("Status: %v, Body: %v", e.Status, e.Body)
+}
+
+// The server returned an error response that is not an OIDC error document,
+// or the response could not be read.
+type ResponseError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	ReadErr    error
+}
+
+func (e ResponseError) Error() string {
+	return fmt.Sprintf("Status: %v, Body: %v [%v]", e.Status, e.Body, e.ReadErr)
+}
+
+func (client *OIDCClient) checkResponse(response *http.Response) (err error) {
+	if response.StatusCode/100 == 2 {
+		return
+	}
+
+	respBody, readErr := ioutil.ReadAll(response.Body)
+	if readErr != nil {
+		return ResponseError{response.StatusCode, response.Status, string(respBody), readErr}
+	}
+
+	var oidcErr OIDCError
+	err = json.Unmarshal(respBody, &oidcErr)
+	if err != nil || oidcErr.Code == "" {
+		if response.StatusCode/100 == 5 {
+			return ServerError{StatusCode: response.StatusCode, Status: response.Status, Body: string(respBody)}
+		}
+		return ResponseError{response.StatusCode, response.Status, string(respBody), nil}
+	}
+
+	return newOIDCError(response, oidcErr)
+}
+
+// Maps an OIDC error document to the most specific error type.
+func newOIDCError(response *http.Response, oidcErr OIDCError) error {
+	if response.StatusCode/100 == 5 || oidcErr.Code == serverErrorCode || oidcErr.Code == temporarilyUnavailableCode {
+		return ServerError{response.StatusCode, response.Status, oidcErr.Code, oidcErr.Message, ""}
+	}
+
+	switch oidcErr.Code {
+	case invalidClientCode:
+		return InvalidClientError{oidcErr}
+	case invalidGrantCode:
+		message := strings.TrimSpace(oidcErr.Message)
+		lower := strings.ToLower(message)
+		switch {
+		case strings.HasPrefix(message, gssContinueNeededPrefix):
+			// The format is gss_continue_needed:'context id':'token from server'
+			parts := strings.SplitN(strings.TrimPrefix(message, gssContinueNeededPrefix), ":", 2)
+			if len(parts) == 2 {
+				return GSSContinueNeededError{oidcErr, parts[0], parts[1]}
+			}
+		case strings.Contains(lower, "locked"):
+			return AccountLockedError{oidcErr}
+		case strings.Contains(lower, "expired") && strings.Contains(lower, "refresh"):
+			return ExpiredRefreshTokenError{oidcErr}
+		}
+		return InvalidGrantError{oidcErr}
+	}
+	return oidcErr
+}
+
+// Error classification
+
+// What the caller should do about an error returned by OIDCClient.
+type ErrorAction int
+
+const (
+	// The error is permanent, e.g. a misconfigured client or a locked account.
+	ActionFail ErrorAction = iota
+
+	// The error is transient and the same request may succeed later.
+	ActionRetry
+
+	// The credentials were rejected and the user should be asked for new ones.
+	ActionReprompt
+)
+
+func (action ErrorAction) String() string {
+	switch action {
+	case ActionRetry:
+		return "retry"
+	case ActionReprompt:
+		return "reprompt"
+	default:
+		return "fail"
+	}
+}
+
+// Tells whether a request that failed with err should be retried, the user
+// should be prompted for credentials again, or the operation should fail.
+func ClassifyError(err error) ErrorAction {
+	switch e := err.(type) {
+	case nil:
+		return ActionFail
+	case ServerError:
+		return ActionRetry
+	case ResponseError:
+		if e.ReadErr != nil || e.StatusCode == http.StatusTooManyRequests {
+			return ActionRetry
+		}
+		return ActionFail
+	case InvalidGrantError, ExpiredRefreshTokenError:
+		return ActionReprompt
+	case AccountLockedError, InvalidClientError, GSSContinueNeededError, OIDCError:
+		return ActionFail
+	case *url.Error:
+		return ClassifyError(e.Err)
+	case *net.OpError:
+		// Connection refused or reset, the server may be restarting.
+		return ActionRetry
+	case net.Error:
+		if e.Timeout() || e.Temporary() {
+			return ActionRetry
+		}
+	}
+	return ActionFail
+}
+
+// Returns true if the request that failed with err may succeed when retried.
+func IsRetryable(err error) bool {
+	return ClassifyError(err) == ActionRetry
+}
+
+// Returns true if the user should be prompted for credentials again.
+func NeedsReprompt(err error) bool {
+	return ClassifyError(err) == ActionReprompt
+}
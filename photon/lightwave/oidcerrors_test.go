@@ -0,0 +1,139 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package lightwave
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+type failingReader struct{}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+var _ = Describe("OIDCErrors", func() {
+	var (
+		client *OIDCClient
+		server *mocks.Server
+	)
+
+	BeforeEach(func() {
+		client, server = testSetupFakeServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	getTokenError := func(status int, oidcErr OIDCError) error {
+		server.SetResponseJsonForPath(tokenPath, status, oidcErr)
+		resp, err := client.GetTokenByPasswordGrant("u", "p")
+		Expect(resp).To(BeNil())
+		Expect(err).ToNot(BeNil())
+		return err
+	}
+
+	Describe("checkResponse", func() {
+		It("returns InvalidGrantError for rejected credentials", func() {
+			err := getTokenError(400, OIDCError{"invalid_grant", "incorrect username or password"})
+			Expect(err).To(Equal(InvalidGrantError{OIDCError{"invalid_grant", "incorrect username or password"}}))
+			Expect(err).To(MatchError("invalid_grant: incorrect username or password"))
+			Expect(ClassifyError(err)).To(Equal(ActionReprompt))
+		})
+
+		It("returns InvalidClientError for unknown clients", func() {
+			err := getTokenError(401, OIDCError{"invalid_client", "client not found"})
+			_, ok := err.(InvalidClientError)
+			Expect(ok).To(BeTrue())
+			Expect(ClassifyError(err)).To(Equal(ActionFail))
+		})
+
+		It("returns AccountLockedError for locked accounts", func() {
+			err := getTokenError(400, OIDCError{"invalid_grant", "User account is locked"})
+			_, ok := err.(AccountLockedError)
+			Expect(ok).To(BeTrue())
+			Expect(ClassifyError(err)).To(Equal(ActionFail))
+		})
+
+		It("returns ExpiredRefreshTokenError for expired refresh tokens", func() {
+			err := getTokenError(400, OIDCError{"invalid_grant", "refresh_token is expired"})
+			_, ok := err.(ExpiredRefreshTokenError)
+			Expect(ok).To(BeTrue())
+			Expect(NeedsReprompt(err)).To(BeTrue())
+		})
+
+		It("returns GSSContinueNeededError with the context id and server token", func() {
+			err := getTokenError(400, OIDCError{"invalid_grant", "gss_continue_needed:CTX:dG9rZW4="})
+			continueErr, ok := err.(GSSContinueNeededError)
+			Expect(ok).To(BeTrue())
+			Expect(continueErr.ContextID).To(Equal("CTX"))
+			Expect(continueErr.ServerToken).To(Equal("dG9rZW4="))
+		})
+
+		It("returns ServerError for server failures", func() {
+			err := getTokenError(500, OIDCError{"server_error", "internal error"})
+			serverErr, ok := err.(ServerError)
+			Expect(ok).To(BeTrue())
+			Expect(serverErr.StatusCode).To(Equal(500))
+			Expect(serverErr.Code).To(Equal("server_error"))
+			Expect(IsRetryable(err)).To(BeTrue())
+		})
+
+		It("returns ServerError for server failures without an error document", func() {
+			server.SetResponseForPath(tokenPath, 503, "Unavailable")
+			_, err := client.GetTokenByPasswordGrant("u", "p")
+			serverErr, ok := err.(ServerError)
+			Expect(ok).To(BeTrue())
+			Expect(serverErr.StatusCode).To(Equal(503))
+			Expect(IsRetryable(err)).To(BeTrue())
+		})
+
+		It("returns OIDCError for other error codes", func() {
+			err := getTokenError(400, OIDCError{"invalid_request", "missing parameter"})
+			Expect(err).To(Equal(OIDCError{"invalid_request", "missing parameter"}))
+			Expect(ClassifyError(err)).To(Equal(ActionFail))
+		})
+
+		It("returns ResponseError when the body cannot be read", func() {
+			response := &http.Response{
+				StatusCode: 400,
+				Status:     "400 Bad Request",
+				Body:       ioutil.NopCloser(failingReader{}),
+			}
+			err := client.checkResponse(response)
+			responseErr, ok := err.(ResponseError)
+			Expect(ok).To(BeTrue())
+			Expect(responseErr.ReadErr).To(MatchError("connection reset"))
+			Expect(IsRetryable(err)).To(BeTrue())
+		})
+	})
+
+	Describe("ClassifyError", func() {
+		It("retries connection failures", func() {
+			_, err := NewOIDCClient("https://127.0.0.1:1", nil, nil).GetTokenByPasswordGrant("u", "p")
+			_, ok := err.(*url.Error)
+			Expect(ok).To(BeTrue())
+			Expect(ClassifyError(err)).To(Equal(ActionRetry))
+		})
+
+		It("fails on unknown errors", func() {
+			Expect(ClassifyError(errors.New("unknown"))).To(Equal(ActionFail))
+			Expect(ClassifyError(nil)).To(Equal(ActionFail))
+		})
+	})
+})
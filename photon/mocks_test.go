@@ -12,11 +12,16 @@ package photon
 import (
 	"bytes"
 	"crypto/tls"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
@@ -310,3 +315,43 @@ func isRealAgent() bool {
 func isIntegrationTest() bool {
 	return os.Getenv("TEST_ENDPOINT") != ""
 }
+
+// Creates a mock server and a client that polls it without delay, for tests
+// that script the server responses and cannot run against TEST_ENDPOINT.
+func mockServerClient() (server *mocks.Server, client *Client) {
+	server = mocks.NewTestServer()
+	client = NewClient(server.HttpServer.URL, &ClientOptions{TaskPollDelay: time.Millisecond}, nil)
+	return
+}
+
+// Reads the file and the form fields of a multipart upload the mock server
+// received. The name of the file is in field "filename".
+func readMultipartUpload(request mocks.Request) (file []byte, fields map[string]string, err error) {
+	_, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return
+	}
+	reader := multipart.NewReader(strings.NewReader(request.Body), params["boundary"])
+	fields = map[string]string{}
+	for {
+		var part *multipart.Part
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			return file, fields, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var data []byte
+		data, err = ioutil.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() == "file" {
+			file = data
+			fields["filename"] = part.FileName()
+		} else {
+			fields[part.FormName()] = string(data)
+		}
+	}
+}
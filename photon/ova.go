@@ -0,0 +1,674 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format of the disks Photon imports: stream-optimized VMDK.
+const OvfStreamOptimizedFormat string = "http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"
+
+// Largest OVF descriptor or manifest read.
+const ovaMaxDescriptorSize int64 = 16 << 20
+
+// CIM resource types of the virtual hardware items.
+const (
+	ovfResourceProcessor      int = 3
+	ovfResourceMemory         int = 4
+	ovfResourceSCSIController int = 6
+	ovfResourceEthernet       int = 10
+	ovfResourceDisk           int = 17
+)
+
+// Contents of an OVA package, as read from its OVF descriptor.
+type OvaPackage struct {
+	// Name of the OVF descriptor in the package.
+	Descriptor string
+
+	// Files of the package, in order.
+	Files []OvaFile
+
+	// Name and guest operating system of the virtual system.
+	Name            string
+	OperatingSystem string
+
+	Disks      []OvfDisk
+	Networks   []string
+	Hardware   OvfHardware
+	Properties []OvfProperty
+
+	envelope ovfEnvelope
+
+	// Digests in the manifest, by file and algorithm.
+	manifest map[string]map[string]string
+}
+
+// A file of an OVA package.
+type OvaFile struct {
+	Name string
+	Size int64
+
+	// Hex digests of the file by algorithm, as named in manifests: SHA1,
+	// SHA256 or SHA512.
+	Digests map[string]string
+}
+
+// A virtual disk of an OVF descriptor.
+type OvfDisk struct {
+	ID       string
+	FileName string
+	Format   string
+
+	// Capacity of the disk and, if known, the bytes in use, in bytes.
+	Capacity      int64
+	PopulatedSize int64
+}
+
+// The virtual hardware of an OVF descriptor.
+type OvfHardware struct {
+	// Such as vmx-10.
+	SystemType string
+
+	CPUs     int64
+	MemoryMB int64
+
+	Items []OvfHardwareItem
+}
+
+// A device of the virtual hardware.
+type OvfHardwareItem struct {
+	InstanceID      string
+	ElementName     string
+	ResourceType    int
+	ResourceSubType string
+	Parent          string
+	AddressOnParent string
+	HostResource    string
+	Connection      string
+}
+
+// A property of an OVF product section.
+type OvfProperty struct {
+	Key              string
+	Type             string
+	DefaultValue     string
+	Label            string
+	Description      string
+	UserConfigurable bool
+}
+
+// Problems that keep an OVA package from being imported.
+type OvaError struct {
+	Problems []string
+}
+
+// Implement Go error interface for OvaError.
+func (e OvaError) Error() string {
+	return "photon: Invalid OVA: " + strings.Join(e.Problems, "; ")
+}
+
+type ovfEnvelope struct {
+	Files       []ovfFile       `xml:"References>File"`
+	Disks       []ovfDisk       `xml:"DiskSection>Disk"`
+	Networks    []ovfNetwork    `xml:"NetworkSection>Network"`
+	Systems     []ovfSystem     `xml:"VirtualSystem"`
+	Collections []ovfCollection `xml:"VirtualSystemCollection"`
+}
+
+type ovfFile struct {
+	ID   string `xml:"id,attr"`
+	Href string `xml:"href,attr"`
+	Size string `xml:"size,attr"`
+}
+
+type ovfDisk struct {
+	DiskID        string `xml:"diskId,attr"`
+	FileRef       string `xml:"fileRef,attr"`
+	Capacity      string `xml:"capacity,attr"`
+	CapacityUnits string `xml:"capacityAllocationUnits,attr"`
+	Format        string `xml:"format,attr"`
+	PopulatedSize string `xml:"populatedSize,attr"`
+}
+
+type ovfNetwork struct {
+	Name string `xml:"name,attr"`
+}
+
+type ovfCollection struct {
+	ID string `xml:"id,attr"`
+}
+
+type ovfSystem struct {
+	ID              string        `xml:"id,attr"`
+	Name            string        `xml:"Name"`
+	OperatingSystem ovfOS         `xml:"OperatingSystemSection"`
+	Hardware        []ovfHardware `xml:"VirtualHardwareSection"`
+	Products        []ovfProduct  `xml:"ProductSection"`
+}
+
+type ovfOS struct {
+	Type        string `xml:"osType,attr"`
+	Description string `xml:"Description"`
+}
+
+type ovfHardware struct {
+	SystemType string    `xml:"System>VirtualSystemType"`
+	Items      []ovfItem `xml:"Item"`
+}
+
+type ovfItem struct {
+	InstanceID      string `xml:"InstanceID"`
+	ElementName     string `xml:"ElementName"`
+	ResourceType    string `xml:"ResourceType"`
+	ResourceSubType string `xml:"ResourceSubType"`
+	Parent          string `xml:"Parent"`
+	AddressOnParent string `xml:"AddressOnParent"`
+	HostResource    string `xml:"HostResource"`
+	Connection      string `xml:"Connection"`
+	Quantity        string `xml:"VirtualQuantity"`
+	Units           string `xml:"AllocationUnits"`
+}
+
+type ovfProduct struct {
+	Class      string        `xml:"class,attr"`
+	Instance   string        `xml:"instance,attr"`
+	Properties []ovfProperty `xml:"Property"`
+}
+
+type ovfProperty struct {
+	Key              string `xml:"key,attr"`
+	Type             string `xml:"type,attr"`
+	Value            string `xml:"value,attr"`
+	UserConfigurable string `xml:"userConfigurable,attr"`
+	Label            string `xml:"Label"`
+	Description      string `xml:"Description"`
+}
+
+// Lines of a manifest, like "SHA256(disk1.vmdk)= 0123...".
+var ovaManifestLine = regexp.MustCompile(`^\s*(\w+)\s*\((.+)\)\s*=\s*([0-9a-fA-F]+)\s*$`)
+
+// Allocation units like "byte * 2^30".
+var ovfByteUnits = regexp.MustCompile(`^byte\s*\*\s*2\s*\^\s*(\d+)$`)
+
+var ovaDigesters = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// Reads an OVA package from a file.
+func InspectOvaFile(path string) (pkg *OvaPackage, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return InspectOva(file)
+}
+
+// Reads an OVA package: the tar archive with the OVF descriptor, an optional
+// manifest and the disks. All files are read through, to size them and check
+// them against the manifest. Only unreadable packages are errors; whether
+// Photon can import the package is up to Validate.
+func InspectOva(reader io.Reader) (pkg *OvaPackage, err error) {
+	pkg = &OvaPackage{}
+	var descriptor []byte
+	archive := tar.NewReader(reader)
+	for {
+		var header *tar.Header
+		header, err = archive.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("photon: Reading OVA: %s", err)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		file := OvaFile{Name: header.Name, Digests: map[string]string{}}
+		ext := strings.ToLower(filepath.Ext(header.Name))
+		switch {
+		case ext == ".ovf" && pkg.Descriptor == "":
+			pkg.Descriptor = header.Name
+			descriptor, err = readOvaFile(archive, header)
+			if err == nil {
+				file.Size, err = pkg.digest(bytes.NewReader(descriptor), &file)
+			}
+		case ext == ".mf" && pkg.manifest == nil:
+			var manifest []byte
+			manifest, err = readOvaFile(archive, header)
+			if err == nil {
+				pkg.manifest, err = parseOvaManifest(manifest)
+			}
+			file.Size = int64(len(manifest))
+		default:
+			file.Size, err = pkg.digest(archive, &file)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("photon: Reading '%s' of OVA: %s", header.Name, err)
+		}
+		pkg.Files = append(pkg.Files, file)
+	}
+
+	if pkg.Descriptor == "" {
+		return pkg, nil
+	}
+	err = xml.Unmarshal(descriptor, &pkg.envelope)
+	if err != nil {
+		return nil, fmt.Errorf("photon: Parsing OVF descriptor '%s': %s", pkg.Descriptor, err)
+	}
+	err = pkg.readEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func readOvaFile(archive io.Reader, header *tar.Header) (data []byte, err error) {
+	if header.Size > ovaMaxDescriptorSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", ovaMaxDescriptorSize)
+	}
+	return ioutil.ReadAll(archive)
+}
+
+// Reads a file through, computing the digests the manifest has for it, or all
+// if the manifest is not read yet.
+func (pkg *OvaPackage) digest(archive io.Reader, file *OvaFile) (size int64, err error) {
+	hashes := map[string]hash.Hash{}
+	writers := []io.Writer{}
+	for algorithm, digester := range ovaDigesters {
+		if pkg.manifest != nil && pkg.manifest[file.Name][algorithm] == "" {
+			continue
+		}
+		hashes[algorithm] = digester()
+		writers = append(writers, hashes[algorithm])
+	}
+	if len(writers) == 0 {
+		return io.Copy(ioutil.Discard, archive)
+	}
+	size, err = io.Copy(io.MultiWriter(writers...), archive)
+	for algorithm, h := range hashes {
+		file.Digests[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return
+}
+
+func parseOvaManifest(data []byte) (manifest map[string]map[string]string, err error) {
+	manifest = map[string]map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		match := ovaManifestLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("invalid manifest line '%s'", line)
+		}
+		if manifest[match[2]] == nil {
+			manifest[match[2]] = map[string]string{}
+		}
+		manifest[match[2]][strings.ToUpper(match[1])] = strings.ToLower(match[3])
+	}
+	return manifest, scanner.Err()
+}
+
+// Fills the package in from the parsed envelope.
+func (pkg *OvaPackage) readEnvelope() (err error) {
+	envelope := &pkg.envelope
+	files := map[string]string{}
+	for _, file := range envelope.Files {
+		files[file.ID] = file.Href
+	}
+
+	for _, disk := range envelope.Disks {
+		capacity, err := parseOvfQuantity(disk.Capacity, disk.CapacityUnits)
+		if err != nil {
+			return fmt.Errorf("photon: OVF disk '%s' capacity: %s", disk.DiskID, err)
+		}
+		var populated int64
+		if disk.PopulatedSize != "" {
+			populated, err = strconv.ParseInt(disk.PopulatedSize, 10, 64)
+			if err != nil {
+				return fmt.Errorf("photon: OVF disk '%s' populated size: %s", disk.DiskID, err)
+			}
+		}
+		pkg.Disks = append(pkg.Disks, OvfDisk{
+			ID:            disk.DiskID,
+			FileName:      files[disk.FileRef],
+			Format:        disk.Format,
+			Capacity:      capacity,
+			PopulatedSize: populated,
+		})
+	}
+
+	for _, network := range envelope.Networks {
+		pkg.Networks = append(pkg.Networks, network.Name)
+	}
+
+	if len(envelope.Systems) != 1 {
+		return nil
+	}
+	system := envelope.Systems[0]
+	pkg.Name = system.Name
+	if pkg.Name == "" {
+		pkg.Name = system.ID
+	}
+	pkg.OperatingSystem = system.OperatingSystem.Description
+	if pkg.OperatingSystem == "" {
+		pkg.OperatingSystem = system.OperatingSystem.Type
+	}
+
+	if len(system.Hardware) > 0 {
+		hardware := system.Hardware[0]
+		pkg.Hardware.SystemType = hardware.SystemType
+		for _, item := range hardware.Items {
+			resourceType, err := strconv.Atoi(strings.TrimSpace(item.ResourceType))
+			if err != nil {
+				return fmt.Errorf("photon: OVF hardware item '%s' resource type: %s", item.ElementName, err)
+			}
+			switch resourceType {
+			case ovfResourceProcessor:
+				pkg.Hardware.CPUs, err = parseOvfQuantity(item.Quantity, "")
+			case ovfResourceMemory:
+				var memory int64
+				memory, err = parseOvfQuantity(item.Quantity, item.Units)
+				pkg.Hardware.MemoryMB = memory >> 20
+			}
+			if err != nil {
+				return fmt.Errorf("photon: OVF hardware item '%s': %s", item.ElementName, err)
+			}
+			pkg.Hardware.Items = append(pkg.Hardware.Items, OvfHardwareItem{
+				InstanceID:      item.InstanceID,
+				ElementName:     item.ElementName,
+				ResourceType:    resourceType,
+				ResourceSubType: item.ResourceSubType,
+				Parent:          item.Parent,
+				AddressOnParent: item.AddressOnParent,
+				HostResource:    item.HostResource,
+				Connection:      item.Connection,
+			})
+		}
+	}
+
+	for _, product := range system.Products {
+		for _, property := range product.Properties {
+			// Keys of product sections with a class or instance are qualified.
+			key := property.Key
+			if product.Class != "" {
+				key = product.Class + "." + key
+			}
+			if product.Instance != "" {
+				key = key + "." + product.Instance
+			}
+			pkg.Properties = append(pkg.Properties, OvfProperty{
+				Key:              key,
+				Type:             property.Type,
+				DefaultValue:     property.Value,
+				Label:            property.Label,
+				Description:      property.Description,
+				UserConfigurable: property.UserConfigurable == "true",
+			})
+		}
+	}
+	return nil
+}
+
+// Parses a quantity in allocation units, "byte * 2^20" for example. Units
+// that are empty or "byte" leave the quantity as is; MB and GB are
+// understood as older forms.
+func parseOvfQuantity(quantity string, units string) (value int64, err error) {
+	value, err = strconv.ParseInt(strings.TrimSpace(quantity), 10, 64)
+	if err != nil {
+		return
+	}
+	units = strings.TrimSpace(units)
+	var shift uint
+	switch {
+	case units == "" || units == "byte":
+	case units == "KB" || units == "KiloBytes":
+		shift = 10
+	case units == "MB" || units == "MegaBytes":
+		shift = 20
+	case units == "GB" || units == "GigaBytes":
+		shift = 30
+	default:
+		match := ovfByteUnits.FindStringSubmatch(units)
+		if match == nil {
+			return 0, fmt.Errorf("unknown allocation units '%s'", units)
+		}
+		var exponent int
+		exponent, err = strconv.Atoi(match[1])
+		if err != nil || exponent > 60 {
+			return 0, fmt.Errorf("unknown allocation units '%s'", units)
+		}
+		shift = uint(exponent)
+	}
+	return value << shift, nil
+}
+
+// Checks that Photon can import the package: the descriptor comes first, it
+// describes a single virtual system with CPUs, memory and stream-optimized
+// disks, the files it references are in the package with the sizes given,
+// and the files match the manifest. All problems found are returned in an
+// OvaError.
+func (pkg *OvaPackage) Validate() error {
+	problems := []string{}
+	if pkg.Descriptor == "" {
+		return OvaError{[]string{"package has no OVF descriptor"}}
+	}
+	if pkg.Files[0].Name != pkg.Descriptor {
+		problems = append(problems, fmt.Sprintf("OVF descriptor '%s' is not the first file", pkg.Descriptor))
+	}
+
+	files := map[string]*OvaFile{}
+	for idx := range pkg.Files {
+		files[pkg.Files[idx].Name] = &pkg.Files[idx]
+	}
+
+	envelope := &pkg.envelope
+	if len(envelope.Collections) > 0 {
+		problems = append(problems, "package has a virtual system collection")
+	}
+	if len(envelope.Systems) != 1 {
+		problems = append(problems, fmt.Sprintf("package has %d virtual systems, needs exactly one", len(envelope.Systems)))
+	}
+
+	for _, reference := range envelope.Files {
+		file := files[reference.Href]
+		if file == nil {
+			problems = append(problems, fmt.Sprintf("file '%s' is not in the package", reference.Href))
+			continue
+		}
+		if reference.Size != "" && reference.Size != strconv.FormatInt(file.Size, 10) {
+			problems = append(problems, fmt.Sprintf("file '%s' has %d bytes, not %s", reference.Href, file.Size, reference.Size))
+		}
+	}
+
+	disks := map[string]bool{}
+	if len(pkg.Disks) == 0 {
+		problems = append(problems, "package has no disks")
+	}
+	for _, disk := range pkg.Disks {
+		disks[disk.ID] = true
+		if disk.FileName == "" {
+			problems = append(problems, fmt.Sprintf("disk '%s' has no file", disk.ID))
+		}
+		if disk.Format != OvfStreamOptimizedFormat {
+			problems = append(problems, fmt.Sprintf("disk '%s' has format '%s', not stream-optimized VMDK", disk.ID, disk.Format))
+		}
+	}
+
+	if len(envelope.Systems) == 1 {
+		if pkg.Hardware.CPUs <= 0 {
+			problems = append(problems, "virtual system has no CPUs")
+		}
+		if pkg.Hardware.MemoryMB <= 0 {
+			problems = append(problems, "virtual system has no memory")
+		}
+		for _, item := range pkg.Hardware.Items {
+			if item.ResourceType != ovfResourceDisk {
+				continue
+			}
+			diskID := strings.TrimPrefix(strings.TrimPrefix(item.HostResource, "ovf:"), "/disk/")
+			if !disks[diskID] {
+				problems = append(problems, fmt.Sprintf("hardware item '%s' refers to unknown disk '%s'", item.ElementName, item.HostResource))
+			}
+		}
+	}
+
+	listed := []string{}
+	for name := range pkg.manifest {
+		listed = append(listed, name)
+	}
+	sort.Strings(listed)
+	for _, name := range listed {
+		file := files[name]
+		if file == nil {
+			problems = append(problems, fmt.Sprintf("manifest lists file '%s', which is not in the package", name))
+			continue
+		}
+		algorithms := []string{}
+		for algorithm := range pkg.manifest[name] {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+		for _, algorithm := range algorithms {
+			digest, known := file.Digests[algorithm]
+			switch {
+			case ovaDigesters[algorithm] == nil:
+				problems = append(problems, fmt.Sprintf("manifest has unknown digest algorithm %s for file '%s'", algorithm, name))
+			case !known:
+				problems = append(problems, fmt.Sprintf("file '%s' precedes the manifest and cannot be checked", name))
+			case digest != pkg.manifest[name][algorithm]:
+				problems = append(problems, fmt.Sprintf("file '%s' does not match its %s digest in the manifest", name, algorithm))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return OvaError{problems}
+}
+
+// Returns the settings of the package in the form of Image.Settings: the
+// device types of the SCSI controllers and network adapters, then the
+// properties with their default values.
+func (pkg *OvaPackage) Settings() (settings []ImageSetting) {
+	scsi, ethernet := 0, 0
+	for _, item := range pkg.Hardware.Items {
+		switch item.ResourceType {
+		case ovfResourceSCSIController:
+			settings = append(settings, ImageSetting{fmt.Sprintf("scsi%d.virtualDev", scsi), item.ResourceSubType})
+			scsi++
+		case ovfResourceEthernet:
+			settings = append(settings, ImageSetting{fmt.Sprintf("ethernet%d.virtualDev", ethernet), item.ResourceSubType})
+			ethernet++
+		}
+	}
+	for _, property := range pkg.Properties {
+		settings = append(settings, ImageSetting{property.Key, property.DefaultValue})
+	}
+	return
+}
+
+// Formats the package for review before it is imported: the virtual system,
+// its disks and its settings.
+func (pkg *OvaPackage) String() string {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Name:\t%s\n", pkg.Name)
+	fmt.Fprintf(writer, "Operating system:\t%s\n", pkg.OperatingSystem)
+	fmt.Fprintf(writer, "Hardware:\t%s, %d CPUs, %d MB memory\n", pkg.Hardware.SystemType, pkg.Hardware.CPUs, pkg.Hardware.MemoryMB)
+	fmt.Fprintf(writer, "Networks:\t%s\n", strings.Join(pkg.Networks, ", "))
+	writer.Flush()
+
+	writer = tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "\nDISK\tFILE\tCAPACITY\tFORMAT")
+	for _, disk := range pkg.Disks {
+		format := disk.Format
+		if format == OvfStreamOptimizedFormat {
+			format = "streamOptimized"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s GB\t%s\n", disk.ID, disk.FileName,
+			formatQuotaValue(float64(disk.Capacity)/quotaUnitBytes[QuotaUnitGB]), format)
+	}
+	writer.Flush()
+
+	writer = tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "\nSETTING\tDEFAULT")
+	for _, setting := range pkg.Settings() {
+		fmt.Fprintf(writer, "%s\t%s\n", setting.Name, setting.DefaultValue)
+	}
+	writer.Flush()
+	return buf.String()
+}
+
+// Inspects and validates an OVA package, then uploads it as ImagesAPI.Create
+// does. The reader is read through once for the inspection, then from the
+// same position again for the upload. Returns the package with the task, or
+// an OvaError if the package cannot be imported.
+func (api *ImagesAPI) ImportOva(reader io.ReadSeeker, name string, options *ImageCreateOptions) (pkg *OvaPackage, task *Task, err error) {
+	if options != nil && options.ReplicationType != "" &&
+		options.ReplicationType != ImageReplicationEager && options.ReplicationType != ImageReplicationOnDemand {
+		return nil, nil, fmt.Errorf("photon: Unknown image replication type '%s'", options.ReplicationType)
+	}
+	start, err := reader.Seek(0, 1)
+	if err != nil {
+		return
+	}
+	pkg, err = InspectOva(reader)
+	if err != nil {
+		return
+	}
+	err = pkg.Validate()
+	if err != nil {
+		return
+	}
+	_, err = reader.Seek(start, 0)
+	if err != nil {
+		return
+	}
+	task, err = api.Create(reader, name, options)
+	return
+}
+
+// Imports an OVA package from a file, as ImportOva does.
+func (api *ImagesAPI) ImportOvaFile(path string, options *ImageCreateOptions) (pkg *OvaPackage, task *Task, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return api.ImportOva(file, filepath.Base(path), options)
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+const testOvfDescriptor = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1"
+    xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1"
+    xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData"
+    xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData">
+  <References>
+    <File ovf:href="tty-disk1.vmdk" ovf:id="file1" ovf:size="%d"/>
+  </References>
+  <DiskSection>
+    <Info>Virtual disk information</Info>
+    <Disk ovf:capacity="2" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="vmdisk1"
+        ovf:fileRef="file1" ovf:format="%s" ovf:populatedSize="1048576"/>
+  </DiskSection>
+  <NetworkSection>
+    <Info>The list of logical networks</Info>
+    <Network ovf:name="VM Network"/>
+  </NetworkSection>
+  <VirtualSystem ovf:id="tty">
+    <Name>ttylinux</Name>
+    <OperatingSystemSection ovf:id="36" ovf:osType="otherLinuxGuest">
+      <Description>Other Linux</Description>
+    </OperatingSystemSection>
+    <VirtualHardwareSection>
+      <System>
+        <vssd:VirtualSystemType>vmx-10</vssd:VirtualSystemType>
+      </System>
+      <Item>
+        <rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits>
+        <rasd:ElementName>1 virtual CPU(s)</rasd:ElementName>
+        <rasd:InstanceID>1</rasd:InstanceID>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>1</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:ElementName>256MB of memory</rasd:ElementName>
+        <rasd:InstanceID>2</rasd:InstanceID>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>256</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:ElementName>SCSI controller 0</rasd:ElementName>
+        <rasd:InstanceID>3</rasd:InstanceID>
+        <rasd:ResourceSubType>lsilogic</rasd:ResourceSubType>
+        <rasd:ResourceType>6</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>0</rasd:AddressOnParent>
+        <rasd:ElementName>Hard disk 1</rasd:ElementName>
+        <rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource>
+        <rasd:InstanceID>4</rasd:InstanceID>
+        <rasd:Parent>3</rasd:Parent>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:Connection>VM Network</rasd:Connection>
+        <rasd:ElementName>Network adapter 1</rasd:ElementName>
+        <rasd:InstanceID>5</rasd:InstanceID>
+        <rasd:ResourceSubType>E1000</rasd:ResourceSubType>
+        <rasd:ResourceType>10</rasd:ResourceType>
+      </Item>
+    </VirtualHardwareSection>
+    <ProductSection>
+      <Info>Appliance properties</Info>
+      <Property ovf:key="hostname" ovf:type="string" ovf:value="tty" ovf:userConfigurable="true">
+        <Label>Host name</Label>
+      </Property>
+    </ProductSection>
+  </VirtualSystem>
+</Envelope>`
+
+type testOvaFile struct {
+	name string
+	data []byte
+}
+
+func buildTestOva(files ...testOvaFile) []byte {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, file := range files {
+		writer.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data))})
+		writer.Write(file.data)
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func testOvaManifest(files ...testOvaFile) testOvaFile {
+	var buf bytes.Buffer
+	for _, file := range files {
+		sum := sha256.Sum256(file.data)
+		fmt.Fprintf(&buf, "SHA256(%s)= %s\n", file.name, hex.EncodeToString(sum[:]))
+	}
+	return testOvaFile{"tty.mf", buf.Bytes()}
+}
+
+var _ = Describe("OVA", func() {
+	var (
+		disk       testOvaFile
+		descriptor testOvaFile
+	)
+
+	BeforeEach(func() {
+		disk = testOvaFile{"tty-disk1.vmdk", bytes.Repeat([]byte("vmdk"), 1024)}
+		descriptor = testOvaFile{"tty.ovf", []byte(fmt.Sprintf(testOvfDescriptor, len(disk.data), OvfStreamOptimizedFormat))}
+	})
+
+	It("reads the descriptor", func() {
+		ova := buildTestOva(descriptor, testOvaManifest(descriptor, disk), disk)
+		pkg, err := InspectOva(bytes.NewReader(ova))
+		Expect(err).Should(BeNil())
+		Expect(pkg.Validate()).Should(BeNil())
+
+		Expect(pkg.Descriptor).Should(Equal("tty.ovf"))
+		Expect(pkg.Files).Should(HaveLen(3))
+		Expect(pkg.Files[2].Size).Should(Equal(int64(4096)))
+		Expect(pkg.Name).Should(Equal("ttylinux"))
+		Expect(pkg.OperatingSystem).Should(Equal("Other Linux"))
+		Expect(pkg.Networks).Should(Equal([]string{"VM Network"}))
+		Expect(pkg.Disks).Should(Equal([]OvfDisk{{
+			ID:            "vmdisk1",
+			FileName:      "tty-disk1.vmdk",
+			Format:        OvfStreamOptimizedFormat,
+			Capacity:      2 << 30,
+			PopulatedSize: 1 << 20,
+		}}))
+		Expect(pkg.Hardware.SystemType).Should(Equal("vmx-10"))
+		Expect(pkg.Hardware.CPUs).Should(Equal(int64(1)))
+		Expect(pkg.Hardware.MemoryMB).Should(Equal(int64(256)))
+		Expect(pkg.Hardware.Items).Should(HaveLen(5))
+		Expect(pkg.Properties).Should(Equal([]OvfProperty{
+			{Key: "hostname", Type: "string", DefaultValue: "tty", Label: "Host name", UserConfigurable: true},
+		}))
+		Expect(pkg.Settings()).Should(Equal([]ImageSetting{
+			{"scsi0.virtualDev", "lsilogic"},
+			{"ethernet0.virtualDev", "E1000"},
+			{"hostname", "tty"},
+		}))
+
+		summary := pkg.String()
+		Expect(summary).Should(ContainSubstring("vmx-10, 1 CPUs, 256 MB memory"))
+		Expect(summary).Should(ContainSubstring("tty-disk1.vmdk  2 GB"))
+		Expect(summary).Should(ContainSubstring("scsi0.virtualDev"))
+	})
+
+	It("reports all problems with the package at once", func() {
+		descriptor.data = []byte(fmt.Sprintf(testOvfDescriptor, 10, "http://www.vmware.com/interfaces/specifications/vmdk.html#sparse"))
+		manifest := testOvaManifest(descriptor, disk)
+		disk.data[0] = 'V'
+		pkg, err := InspectOva(bytes.NewReader(buildTestOva(manifest, descriptor, disk)))
+		Expect(err).Should(BeNil())
+		Expect(pkg.Validate()).Should(Equal(OvaError{[]string{
+			"OVF descriptor 'tty.ovf' is not the first file",
+			"file 'tty-disk1.vmdk' has 4096 bytes, not 10",
+			"disk 'vmdisk1' has format 'http://www.vmware.com/interfaces/specifications/vmdk.html#sparse', not stream-optimized VMDK",
+			"file 'tty-disk1.vmdk' does not match its SHA256 digest in the manifest",
+		}}))
+	})
+
+	It("reports missing files", func() {
+		pkg, err := InspectOva(bytes.NewReader(buildTestOva(descriptor, testOvaManifest(descriptor, disk))))
+		Expect(err).Should(BeNil())
+		Expect(pkg.Validate()).Should(Equal(OvaError{[]string{
+			"file 'tty-disk1.vmdk' is not in the package",
+			"manifest lists file 'tty-disk1.vmdk', which is not in the package",
+		}}))
+
+		pkg, err = InspectOva(bytes.NewReader(buildTestOva(disk)))
+		Expect(err).Should(BeNil())
+		Expect(pkg.Validate()).Should(Equal(OvaError{[]string{"package has no OVF descriptor"}}))
+	})
+
+	It("fails on what is not an OVA", func() {
+		_, err := InspectOva(strings.NewReader("not a tar archive, but long enough to hold a tar header if it were one"))
+		Expect(err).ShouldNot(BeNil())
+
+		descriptor.data = []byte("<Envelope><DiskSection>")
+		_, err = InspectOva(bytes.NewReader(buildTestOva(descriptor)))
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	Describe("import", func() {
+		var (
+			server *mocks.Server
+			client *Client
+		)
+
+		// The file name, package name and replication type of each upload.
+		uploads := func() (uploads []map[string]string) {
+			for _, request := range server.RequestsFor("POST", imageUrl) {
+				file, fields, err := readMultipartUpload(request)
+				Expect(err).Should(BeNil())
+				pkg, err := InspectOva(bytes.NewReader(file))
+				Expect(err).Should(BeNil())
+				uploads = append(uploads, map[string]string{
+					"filename":         fields["filename"],
+					"name":             pkg.Name,
+					"ImageReplication": fields["ImageReplication"],
+				})
+			}
+			return
+		}
+
+		BeforeEach(func() {
+			if isIntegrationTest() {
+				Skip("Skipping OVA import test on integration mode.")
+			}
+			server, client = mockServerClient()
+			server.SetResponseJson(200, &Task{ID: "task-1", State: "QUEUED"})
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("uploads valid packages", func() {
+			ova := buildTestOva(descriptor, disk)
+			pkg, task, err := client.Images.ImportOva(bytes.NewReader(ova), "tty.ova",
+				&ImageCreateOptions{ReplicationType: ImageReplicationEager})
+			Expect(err).Should(BeNil())
+			Expect(pkg.Name).Should(Equal("ttylinux"))
+			Expect(task.ID).Should(Equal("task-1"))
+			Expect(uploads()).Should(Equal([]map[string]string{{
+				"filename":         "tty.ova",
+				"name":             "ttylinux",
+				"ImageReplication": ImageReplicationEager,
+			}}))
+		})
+
+		It("does not upload invalid packages", func() {
+			_, _, err := client.Images.ImportOva(bytes.NewReader(buildTestOva(descriptor)), "tty.ova", nil)
+			Expect(err).Should(BeAssignableToTypeOf(OvaError{}))
+
+			_, _, err = client.Images.ImportOva(bytes.NewReader(buildTestOva(descriptor, disk)), "tty.ova",
+				&ImageCreateOptions{ReplicationType: "LAZY"})
+			Expect(err).ShouldNot(BeNil())
+			Expect(server.RequestsFor("POST", imageUrl)).Should(BeEmpty())
+		})
+	})
+})
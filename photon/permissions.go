@@ -0,0 +1,383 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
+)
+
+// Levels of the hierarchy IAM policies and security groups are attached to.
+const (
+	ScopeSystem   string = "system"
+	ScopeTenant   string = "tenant"
+	ScopeProject  string = "project"
+	ScopeResource string = "resource"
+)
+
+// Roles granted by security group membership rather than by an IAM policy.
+const (
+	SystemAdminRole string = "system-admin"
+	TenantAdminRole string = "tenant-admin"
+	ProjectUserRole string = "project-user"
+)
+
+// Sources of a role grant.
+const (
+	GrantSourceIam           string = "iam"
+	GrantSourceSecurityGroup string = "security-group"
+)
+
+// A user and the groups it belongs to, usually taken from its access token.
+type PermissionSubject struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+}
+
+// Returns the subject described by the token's subject and groups claims.
+func SubjectFromToken(token *lightwave.JWTToken) *PermissionSubject {
+	return &PermissionSubject{Name: token.Subject, Groups: token.Groups}
+}
+
+// Identifies a resource and its place in the hierarchy; Kind is one of the
+// EntityKind constants for VMs, disks and images. Kind and ID may be
+// empty to evaluate the permissions on the project, ProjectID may also be
+// empty for the tenant, and TenantID too for the system.
+type ResourceRef struct {
+	TenantID  string `json:"tenantId"`
+	ProjectID string `json:"projectId,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	ID        string `json:"id,omitempty"`
+}
+
+// Security groups and IAM policies of the hierarchy, as far as they have been
+// fetched. A snapshot can be saved and evaluated offline later.
+type PolicySnapshot struct {
+	SystemSecurityGroups []string                 `json:"systemSecurityGroups"`
+	Tenants              map[string]*EntityPolicy `json:"tenants"`
+	Projects             map[string]*EntityPolicy `json:"projects"`
+	Resources            map[string]*EntityPolicy `json:"resources"`
+}
+
+// Security groups and IAM policy of one tenant, project or resource.
+type EntityPolicy struct {
+	Name           string          `json:"name,omitempty"`
+	SecurityGroups []SecurityGroup `json:"securityGroups,omitempty"`
+	Policy         []*RoleBinding  `json:"policy"`
+}
+
+func NewPolicySnapshot() *PolicySnapshot {
+	return &PolicySnapshot{
+		Tenants:   map[string]*EntityPolicy{},
+		Projects:  map[string]*EntityPolicy{},
+		Resources: map[string]*EntityPolicy{},
+	}
+}
+
+// Reads a snapshot written by PolicySnapshot.Save.
+func LoadPolicySnapshot(r io.Reader) (snapshot *PolicySnapshot, err error) {
+	snapshot = NewPolicySnapshot()
+	err = json.NewDecoder(r).Decode(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Writes the snapshot as JSON.
+func (snapshot *PolicySnapshot) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(snapshot)
+}
+
+func resourceKey(kind string, id string) string {
+	return kind + "/" + id
+}
+
+// A role granted to the subject, and where it came from.
+type RoleGrant struct {
+	Role string `json:"role"`
+
+	// Scope and ID of the entity the grant is attached to.
+	Scope   string `json:"scope"`
+	ScopeID string `json:"scopeId,omitempty"`
+
+	// GrantSourceIam or GrantSourceSecurityGroup.
+	Source string `json:"source"`
+
+	// The user or group of the subject the grant matched.
+	Principal string `json:"principal"`
+
+	// Set for security groups a project inherited from its tenant.
+	Inherited bool `json:"inherited,omitempty"`
+}
+
+func (grant RoleGrant) String() string {
+	scope := grant.Scope
+	if grant.ScopeID != "" {
+		scope = fmt.Sprintf("%s '%s'", grant.Scope, grant.ScopeID)
+	}
+	source := "IAM policy"
+	if grant.Source == GrantSourceSecurityGroup {
+		source = "security group"
+		if grant.Inherited {
+			source = "inherited security group"
+		}
+	}
+	return fmt.Sprintf("%s on %s via %s '%s'", grant.Role, scope, source, grant.Principal)
+}
+
+// The roles a subject has on a resource, including the ones inherited from
+// the levels above it.
+type EffectivePermissions struct {
+	Subject  *PermissionSubject `json:"subject"`
+	Resource ResourceRef        `json:"resource"`
+
+	// Distinct roles, sorted.
+	Roles []string `json:"roles"`
+
+	// Every grant that contributed a role, from the system level down.
+	Grants []RoleGrant `json:"grants"`
+}
+
+func (p *EffectivePermissions) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns one line per grant saying where each role came from.
+func (p *EffectivePermissions) Explain() string {
+	if len(p.Grants) == 0 {
+		return fmt.Sprintf("%s has no roles", p.Subject.Name)
+	}
+	lines := make([]string, len(p.Grants))
+	for idx, grant := range p.Grants {
+		lines[idx] = grant.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Computes effective permissions from a policy snapshot. An evaluator created
+// with a client fetches whatever is missing from the snapshot and keeps it;
+// one created without works offline on the snapshot alone.
+type PermissionEvaluator struct {
+	client *Client
+	lock   sync.Mutex
+
+	Snapshot *PolicySnapshot
+}
+
+// Creates an evaluator that fetches policies with the client and caches them.
+func NewPermissionEvaluator(client *Client) *PermissionEvaluator {
+	return &PermissionEvaluator{client: client, Snapshot: NewPolicySnapshot()}
+}
+
+// Creates an evaluator that only uses the given snapshot.
+func NewOfflinePermissionEvaluator(snapshot *PolicySnapshot) *PermissionEvaluator {
+	return &PermissionEvaluator{Snapshot: snapshot}
+}
+
+// Returns the roles the subject has on the resource, walking the hierarchy from
+// the system down to the resource.
+func (e *PermissionEvaluator) EffectivePermissions(subject *PermissionSubject, ref ResourceRef) (perms *EffectivePermissions, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	principals := newPrincipalSet(subject)
+	perms = &EffectivePermissions{Subject: subject, Resource: ref}
+
+	systemGroups, err := e.systemSecurityGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range systemGroups {
+		if principal, ok := principals.match(group); ok {
+			perms.add(RoleGrant{SystemAdminRole, ScopeSystem, "", GrantSourceSecurityGroup, principal, false})
+		}
+	}
+
+	if ref.TenantID != "" {
+		tenant, err := e.tenantPolicy(ref.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		perms.addEntity(principals, tenant, ScopeTenant, ref.TenantID, TenantAdminRole)
+	}
+
+	if ref.ProjectID != "" {
+		project, err := e.projectPolicy(ref.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		perms.addEntity(principals, project, ScopeProject, ref.ProjectID, ProjectUserRole)
+	}
+
+	if ref.Kind != "" {
+		resource, err := e.resourcePolicy(ref.Kind, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		perms.addEntity(principals, resource, ScopeResource, resourceKey(ref.Kind, ref.ID), "")
+	}
+
+	sort.Strings(perms.Roles)
+	return
+}
+
+// Adds the grants from the security groups and IAM policy of an entity.
+func (p *EffectivePermissions) addEntity(
+	principals principalSet, entity *EntityPolicy, scope string, scopeID string, groupRole string) {
+
+	for _, group := range entity.SecurityGroups {
+		if principal, ok := principals.match(group.Name); ok && groupRole != "" {
+			p.add(RoleGrant{groupRole, scope, scopeID, GrantSourceSecurityGroup, principal, group.Inherited})
+		}
+	}
+	for _, binding := range entity.Policy {
+		for _, subject := range binding.Subjects {
+			if principal, ok := principals.match(subject); ok {
+				p.add(RoleGrant{binding.Role, scope, scopeID, GrantSourceIam, principal, false})
+			}
+		}
+	}
+}
+
+func (p *EffectivePermissions) add(grant RoleGrant) {
+	p.Grants = append(p.Grants, grant)
+	if !p.HasRole(grant.Role) {
+		p.Roles = append(p.Roles, grant.Role)
+	}
+}
+
+// Principal matching
+
+// Maps the normalized names of a subject and its groups to the names as given.
+type principalSet map[string]string
+
+func newPrincipalSet(subject *PermissionSubject) principalSet {
+	principals := principalSet{}
+	principals[normalizePrincipal(subject.Name)] = subject.Name
+	for _, group := range subject.Groups {
+		principals[normalizePrincipal(group)] = group
+	}
+	return principals
+}
+
+func (principals principalSet) match(name string) (principal string, ok bool) {
+	principal, ok = principals[normalizePrincipal(name)]
+	return
+}
+
+// Lightwave names principals both as name@domain and domain\name, in any case.
+func normalizePrincipal(name string) string {
+	name = strings.ToLower(name)
+	if idx := strings.Index(name, "\\"); idx >= 0 {
+		return name[idx+1:] + "@" + name[:idx]
+	}
+	return name
+}
+
+// Policy lookup, fetching missing entries if there is a client
+
+func (e *PermissionEvaluator) systemSecurityGroups() (groups []string, err error) {
+	if e.Snapshot.SystemSecurityGroups != nil || e.client == nil {
+		return e.Snapshot.SystemSecurityGroups, nil
+	}
+
+	authInfo, err := e.client.System.GetAuthInfo()
+	if err != nil {
+		return
+	}
+	e.Snapshot.SystemSecurityGroups = authInfo.SecurityGroups
+	if e.Snapshot.SystemSecurityGroups == nil {
+		e.Snapshot.SystemSecurityGroups = []string{}
+	}
+	return e.Snapshot.SystemSecurityGroups, nil
+}
+
+func (e *PermissionEvaluator) tenantPolicy(id string) (entity *EntityPolicy, err error) {
+	entity, ok := e.Snapshot.Tenants[id]
+	if ok {
+		return
+	}
+	if e.client == nil {
+		return nil, missingFromSnapshot(ScopeTenant, id)
+	}
+
+	tenant, err := e.client.Tenants.Get(id)
+	if err != nil {
+		return
+	}
+	policy, err := e.client.Tenants.GetIam(id)
+	if err != nil {
+		return
+	}
+
+	entity = &EntityPolicy{Name: tenant.Name, SecurityGroups: tenant.SecurityGroups, Policy: policy}
+	e.Snapshot.Tenants[id] = entity
+	return
+}
+
+func (e *PermissionEvaluator) projectPolicy(id string) (entity *EntityPolicy, err error) {
+	entity, ok := e.Snapshot.Projects[id]
+	if ok {
+		return
+	}
+	if e.client == nil {
+		return nil, missingFromSnapshot(ScopeProject, id)
+	}
+
+	project, err := e.client.Projects.Get(id)
+	if err != nil {
+		return
+	}
+	policy, err := e.client.Projects.GetIam(id)
+	if err != nil {
+		return
+	}
+
+	entity = &EntityPolicy{Name: project.Name, SecurityGroups: project.SecurityGroups, Policy: policy}
+	e.Snapshot.Projects[id] = entity
+	return
+}
+
+func (e *PermissionEvaluator) resourcePolicy(kind string, id string) (entity *EntityPolicy, err error) {
+	key := resourceKey(kind, id)
+	entity, ok := e.Snapshot.Resources[key]
+	if ok {
+		return
+	}
+	if e.client == nil {
+		return nil, missingFromSnapshot(kind, id)
+	}
+
+	policy, err := e.client.Iam.Get(Entity{ID: id, Kind: kind})
+	if err != nil {
+		return
+	}
+
+	entity = &EntityPolicy{Policy: policy}
+	e.Snapshot.Resources[key] = entity
+	return
+}
+
+func missingFromSnapshot(kind string, id string) error {
+	return fmt.Errorf("photon: Policy of %s '%s' is not in the snapshot", kind, id)
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
+)
+
+var _ = Describe("Permissions", func() {
+	var (
+		snapshot *PolicySnapshot
+		subject  *PermissionSubject
+		vmRef    ResourceRef
+	)
+
+	BeforeEach(func() {
+		snapshot = NewPolicySnapshot()
+		snapshot.SystemSecurityGroups = []string{"photon.local\\Administrators"}
+		snapshot.Tenants["t1"] = &EntityPolicy{
+			Name:           "tenant1",
+			SecurityGroups: []SecurityGroup{{Name: "photon.local\\TenantAdmins", Inherited: false}},
+			Policy:         []*RoleBinding{{Role: "viewer", Subjects: []string{"joe@photon.local"}}},
+		}
+		snapshot.Projects["p1"] = &EntityPolicy{
+			Name: "project1",
+			SecurityGroups: []SecurityGroup{
+				{Name: "photon.local\\TenantAdmins", Inherited: true},
+				{Name: "photon.local\\Developers", Inherited: false},
+			},
+			Policy: []*RoleBinding{{Role: "contributor", Subjects: []string{"photon.local\\Developers"}}},
+		}
+		snapshot.Resources["vm/vm1"] = &EntityPolicy{
+			Policy: []*RoleBinding{{Role: "owner", Subjects: []string{"JOE@PHOTON.LOCAL"}}},
+		}
+
+		subject = &PermissionSubject{Name: "joe@photon.local", Groups: []string{"photon.local\\Developers"}}
+		vmRef = ResourceRef{TenantID: "t1", ProjectID: "p1", Kind: EntityKindVm, ID: "vm1"}
+	})
+
+	Describe("Offline", func() {
+		It("combines the roles of all levels", func() {
+			perms, err := NewOfflinePermissionEvaluator(snapshot).EffectivePermissions(subject, vmRef)
+			Expect(err).Should(BeNil())
+			Expect(perms.Roles).Should(Equal([]string{"contributor", "owner", "project-user", "viewer"}))
+			Expect(perms.HasRole(SystemAdminRole)).Should(BeFalse())
+			Expect(perms.Grants).Should(Equal([]RoleGrant{
+				{"viewer", ScopeTenant, "t1", GrantSourceIam, "joe@photon.local", false},
+				{ProjectUserRole, ScopeProject, "p1", GrantSourceSecurityGroup, "photon.local\\Developers", false},
+				{"contributor", ScopeProject, "p1", GrantSourceIam, "photon.local\\Developers", false},
+				{"owner", ScopeResource, "vm/vm1", GrantSourceIam, "joe@photon.local", false},
+			}))
+			Expect(perms.Explain()).Should(ContainSubstring(
+				"owner on resource 'vm/vm1' via IAM policy 'joe@photon.local'"))
+		})
+
+		It("explains inherited security groups", func() {
+			admin := &PermissionSubject{Name: "ann@photon.local", Groups: []string{"ann@photon.local", "TenantAdmins@photon.local"}}
+			perms, err := NewOfflinePermissionEvaluator(snapshot).EffectivePermissions(admin, vmRef)
+			Expect(err).Should(BeNil())
+			Expect(perms.Roles).Should(Equal([]string{"project-user", "tenant-admin"}))
+			Expect(perms.Explain()).Should(ContainSubstring(
+				"project-user on project 'p1' via inherited security group 'TenantAdmins@photon.local'"))
+		})
+
+		It("grants system admin from the system security groups", func() {
+			admin := &PermissionSubject{Name: "root@photon.local", Groups: []string{"photon.local\\administrators"}}
+			perms, err := NewOfflinePermissionEvaluator(snapshot).EffectivePermissions(admin, ResourceRef{TenantID: "t1"})
+			Expect(err).Should(BeNil())
+			Expect(perms.Roles).Should(Equal([]string{SystemAdminRole}))
+		})
+
+		It("reports no roles", func() {
+			other := &PermissionSubject{Name: "bob@photon.local"}
+			perms, err := NewOfflinePermissionEvaluator(snapshot).EffectivePermissions(other, vmRef)
+			Expect(err).Should(BeNil())
+			Expect(perms.Roles).Should(BeEmpty())
+			Expect(perms.Explain()).Should(Equal("bob@photon.local has no roles"))
+		})
+
+		It("fails for entries missing from the snapshot", func() {
+			_, err := NewOfflinePermissionEvaluator(snapshot).EffectivePermissions(subject,
+				ResourceRef{TenantID: "t1", ProjectID: "p1", Kind: EntityKindDisk, ID: "disk1"})
+			Expect(err).ShouldNot(BeNil())
+			Expect(err.Error()).Should(ContainSubstring("disk 'disk1' is not in the snapshot"))
+		})
+
+		It("saves and loads snapshots", func() {
+			buf := &bytes.Buffer{}
+			Expect(snapshot.Save(buf)).Should(Succeed())
+			loaded, err := LoadPolicySnapshot(buf)
+			Expect(err).Should(BeNil())
+			Expect(loaded).Should(Equal(snapshot))
+		})
+
+		It("takes the subject from a token", func() {
+			token := &lightwave.JWTToken{Subject: "joe@photon.local", Groups: []string{"photon.local\\Developers"}}
+			Expect(SubjectFromToken(token)).Should(Equal(subject))
+		})
+	})
+
+	Describe("Online", func() {
+		var (
+			server *mocks.Server
+			client *Client
+		)
+
+		BeforeEach(func() {
+			if isIntegrationTest() {
+				Skip("Skipping permission evaluator test on integration mode.")
+			}
+			responses := map[string]interface{}{
+				rootUrl + "/system/auth":     &AuthInfo{SecurityGroups: snapshot.SystemSecurityGroups},
+				rootUrl + "/tenants/t1":      &Tenant{ID: "t1", Name: "tenant1", SecurityGroups: snapshot.Tenants["t1"].SecurityGroups},
+				rootUrl + "/tenants/t1/iam":  snapshot.Tenants["t1"].Policy,
+				rootUrl + "/projects/p1":     &ProjectCompact{ID: "p1", Name: "project1", SecurityGroups: snapshot.Projects["p1"].SecurityGroups},
+				rootUrl + "/projects/p1/iam": snapshot.Projects["p1"].Policy,
+				rootUrl + "/vms/vm1/iam":     snapshot.Resources["vm/vm1"].Policy,
+			}
+			server, client = mockServerClient()
+			server.SetResponseJson(404, createMockApiError("NotFound", "Not found", 404))
+			for path, response := range responses {
+				server.SetResponseJsonForPath(path, 200, response)
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("fetches and caches the policies", func() {
+			evaluator := NewPermissionEvaluator(client)
+			perms, err := evaluator.EffectivePermissions(subject, vmRef)
+			Expect(err).Should(BeNil())
+			Expect(perms.Roles).Should(Equal([]string{"contributor", "owner", "project-user", "viewer"}))
+
+			_, err = evaluator.EffectivePermissions(subject, vmRef)
+			Expect(err).Should(BeNil())
+			Expect(server.RequestsFor("GET", rootUrl+"/tenants/t1/iam")).Should(HaveLen(1))
+			Expect(server.RequestsFor("GET", rootUrl+"/vms/vm1/iam")).Should(HaveLen(1))
+
+			Expect(evaluator.Snapshot.Projects["p1"]).Should(Equal(snapshot.Projects["p1"]))
+		})
+
+		It("returns API errors", func() {
+			evaluator := NewPermissionEvaluator(client)
+			_, err := evaluator.EffectivePermissions(subject, ResourceRef{TenantID: "t2"})
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+})
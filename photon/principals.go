@@ -0,0 +1,76 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
+)
+
+// Returned when principals passed to SetIam or SetSecurityGroups do not exist in Lightwave.
+type UnknownPrincipalsError struct {
+	Principals []string
+}
+
+func (e UnknownPrincipalsError) Error() string {
+	return fmt.Sprintf("photon: Unknown principals: %s", strings.Join(e.Principals, ", "))
+}
+
+// Checks that every subject of the policy exists in Lightwave, before it is
+// passed to one of the SetIam calls. Unknown subjects are returned all at once
+// in an UnknownPrincipalsError.
+func ValidateRoleBindings(idmClient *lightwave.IDMClient, policy []*RoleBinding) (err error) {
+	var subjects []string
+	for _, binding := range policy {
+		subjects = append(subjects, binding.Subjects...)
+	}
+	return validatePrincipals(idmClient, subjects, "")
+}
+
+// Checks that every security group exists as a group in Lightwave, before it
+// is passed to one of the SetSecurityGroups calls. Unknown groups are returned
+// all at once in an UnknownPrincipalsError.
+func ValidateSecurityGroups(idmClient *lightwave.IDMClient, securityGroups *SecurityGroupsSpec) (err error) {
+	if securityGroups == nil {
+		return
+	}
+	return validatePrincipals(idmClient, securityGroups.Items, lightwave.MemberTypeGroup)
+}
+
+// If principalType is not empty, principals of other types are reported as unknown.
+func validatePrincipals(idmClient *lightwave.IDMClient, principals []string, principalType string) (err error) {
+	unknown := []string{}
+	seen := map[string]bool{}
+	for _, principal := range principals {
+		if seen[principal] {
+			continue
+		}
+		seen[principal] = true
+
+		resolved, err := idmClient.ResolvePrincipal(principal)
+		if _, ok := err.(lightwave.PrincipalNotFoundError); ok {
+			unknown = append(unknown, principal)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if principalType != "" && resolved.Type != principalType {
+			unknown = append(unknown, principal)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return UnknownPrincipalsError{unknown}
+	}
+	return
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
+)
+
+var _ = Describe("Principals", func() {
+	var (
+		idmServer *mocks.Server
+		idmClient *lightwave.IDMClient
+	)
+
+	BeforeEach(func() {
+		idmServer = mocks.NewTlsTestServer()
+		idmServer.SetResponseJson(404, &lightwave.IDMError{Code: "not_found"})
+		idmServer.SetResponseJsonForPath("/idm/tenant/photon.local/users/joe@photon.local", 200,
+			&lightwave.IDMUser{Name: "joe", Domain: "photon.local"})
+		idmServer.SetResponseJsonForPath("/idm/tenant/photon.local/groups/admins@photon.local", 200,
+			&lightwave.IDMGroup{Name: "admins", Domain: "photon.local"})
+
+		idmClient = lightwave.NewIDMClient(idmServer.HttpServer.URL, "photon.local", "fake_token",
+			&lightwave.OIDCClientOptions{IgnoreCertificate: true}, nil)
+	})
+
+	AfterEach(func() {
+		idmServer.Close()
+	})
+
+	Describe("ValidateRoleBindings", func() {
+		It("accepts known users and groups", func() {
+			policy := []*RoleBinding{
+				{Role: "owner", Subjects: []string{"joe@photon.local"}},
+				{Role: "viewer", Subjects: []string{"photon.local\\admins", "joe@photon.local"}},
+			}
+			err := ValidateRoleBindings(idmClient, policy)
+			Expect(err).Should(BeNil())
+		})
+
+		It("reports all unknown subjects", func() {
+			policy := []*RoleBinding{
+				{Role: "owner", Subjects: []string{"joe@photon.local", "ann@photon.local"}},
+				{Role: "viewer", Subjects: []string{"photon.local\\nobody"}},
+			}
+			err := ValidateRoleBindings(idmClient, policy)
+			Expect(err).Should(Equal(UnknownPrincipalsError{[]string{"ann@photon.local", "photon.local\\nobody"}}))
+		})
+
+		It("returns other IDM errors", func() {
+			idmServer.SetResponseJson(500, &lightwave.IDMError{Code: "internal_error"})
+			err := ValidateRoleBindings(idmClient, []*RoleBinding{{Role: "owner", Subjects: []string{"ann@photon.local"}}})
+			idmErr, ok := err.(lightwave.IDMError)
+			Expect(ok).Should(BeTrue())
+			Expect(idmErr.StatusCode).Should(Equal(500))
+		})
+	})
+
+	Describe("ValidateSecurityGroups", func() {
+		It("accepts known groups", func() {
+			err := ValidateSecurityGroups(idmClient, &SecurityGroupsSpec{Items: []string{"photon.local\\admins"}})
+			Expect(err).Should(BeNil())
+		})
+
+		It("rejects users and unknown groups", func() {
+			err := ValidateSecurityGroups(idmClient,
+				&SecurityGroupsSpec{Items: []string{"photon.local\\admins", "joe@photon.local", "photon.local\\nobody"}})
+			Expect(err).Should(Equal(UnknownPrincipalsError{[]string{"joe@photon.local", "photon.local\\nobody"}}))
+		})
+	})
+})
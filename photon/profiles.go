@@ -0,0 +1,281 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Environment variables overriding the profile config file and its values.
+const (
+	ConfigEnvVar            string = "PHOTON_CONFIG"
+	ProfileEnvVar           string = "PHOTON_PROFILE"
+	EndpointEnvVar          string = "PHOTON_ENDPOINT"
+	AuthEndpointEnvVar      string = "PHOTON_AUTH_ENDPOINT"
+	LightwaveTenantEnvVar   string = "PHOTON_LIGHTWAVE_TENANT"
+	CAFilesEnvVar           string = "PHOTON_CA_FILES"
+	IgnoreCertificateEnvVar string = "PHOTON_IGNORE_CERTIFICATE"
+	TaskPollTimeoutEnvVar   string = "PHOTON_TASK_POLL_TIMEOUT"
+	TaskPollDelayEnvVar     string = "PHOTON_TASK_POLL_DELAY"
+	TaskRetryCountEnvVar    string = "PHOTON_TASK_RETRY_COUNT"
+	AccessTokenEnvVar       string = "PHOTON_ACCESS_TOKEN"
+)
+
+const defaultProfileName string = "default"
+
+// A set of named profiles, read from a YAML or JSON file such as:
+//
+//	default_profile: prod
+//	profiles:
+//	  prod:
+//	    endpoint: https://photon.example.com
+//	    ca_files: [/etc/photon/ca.pem]
+//	    task_poll_timeout: 1h
+//	    credentials_files: [/run/secrets/photon]
+type ProfileConfig struct {
+	DefaultProfile string              `json:"default_profile" yaml:"default_profile"`
+	Profiles       map[string]*Profile `json:"profiles" yaml:"profiles"`
+}
+
+// Settings for one Photon deployment. Durations use the time.ParseDuration
+// format, e.g. "30m". Empty values keep the ClientOptions defaults.
+type Profile struct {
+	// Name of the profile in the config file, set by GetProfile.
+	Name string `json:"-" yaml:"-"`
+
+	Endpoint     string `json:"endpoint" yaml:"endpoint"`
+	AuthEndpoint string `json:"auth_endpoint,omitempty" yaml:"auth_endpoint,omitempty"`
+
+	// Lightwave tenant of the deployment, for tools that talk to Lightwave directly.
+	LightwaveTenant string `json:"lightwave_tenant,omitempty" yaml:"lightwave_tenant,omitempty"`
+
+	// PEM files with the root CAs to verify the server certificates with.
+	CAFiles           []string `json:"ca_files,omitempty" yaml:"ca_files,omitempty"`
+	IgnoreCertificate bool     `json:"ignore_certificate,omitempty" yaml:"ignore_certificate,omitempty"`
+
+	TaskPollTimeout string `json:"task_poll_timeout,omitempty" yaml:"task_poll_timeout,omitempty"`
+	TaskPollDelay   string `json:"task_poll_delay,omitempty" yaml:"task_poll_delay,omitempty"`
+	TaskRetryCount  int    `json:"task_retry_count,omitempty" yaml:"task_retry_count,omitempty"`
+
+	// Token sources. Tokens are used as they are; credentials are tried in the
+	// order environment, inline username/password or refresh token, then files.
+	AccessToken      string   `json:"access_token,omitempty" yaml:"access_token,omitempty"`
+	Username         string   `json:"username,omitempty" yaml:"username,omitempty"`
+	Password         string   `json:"password,omitempty" yaml:"password,omitempty"`
+	RefreshToken     string   `json:"refresh_token,omitempty" yaml:"refresh_token,omitempty"`
+	CredentialsFiles []string `json:"credentials_files,omitempty" yaml:"credentials_files,omitempty"`
+}
+
+// Returns the path of the profile config file: PHOTON_CONFIG if set,
+// otherwise config.yaml in the .photon directory of the user's home.
+func DefaultProfileConfigPath() string {
+	if path := os.Getenv(ConfigEnvVar); path != "" {
+		return path
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	return filepath.Join(home, ".photon", "config.yaml")
+}
+
+// Reads a profile config file. Files ending in .json are read as JSON,
+// anything else as YAML.
+func LoadProfileConfig(path string) (config *ProfileConfig, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	config = &ProfileConfig{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, config)
+	} else {
+		err = yaml.Unmarshal(data, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("photon: Invalid profile config '%s': %v", path, err)
+	}
+	return
+}
+
+// Returns a copy of the named profile with the environment overrides applied.
+// If name is empty, PHOTON_PROFILE, the default profile of the file or
+// "default" is used, in that order.
+func (config *ProfileConfig) GetProfile(name string) (profile *Profile, err error) {
+	if name == "" {
+		name = os.Getenv(ProfileEnvVar)
+	}
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		name = defaultProfileName
+	}
+
+	found, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("photon: Profile '%s' not found", name)
+	}
+
+	profile = &Profile{}
+	*profile = *found
+	profile.Name = name
+	err = profile.applyEnvOverrides()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (profile *Profile) applyEnvOverrides() (err error) {
+	overrides := map[string]*string{
+		EndpointEnvVar:        &profile.Endpoint,
+		AuthEndpointEnvVar:    &profile.AuthEndpoint,
+		LightwaveTenantEnvVar: &profile.LightwaveTenant,
+		TaskPollTimeoutEnvVar: &profile.TaskPollTimeout,
+		TaskPollDelayEnvVar:   &profile.TaskPollDelay,
+		AccessTokenEnvVar:     &profile.AccessToken,
+	}
+	for name, field := range overrides {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	if value := os.Getenv(CAFilesEnvVar); value != "" {
+		profile.CAFiles = filepath.SplitList(value)
+	}
+	if value := os.Getenv(IgnoreCertificateEnvVar); value != "" {
+		profile.IgnoreCertificate, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("photon: Invalid %s: %v", IgnoreCertificateEnvVar, err)
+		}
+	}
+	if value := os.Getenv(TaskRetryCountEnvVar); value != "" {
+		profile.TaskRetryCount, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("photon: Invalid %s: %v", TaskRetryCountEnvVar, err)
+		}
+	}
+	return
+}
+
+// Builds the client options described by the profile.
+func (profile *Profile) ClientOptions() (options *ClientOptions, err error) {
+	options = &ClientOptions{
+		IgnoreCertificate: profile.IgnoreCertificate,
+		TaskRetryCount:    profile.TaskRetryCount,
+		AuthEndpoint:      profile.AuthEndpoint,
+	}
+
+	options.TaskPollTimeout, err = parseProfileDuration("task_poll_timeout", profile.TaskPollTimeout)
+	if err != nil {
+		return nil, err
+	}
+	options.TaskPollDelay, err = parseProfileDuration("task_poll_delay", profile.TaskPollDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(profile.CAFiles) > 0 {
+		options.RootCAs, err = loadCAFiles(profile.CAFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if profile.AccessToken != "" {
+		options.TokenOptions = &TokenOptions{AccessToken: profile.AccessToken}
+	}
+
+	// Without any credentials configured the client must not try to log in,
+	// the deployment may not have auth enabled.
+	chain := NewCredentialChain(&EnvCredentialProvider{})
+	if profile.Username != "" || profile.Password != "" || profile.RefreshToken != "" {
+		chain.Providers = append(chain.Providers, &StaticCredentialProvider{Credentials{
+			Username:     profile.Username,
+			Password:     profile.Password,
+			RefreshToken: profile.RefreshToken,
+		}})
+	}
+	for _, path := range profile.CredentialsFiles {
+		chain.Providers = append(chain.Providers, &FileCredentialProvider{Path: path})
+	}
+	if _, envErr := chain.Providers[0].Retrieve(); envErr == nil || len(chain.Providers) > 1 {
+		options.CredentialProvider = chain
+	}
+	return
+}
+
+func parseProfileDuration(name string, value string) (duration time.Duration, err error) {
+	if value == "" {
+		return
+	}
+	duration, err = time.ParseDuration(value)
+	if err != nil {
+		err = fmt.Errorf("photon: Invalid %s '%s': %v", name, value, err)
+	}
+	return
+}
+
+func loadCAFiles(paths []string) (pool *x509.CertPool, err error) {
+	pool = x509.NewCertPool()
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("photon: No PEM certificates found in '%s'", path)
+		}
+	}
+	return
+}
+
+// Creates a client for the named profile of the config file at path. If path is
+// empty DefaultProfileConfigPath is used; see ProfileConfig.GetProfile for how
+// the profile is chosen when name is empty.
+func NewClientFromProfile(path string, name string, logger *log.Logger) (c *Client, err error) {
+	if path == "" {
+		path = DefaultProfileConfigPath()
+	}
+
+	config, err := LoadProfileConfig(path)
+	if err != nil {
+		return
+	}
+
+	profile, err := config.GetProfile(name)
+	if err != nil {
+		return
+	}
+	if profile.Endpoint == "" {
+		return nil, fmt.Errorf("photon: No endpoint set in profile '%s'", profile.Name)
+	}
+
+	options, err := profile.ClientOptions()
+	if err != nil {
+		return
+	}
+
+	return NewClient(profile.Endpoint, options, logger), nil
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+const testProfilesYaml = `
+default_profile: prod
+profiles:
+  prod:
+    endpoint: https://photon.example.com
+    lightwave_tenant: example.com
+    task_poll_timeout: 1h
+    task_poll_delay: 2s
+    task_retry_count: 5
+    username: joe
+    password: secret
+  lab:
+    endpoint: https://lab.example.com
+    ignore_certificate: true
+`
+
+const testProfilesJson = `{
+  "profiles": {
+    "default": {"endpoint": "https://photon.example.com", "access_token": "fake_access_token"}
+  }
+}`
+
+var _ = Describe("Profiles", func() {
+	var (
+		tempDir string
+		saved   map[string]string
+	)
+
+	writeFile := func(name string, content string) string {
+		path := filepath.Join(tempDir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		Expect(err).Should(BeNil())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "photon-profiles")
+		Expect(err).Should(BeNil())
+
+		saved = map[string]string{}
+		for _, name := range []string{
+			ConfigEnvVar, ProfileEnvVar, EndpointEnvVar, CAFilesEnvVar, IgnoreCertificateEnvVar,
+			TaskPollTimeoutEnvVar, TaskRetryCountEnvVar, AccessTokenEnvVar,
+			UsernameEnvVar, PasswordEnvVar, RefreshTokenEnvVar} {
+			saved[name] = os.Getenv(name)
+			os.Setenv(name, "")
+		}
+	})
+
+	AfterEach(func() {
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+		os.RemoveAll(tempDir)
+	})
+
+	Describe("LoadProfileConfig", func() {
+		It("reads YAML", func() {
+			config, err := LoadProfileConfig(writeFile("config.yaml", testProfilesYaml))
+			Expect(err).Should(BeNil())
+			Expect(config.DefaultProfile).Should(Equal("prod"))
+			Expect(config.Profiles).Should(HaveLen(2))
+			Expect(config.Profiles["prod"].LightwaveTenant).Should(Equal("example.com"))
+			Expect(config.Profiles["lab"].IgnoreCertificate).Should(BeTrue())
+		})
+
+		It("reads JSON", func() {
+			config, err := LoadProfileConfig(writeFile("config.json", testProfilesJson))
+			Expect(err).Should(BeNil())
+			Expect(config.Profiles["default"].AccessToken).Should(Equal("fake_access_token"))
+		})
+
+		It("fails on invalid files", func() {
+			_, err := LoadProfileConfig(writeFile("config.json", "profiles: {}"))
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Describe("GetProfile", func() {
+		var config *ProfileConfig
+
+		BeforeEach(func() {
+			var err error
+			config, err = LoadProfileConfig(writeFile("config.yaml", testProfilesYaml))
+			Expect(err).Should(BeNil())
+		})
+
+		It("uses the default profile", func() {
+			profile, err := config.GetProfile("")
+			Expect(err).Should(BeNil())
+			Expect(profile.Name).Should(Equal("prod"))
+			Expect(profile.Endpoint).Should(Equal("https://photon.example.com"))
+		})
+
+		It("uses PHOTON_PROFILE", func() {
+			os.Setenv(ProfileEnvVar, "lab")
+			profile, err := config.GetProfile("")
+			Expect(err).Should(BeNil())
+			Expect(profile.Endpoint).Should(Equal("https://lab.example.com"))
+		})
+
+		It("applies environment overrides without changing the config", func() {
+			os.Setenv(EndpointEnvVar, "https://other.example.com")
+			os.Setenv(TaskRetryCountEnvVar, "7")
+			profile, err := config.GetProfile("prod")
+			Expect(err).Should(BeNil())
+			Expect(profile.Endpoint).Should(Equal("https://other.example.com"))
+			Expect(profile.TaskRetryCount).Should(Equal(7))
+			Expect(config.Profiles["prod"].Endpoint).Should(Equal("https://photon.example.com"))
+		})
+
+		It("fails on invalid overrides", func() {
+			os.Setenv(IgnoreCertificateEnvVar, "maybe")
+			_, err := config.GetProfile("prod")
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		It("fails for unknown profiles", func() {
+			_, err := config.GetProfile("missing")
+			Expect(err).Should(MatchError("photon: Profile 'missing' not found"))
+		})
+	})
+
+	Describe("ClientOptions", func() {
+		It("maps the profile to client options", func() {
+			profile := &Profile{
+				TaskPollTimeout: "1h",
+				TaskPollDelay:   "2s",
+				TaskRetryCount:  5,
+				Username:        "joe",
+				Password:        "secret",
+			}
+			options, err := profile.ClientOptions()
+			Expect(err).Should(BeNil())
+			Expect(options.TaskPollTimeout).Should(Equal(time.Hour))
+			Expect(options.TaskPollDelay).Should(Equal(2 * time.Second))
+			Expect(options.TaskRetryCount).Should(Equal(5))
+
+			credentials, err := options.CredentialProvider.Retrieve()
+			Expect(err).Should(BeNil())
+			Expect(credentials).Should(Equal(&Credentials{Username: "joe", Password: "secret"}))
+		})
+
+		It("loads root CAs from PEM files", func() {
+			server := mocks.NewTlsTestServer()
+			defer server.Close()
+			certOut := new(bytes.Buffer)
+			err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: server.HttpServer.Certificate().Raw})
+			Expect(err).Should(BeNil())
+
+			profile := &Profile{CAFiles: []string{writeFile("ca.pem", certOut.String())}}
+			options, err := profile.ClientOptions()
+			Expect(err).Should(BeNil())
+			Expect(options.RootCAs.Subjects()).Should(HaveLen(1))
+			Expect(options.CredentialProvider).Should(BeNil())
+
+			profile.CAFiles = []string{writeFile("bad.pem", "not a certificate")}
+			_, err = profile.ClientOptions()
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		It("fails on invalid durations", func() {
+			_, err := (&Profile{TaskPollTimeout: "soon"}).ClientOptions()
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Describe("NewClientFromProfile", func() {
+		It("creates a client from PHOTON_CONFIG", func() {
+			os.Setenv(ConfigEnvVar, writeFile("config.json", testProfilesJson))
+			client, err := NewClientFromProfile("", "", nil)
+			Expect(err).Should(BeNil())
+			Expect(client.Endpoint).Should(Equal("https://photon.example.com"))
+			Expect(client.options.TokenOptions.AccessToken).Should(Equal("fake_access_token"))
+			Expect(client.options.TaskPollTimeout).Should(Equal(30 * time.Minute))
+		})
+
+		It("fails without an endpoint", func() {
+			path := writeFile("config.yaml", "profiles:\n  default:\n    task_retry_count: 1\n")
+			_, err := NewClientFromProfile(path, "", nil)
+			Expect(err).Should(MatchError("photon: No endpoint set in profile 'default'"))
+		})
+	})
+})
@@ -21,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type restClient struct {
@@ -28,6 +29,11 @@ type restClient struct {
 	logger                    *log.Logger
 	Auth                      *AuthAPI
 	UpdateAccessTokenCallback TokenCallback
+	TrustBootstrap            func() error
+	Login                     func(staleToken string) (string, error)
+
+	// Held while the Login hook changes the tokens, nil without the hook.
+	TokenLock sync.Locker
 }
 
 type request struct {
@@ -169,6 +175,32 @@ func (client *restClient) SendRequestCommon(method string, url string, contentTy
 }
 
 func (client *restClient) SendRequest(req *request, bodyRewinder bodyRewinder) (res *http.Response, err error) {
+	// Make sure the server certificates can be verified before anything is sent
+	if client.TrustBootstrap != nil {
+		err = client.TrustBootstrap()
+		if err != nil {
+			return
+		}
+	}
+
+	// Send a copy of the tokens. With a Login hook, the client's tokens are
+	// only changed by the hook, which holds TokenLock while it does.
+	shared := req.Tokens
+	if shared != nil {
+		tokens := client.copyTokens(shared)
+		req.Tokens = &tokens
+	}
+
+	// Log in before the first authenticated request if credentials are available
+	if client.Login != nil && req.Tokens != nil && req.Tokens.AccessToken == "" {
+		var accessToken string
+		accessToken, err = client.Login("")
+		if err != nil {
+			return
+		}
+		req.Tokens.AccessToken = accessToken
+	}
+
 	res, err = client.sendRequestHelper(req)
 	// In most cases, we'll return immediately
 	// If the operation succeeded, but we got a 401 response and if we're using
@@ -205,17 +237,31 @@ func (client *restClient) SendRequest(req *request, bodyRewinder bodyRewinder) (
 		return res, nil
 	}
 
-	// We were told that the access token expired, so try to renew it.
-	// Note that this looks recursive because GetTokensByRefreshToken() will
-	// call the /auth API, and therefore SendRequest(). However, it calls
-	// without a token, so we avoid having a loop
-	newTokens, err := client.Auth.GetTokensByRefreshToken(req.Tokens.RefreshToken)
-	if err != nil {
-		return res, err
-	}
-	req.Tokens.AccessToken = newTokens.AccessToken
-	if client.UpdateAccessTokenCallback != nil {
-		client.UpdateAccessTokenCallback(newTokens.AccessToken)
+	if client.Login != nil {
+		// The hook refreshes the token or logs in again, and stores the new
+		// tokens. It returns no token once the client is logged out.
+		accessToken, err := client.Login(req.Tokens.AccessToken)
+		if err != nil {
+			return res, err
+		}
+		if accessToken == "" {
+			return res, nil
+		}
+		req.Tokens.AccessToken = accessToken
+	} else {
+		// We were told that the access token expired, so try to renew it.
+		// Note that this looks recursive because GetTokensByRefreshToken() will
+		// call the /auth API, and therefore SendRequest(). However, it calls
+		// without a token, so we avoid having a loop
+		newTokens, err := client.Auth.GetTokensByRefreshToken(req.Tokens.RefreshToken)
+		if err != nil {
+			return res, err
+		}
+		shared.AccessToken = newTokens.AccessToken
+		req.Tokens.AccessToken = newTokens.AccessToken
+		if client.UpdateAccessTokenCallback != nil {
+			client.UpdateAccessTokenCallback(newTokens.AccessToken)
+		}
 	}
 	if req.Body != nil && bodyRewinder != nil {
 		req.Body = bodyRewinder()
@@ -224,6 +270,15 @@ func (client *restClient) SendRequest(req *request, bodyRewinder bodyRewinder) (
 	return res, err
 }
 
+// Returns a copy of the tokens, read under TokenLock if set.
+func (client *restClient) copyTokens(tokens *TokenOptions) TokenOptions {
+	if client.TokenLock != nil {
+		client.TokenLock.Lock()
+		defer client.TokenLock.Unlock()
+	}
+	return *tokens
+}
+
 func (client *restClient) sendRequestHelper(req *request) (res *http.Response, err error) {
 	r, err := http.NewRequest(req.Method, req.URL, req.Body)
 	if err != nil {
@@ -268,7 +323,9 @@ func (client *restClient) MultipartUpload(url string, reader io.ReadSeeker, file
 	return
 }
 
-func (client *restClient) createMultiReader(reader io.ReadSeeker, filename string, params map[string]string, boundary string) (io.Reader, string) {
+// Parts in trailers follow the file, e.g. fields that are only known once the
+// file has been read.
+func (client *restClient) createMultiReader(reader io.Reader, filename string, params map[string]string, boundary string, trailers ...io.Reader) (io.Reader, string) {
 	// The mime/multipart package does not support streaming multipart data from disk,
 	// at least not without complicated, problematic goroutines that simultaneously read/write into a buffer.
 	// A much easier approach is to just construct the multipart request by hand, using io.MultiPart to
@@ -287,7 +344,9 @@ func (client *restClient) createMultiReader(reader io.ReadSeeker, filename strin
 
 	// The request will consist of a reader to begin the request, a reader which points
 	// to the file data on disk, and a reader containing the closing boundary of the request.
-	parts = append(parts, strings.NewReader(start), reader, strings.NewReader(end))
+	parts = append(parts, strings.NewReader(start), reader)
+	parts = append(parts, trailers...)
+	parts = append(parts, strings.NewReader(end))
 
 	contentType := fmt.Sprintf("multipart/form-data; boundary=%s", boundary)
 
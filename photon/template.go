@@ -0,0 +1,413 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of an image, as in Image.State.
+const (
+	ImageStateCreating      string = "CREATING"
+	ImageStateReady         string = "READY"
+	ImageStateError         string = "ERROR"
+	ImageStatePendingDelete string = "PENDING_DELETE"
+)
+
+// Replication types of an image, as in Image.ReplicationType.
+const (
+	ImageReplicationEager    string = "EAGER"
+	ImageReplicationOnDemand string = "ON_DEMAND"
+)
+
+// Kind of the disks created along with a VM.
+const EphemeralDiskKind string = "ephemeral-disk"
+
+const defaultCloneConcurrency int = 4
+
+// How to capture a template from a VM.
+type TemplateCaptureSpec struct {
+	// Name of the image.
+	Name string
+
+	// ImageReplicationEager or ImageReplicationOnDemand, on demand if not set.
+	// The template is not ready before an eager image is replicated.
+	ReplicationType string
+
+	// Tags to set on the image, e.g. to tell templates apart from other images.
+	Tags []string
+
+	// How long the guest OS of a running VM gets to shut down before the VM
+	// is powered off, 5 minutes if not set.
+	StopTimeout time.Duration
+
+	// How long to wait for the image to be ready, ClientOptions.TaskPollTimeout
+	// if not set. CaptureTemplate returns an ImageWaitTimeoutError after it.
+	ImageTimeout time.Duration
+
+	// Start the VM again after capturing it, if it was running before.
+	RestartSource bool
+}
+
+// An image captured from a VM, along with what clones need to look like it.
+type VmTemplate struct {
+	Image *Image
+
+	// ID of the VM the image was captured from.
+	SourceVmID string
+	Flavor     string
+
+	// Ephemeral disks of the source VM, including the boot disk.
+	AttachedDisks []AttachedDisk
+}
+
+// How to create clones from a template.
+type CloneSpec struct {
+	// Name of the clones, a format with one verb for the clone number which
+	// counts from 1, e.g. "web-%02d".
+	NamePattern string
+	Count       int
+
+	// Flavor of the clones, the template's if not set.
+	Flavor string
+
+	Subnets    []string
+	Affinities []LocalitySpec
+	Tags       []string
+
+	// Environment and metadata of all clones.
+	Environment map[string]string
+	Metadata    map[string]string
+
+	// Settings of single clones, by clone number minus one. They are merged
+	// over the settings of all clones.
+	Clones []CloneSettings
+
+	// Start the clones once created.
+	Start bool
+
+	// How many clones are created at a time, 4 if not set.
+	Concurrency int
+}
+
+// Settings of one clone.
+type CloneSettings struct {
+	Environment map[string]string
+	Metadata    map[string]string
+}
+
+// Returned by CaptureTemplate when the image does not become ready.
+type ImageNotReadyError struct {
+	ID    string
+	State string
+}
+
+// Implement Go error interface for ImageNotReadyError.
+func (e ImageNotReadyError) Error() string {
+	return fmt.Sprintf("photon: Image '%s' did not become ready, state %s", e.ID, e.State)
+}
+
+// Returned by CreateClones when clones fail. The clones created before are
+// deleted again; those that could not be deleted are in Leftovers.
+type CloneError struct {
+	// Why clones failed, by name.
+	Errors map[string]error
+
+	// Why VMs could not be deleted, by ID.
+	Leftovers map[string]error
+}
+
+// Implement Go error interface for CloneError.
+func (e CloneError) Error() string {
+	names := []string{}
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failures := []string{}
+	for _, name := range names {
+		failures = append(failures, fmt.Sprintf("%s: %s", name, e.Errors[name]))
+	}
+	msg := fmt.Sprintf("photon: Failed to create clones (%s)", strings.Join(failures, "; "))
+	if len(e.Leftovers) > 0 {
+		msg += fmt.Sprintf(", %d VMs could not be deleted", len(e.Leftovers))
+	}
+	return msg
+}
+
+// Captures a template from a VM: stops the VM, shutting its guest OS down,
+// creates an image from it and waits for the image to be ready, as
+// ImagesAPI.WaitForReady does. If the image fails it is deleted again.
+func (api *VmAPI) CaptureTemplate(id string, spec *TemplateCaptureSpec) (template *VmTemplate, err error) {
+	if spec.Name == "" {
+		return nil, errors.New("photon: Template needs an image name")
+	}
+	vm, err := api.Get(id)
+	if err != nil {
+		return
+	}
+
+	wasRunning := vm.State == VmStateStarted || vm.State == VmStateSuspended
+	if wasRunning {
+		stopTimeout := spec.StopTimeout
+		if stopTimeout == 0 {
+			stopTimeout = 5 * time.Minute
+		}
+		_, err = api.GracefulStop(id, stopTimeout)
+		if err != nil {
+			return
+		}
+		if spec.RestartSource {
+			defer func() {
+				if _, startErr := api.EnsurePowerState(id, VmStateStarted); startErr != nil && err == nil {
+					err = startErr
+				}
+			}()
+		}
+	}
+
+	image, err := api.captureImage(id, spec)
+	if err != nil {
+		return
+	}
+
+	template = &VmTemplate{Image: image, SourceVmID: id, Flavor: vm.Flavor}
+	for _, disk := range vm.AttachedDisks {
+		if disk.Kind == EphemeralDiskKind {
+			template.AttachedDisks = append(template.AttachedDisks, disk)
+		}
+	}
+	return
+}
+
+func (api *VmAPI) captureImage(id string, spec *TemplateCaptureSpec) (image *Image, err error) {
+	replicationType := spec.ReplicationType
+	if replicationType == "" {
+		replicationType = ImageReplicationOnDemand
+	}
+	task, err := api.CreateImage(id, &ImageCreateSpec{
+		Name:            spec.Name,
+		ReplicationType: replicationType,
+		Tags:            spec.Tags,
+	})
+	if err != nil {
+		return
+	}
+	task, err = api.client.Tasks.Wait(task.ID)
+	if err != nil {
+		return
+	}
+
+	imageID := task.Entity.ID
+	image, err = api.client.Images.WaitForReady(imageID, &ImageWaitOptions{Timeout: spec.ImageTimeout})
+	if err != nil {
+		if task, deleteErr := api.client.Images.Delete(imageID); deleteErr == nil {
+			api.client.Tasks.Wait(task.ID)
+		}
+		return nil, err
+	}
+	return
+}
+
+// Creates clones of a template in a project, waiting for each clone, and
+// returns them in clone number order. If any clone fails, no more clones are
+// started and those already created are deleted again.
+func (api *ProjectsAPI) CreateClones(projectID string, template *VmTemplate, spec *CloneSpec) (vms []*VM, err error) {
+	names, err := cloneNames(spec)
+	if err != nil {
+		return
+	}
+	concurrency := defaultCloneConcurrency
+	if spec.Concurrency > 0 {
+		concurrency = spec.Concurrency
+	}
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		created []string
+		failed  = map[string]error{}
+	)
+	// Workers take the clones in number order, so none is started after one failed.
+	indexes := make(chan int, len(names))
+	for index := range names {
+		indexes <- index
+	}
+	close(indexes)
+	vms = make([]*VM, len(names))
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				lock.Lock()
+				stop := len(failed) > 0
+				lock.Unlock()
+				if stop {
+					return
+				}
+
+				vm, err := api.createClone(projectID, template, spec, index, names[index], func(id string) {
+					lock.Lock()
+					created = append(created, id)
+					lock.Unlock()
+				})
+				lock.Lock()
+				if err != nil {
+					failed[names[index]] = err
+				} else {
+					vms[index] = vm
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) == 0 {
+		return
+	}
+	cloneErr := CloneError{Errors: failed, Leftovers: map[string]error{}}
+	for _, id := range created {
+		if deleteErr := api.deleteClone(id); deleteErr != nil {
+			cloneErr.Leftovers[id] = deleteErr
+		}
+	}
+	return nil, cloneErr
+}
+
+func cloneNames(spec *CloneSpec) (names []string, err error) {
+	if spec.Count <= 0 {
+		return nil, errors.New("photon: Clone count must be positive")
+	}
+	if len(spec.Clones) > spec.Count {
+		return nil, fmt.Errorf("photon: Settings for %d clones given, but only %d clones asked for", len(spec.Clones), spec.Count)
+	}
+	seen := map[string]bool{}
+	for number := 1; number <= spec.Count; number++ {
+		name := fmt.Sprintf(spec.NamePattern, number)
+		if strings.Contains(name, "%!") || seen[name] {
+			return nil, fmt.Errorf("photon: Clone name pattern '%s' does not give unique names", spec.NamePattern)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return
+}
+
+// Creates one clone, calling created with the ID of the VM as soon as it exists.
+func (api *ProjectsAPI) createClone(projectID string, template *VmTemplate, spec *CloneSpec, index int, name string,
+	created func(string)) (vm *VM, err error) {
+
+	var settings CloneSettings
+	if index < len(spec.Clones) {
+		settings = spec.Clones[index]
+	}
+	flavor := spec.Flavor
+	if flavor == "" {
+		flavor = template.Flavor
+	}
+
+	disks := []AttachedDisk{}
+	for _, disk := range template.AttachedDisks {
+		disks = append(disks, AttachedDisk{
+			Name:       disk.Name,
+			Kind:       disk.Kind,
+			Flavor:     disk.Flavor,
+			CapacityGB: disk.CapacityGB,
+			BootDisk:   disk.BootDisk,
+		})
+	}
+
+	task, err := api.CreateVM(projectID, &VmCreateSpec{
+		Name:          name,
+		Flavor:        flavor,
+		SourceImageID: template.Image.ID,
+		AttachedDisks: disks,
+		Affinities:    spec.Affinities,
+		Tags:          spec.Tags,
+		Subnets:       spec.Subnets,
+		Environment:   mergeSettings(spec.Environment, settings.Environment),
+	})
+	if err != nil {
+		return
+	}
+	id := task.Entity.ID
+	task, err = api.client.Tasks.Wait(task.ID)
+	if task != nil && task.Entity.ID != "" {
+		id = task.Entity.ID
+	}
+	// A failed create can leave a VM behind, which is deleted along with the
+	// other clones.
+	if id != "" {
+		created(id)
+	}
+	if err != nil {
+		return
+	}
+
+	metadata := mergeSettings(spec.Metadata, settings.Metadata)
+	if len(metadata) > 0 {
+		task, err = api.client.VMs.SetMetadata(id, &VmMetadata{Metadata: metadata})
+		if err != nil {
+			return
+		}
+		_, err = api.client.Tasks.Wait(task.ID)
+		if err != nil {
+			return
+		}
+	}
+
+	if spec.Start {
+		return api.client.VMs.EnsurePowerState(id, VmStateStarted)
+	}
+	return api.client.VMs.Get(id)
+}
+
+func (api *ProjectsAPI) deleteClone(id string) (err error) {
+	vm, err := api.client.VMs.Get(id)
+	if err != nil {
+		return
+	}
+	// Only running VMs need stopping; one that failed to create is in ERROR.
+	if vm.State == VmStateStarted || vm.State == VmStateSuspended {
+		_, err = api.client.VMs.EnsurePowerState(id, VmStateStopped)
+		if err != nil {
+			return
+		}
+	}
+	task, err := api.client.VMs.Delete(id)
+	if err != nil {
+		return
+	}
+	_, err = api.client.Tasks.Wait(task.ID)
+	return
+}
+
+// Returns the settings of all clones with those of one clone on top.
+func mergeSettings(all, clone map[string]string) map[string]string {
+	if len(all) == 0 && len(clone) == 0 {
+		return nil
+	}
+	merged := map[string]string{}
+	for key, value := range all {
+		merged[key] = value
+	}
+	for key, value := range clone {
+		merged[key] = value
+	}
+	return merged
+}
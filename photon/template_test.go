@@ -0,0 +1,358 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+// Counts the requests in flight that create VMs, holding each for a moment.
+type creatingTransport struct {
+	lock        sync.Mutex
+	creating    int
+	maxCreating int
+}
+
+func (t *creatingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != "POST" || !strings.HasSuffix(r.URL.Path, "/vms") {
+		return http.DefaultTransport.RoundTrip(r)
+	}
+	t.lock.Lock()
+	t.creating++
+	if t.creating > t.maxCreating {
+		t.maxCreating = t.creating
+	}
+	t.lock.Unlock()
+	defer func() {
+		t.lock.Lock()
+		t.creating--
+		t.lock.Unlock()
+	}()
+	time.Sleep(5 * time.Millisecond)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+var _ = Describe("Template", func() {
+	var (
+		server *mocks.Server
+		client *Client
+	)
+
+	// A queued task for an entity; tasks without a response of their own
+	// read as completed.
+	queued := func(id string, entityID string) *Task {
+		return &Task{ID: id, State: "QUEUED", Entity: Entity{ID: entityID}}
+	}
+	vm := func(id string, name string, state string) *VM {
+		return &VM{ID: id, Name: name, State: state}
+	}
+
+	// Method and path of the requests other than task polls.
+	calls := func() (calls []string) {
+		for _, r := range server.Requests() {
+			if !strings.HasPrefix(r.Path, rootUrl+"/tasks/") {
+				calls = append(calls, r.Method+" "+strings.TrimPrefix(r.Path, rootUrl))
+			}
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping template test on integration mode.")
+		}
+
+		server, client = mockServerClient()
+		server.SetResponseJson(200, &Task{State: "COMPLETED"})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("CaptureTemplate", func() {
+		var source *VM
+
+		BeforeEach(func() {
+			source = &VM{
+				ID:     "src",
+				Name:   "golden",
+				State:  VmStateStarted,
+				Flavor: "vm-small",
+				AttachedDisks: []AttachedDisk{
+					{ID: "d1", Name: "boot", Kind: EphemeralDiskKind, Flavor: "disk-ssd", CapacityGB: 20, BootDisk: true, State: "ATTACHED"},
+					{ID: "d2", Name: "data", Kind: "persistent-disk", Flavor: "disk-ssd", CapacityGB: 100, State: "ATTACHED"},
+				},
+			}
+			server.SetResponseJsonForPath(rootUrl+"/vms/src/operations", 200, queued("stop-task", "src"))
+			server.SetResponseJsonForPath(rootUrl+"/vms/src/create_image", 200, queued("image-task", "src"))
+			server.SetResponseJsonForPath(rootUrl+"/vms/src/start", 200, queued("start-task", "src"))
+			server.SetResponseJsonForMethodPath("DELETE", rootUrl+"/images/img1", 200, queued("delete-task", "img1"))
+			server.SetResponseJsonForPath(rootUrl+"/tasks/image-task", 200,
+				&Task{ID: "image-task", State: "COMPLETED", Entity: Entity{ID: "img1", Kind: EntityKindImage}})
+		})
+
+		// The source VM as read by each request, the last one repeated.
+		sourceStates := func(states ...string) {
+			vms := []interface{}{}
+			for _, state := range states {
+				vm := *source
+				vm.State = state
+				vms = append(vms, &vm)
+			}
+			server.SetResponsesJsonForPath(rootUrl+"/vms/src", 200, vms...)
+		}
+
+		It("stops the VM, captures the image and restarts the VM", func() {
+			sourceStates(VmStateStarted, VmStateStarted, VmStateStopped, VmStateStopped, VmStateStarted)
+			server.SetResponsesJsonForPath(rootUrl+"/images/img1", 200,
+				&Image{ID: "img1", State: ImageStateCreating},
+				&Image{ID: "img1", State: ImageStateReady, ReplicationType: ImageReplicationOnDemand})
+
+			template, err := client.VMs.CaptureTemplate("src", &TemplateCaptureSpec{
+				Name:          "golden-1",
+				Tags:          []string{"template:web"},
+				RestartSource: true,
+			})
+			Expect(err).Should(BeNil())
+			Expect(template.Image.ID).Should(Equal("img1"))
+			Expect(template.Image.State).Should(Equal(ImageStateReady))
+			Expect(template.SourceVmID).Should(Equal("src"))
+			Expect(template.Flavor).Should(Equal("vm-small"))
+			Expect(template.AttachedDisks).Should(HaveLen(1))
+			Expect(template.AttachedDisks[0].Name).Should(Equal("boot"))
+
+			Expect(calls()).Should(Equal([]string{
+				"GET /vms/src",
+				"GET /vms/src",
+				"POST /vms/src/operations",
+				"GET /vms/src",
+				"POST /vms/src/create_image",
+				"GET /images/img1",
+				"GET /images/img1",
+				"GET /vms/src",
+				"POST /vms/src/start",
+				"GET /vms/src",
+			}))
+
+			var imageSpec ImageCreateSpec
+			err = json.Unmarshal([]byte(server.RequestsFor("POST", rootUrl+"/vms/src/create_image")[0].Body), &imageSpec)
+			Expect(err).Should(BeNil())
+			Expect(imageSpec).Should(Equal(ImageCreateSpec{
+				Name:            "golden-1",
+				ReplicationType: ImageReplicationOnDemand,
+				Tags:            []string{"template:web"},
+			}))
+		})
+
+		It("waits for an eager image to be replicated", func() {
+			sourceStates(VmStateStarted, VmStateStarted, VmStateStopped)
+			eager := func(progress string) *Image {
+				return &Image{ID: "img1", State: ImageStateReady, ReplicationType: ImageReplicationEager, ReplicationProgress: progress}
+			}
+			server.SetResponsesJsonForPath(rootUrl+"/images/img1", 200, eager("0%"), eager("50%"), eager("100%"))
+
+			template, err := client.VMs.CaptureTemplate("src", &TemplateCaptureSpec{
+				Name:            "golden-1",
+				ReplicationType: ImageReplicationEager,
+			})
+			Expect(err).Should(BeNil())
+			Expect(template.Image.ReplicationProgress).Should(Equal("100%"))
+			Expect(server.RequestsFor("GET", rootUrl+"/images/img1")).Should(HaveLen(3))
+			Expect(server.RequestsFor("POST", rootUrl+"/vms/src/start")).Should(BeEmpty())
+		})
+
+		It("does not wait for an on demand image to be replicated", func() {
+			sourceStates(VmStateStarted, VmStateStarted, VmStateStopped)
+			server.SetResponsesJsonForPath(rootUrl+"/images/img1", 200,
+				&Image{ID: "img1", State: ImageStateCreating},
+				&Image{ID: "img1", State: ImageStateReady, ReplicationType: ImageReplicationOnDemand, ReplicationProgress: "0%"},
+				&Image{ID: "img1", State: ImageStateReady, ReplicationType: ImageReplicationOnDemand, ReplicationProgress: "100%"})
+
+			template, err := client.VMs.CaptureTemplate("src", &TemplateCaptureSpec{Name: "golden-1"})
+			Expect(err).Should(BeNil())
+			Expect(template.Image.ReplicationProgress).Should(Equal("0%"))
+			Expect(server.RequestsFor("GET", rootUrl+"/images/img1")).Should(HaveLen(2))
+			Expect(calls()).ShouldNot(ContainElement("DELETE /images/img1"))
+		})
+
+		It("deletes an image that is not ready in time", func() {
+			sourceStates(VmStateStarted, VmStateStarted, VmStateStopped)
+			server.SetResponseJsonForMethodPath("GET", rootUrl+"/images/img1", 200,
+				&Image{ID: "img1", State: ImageStateReady, ReplicationType: ImageReplicationEager, ReplicationProgress: "50%"})
+
+			_, err := client.VMs.CaptureTemplate("src", &TemplateCaptureSpec{
+				Name:            "golden-1",
+				ReplicationType: ImageReplicationEager,
+				ImageTimeout:    20 * time.Millisecond,
+			})
+			Expect(err).Should(BeAssignableToTypeOf(ImageWaitTimeoutError{}))
+			Expect(err.(ImageWaitTimeoutError).Progress.Replication).Should(Equal(50.0))
+			Expect(calls()).Should(ContainElement("DELETE /images/img1"))
+		})
+
+		It("deletes a failed image and still restarts the VM", func() {
+			sourceStates(VmStateStarted, VmStateStarted, VmStateStopped, VmStateStopped, VmStateStarted)
+			server.SetResponsesJsonForPath(rootUrl+"/images/img1", 200,
+				&Image{ID: "img1", State: ImageStateCreating},
+				&Image{ID: "img1", State: ImageStateError})
+
+			_, err := client.VMs.CaptureTemplate("src", &TemplateCaptureSpec{Name: "golden-1", RestartSource: true})
+			Expect(err).Should(Equal(ImageNotReadyError{"img1", ImageStateError}))
+			Expect(calls()).Should(ContainElement("DELETE /images/img1"))
+			Expect(calls()[len(calls())-2:]).Should(Equal([]string{"POST /vms/src/start", "GET /vms/src"}))
+		})
+	})
+
+	Describe("CreateClones", func() {
+		var template *VmTemplate
+
+		BeforeEach(func() {
+			template = &VmTemplate{
+				Image:      &Image{ID: "img1"},
+				SourceVmID: "src",
+				Flavor:     "vm-small",
+				AttachedDisks: []AttachedDisk{
+					{ID: "d1", Name: "boot", Kind: EphemeralDiskKind, Flavor: "disk-ssd", BootDisk: true, State: "ATTACHED"},
+				},
+			}
+			server.SetResponsesJsonForPath(rootUrl+"/projects/p1/vms", 200,
+				queued("create-1", "vm-1"), queued("create-2", "vm-2"), queued("create-3", "vm-3"))
+			for number := 1; number <= 3; number++ {
+				id := fmt.Sprintf("vm-%d", number)
+				for _, op := range []string{"start", "stop", "set_metadata"} {
+					server.SetResponseJsonForPath(rootUrl+"/vms/"+id+"/"+op, 200, queued(op+"-task", id))
+				}
+				server.SetResponseJsonForMethodPath("DELETE", rootUrl+"/vms/"+id, 200, queued("delete-task", id))
+			}
+		})
+
+		// Decodes the create specs sent, in the order they were sent.
+		createSpecs := func() (specs []VmCreateSpec) {
+			for _, r := range server.RequestsFor("POST", rootUrl+"/projects/p1/vms") {
+				var spec VmCreateSpec
+				Expect(json.Unmarshal([]byte(r.Body), &spec)).Should(Succeed())
+				specs = append(specs, spec)
+			}
+			return
+		}
+
+		It("creates clones with their own names, environment and metadata", func() {
+			for number := 1; number <= 3; number++ {
+				id, name := fmt.Sprintf("vm-%d", number), fmt.Sprintf("web-%02d", number)
+				server.SetResponsesJsonForPath(rootUrl+"/vms/"+id, 200,
+					vm(id, name, VmStateStopped), vm(id, name, VmStateStarted))
+			}
+
+			vms, err := client.Projects.CreateClones("p1", template, &CloneSpec{
+				NamePattern: "web-%02d",
+				Count:       3,
+				Concurrency: 1,
+				Tags:        []string{"tier:web"},
+				Environment: map[string]string{"ROLE": "web", "PRIMARY": "false"},
+				Metadata:    map[string]string{"owner": "ops"},
+				Clones:      []CloneSettings{{Environment: map[string]string{"PRIMARY": "true"}}},
+				Start:       true,
+			})
+			Expect(err).Should(BeNil())
+			Expect(vms).Should(HaveLen(3))
+
+			specs := createSpecs()
+			Expect(specs).Should(HaveLen(3))
+			for i, vm := range vms {
+				name := fmt.Sprintf("web-%02d", i+1)
+				Expect(vm.Name).Should(Equal(name))
+				Expect(vm.State).Should(Equal(VmStateStarted))
+
+				spec := specs[i]
+				Expect(spec.Name).Should(Equal(name))
+				Expect(spec.Flavor).Should(Equal("vm-small"))
+				Expect(spec.SourceImageID).Should(Equal("img1"))
+				Expect(spec.Tags).Should(Equal([]string{"tier:web"}))
+				Expect(spec.AttachedDisks).Should(Equal([]AttachedDisk{
+					{Name: "boot", Kind: EphemeralDiskKind, Flavor: "disk-ssd", BootDisk: true}}))
+
+				metadata := server.RequestsFor("POST", rootUrl+"/vms/"+vm.ID+"/set_metadata")
+				Expect(metadata).Should(HaveLen(1))
+				Expect(metadata[0].Body).Should(MatchJSON(`{"metadata": {"owner": "ops"}}`))
+			}
+			Expect(specs[0].Environment).Should(Equal(map[string]string{"ROLE": "web", "PRIMARY": "true"}))
+			Expect(specs[1].Environment).Should(Equal(map[string]string{"ROLE": "web", "PRIMARY": "false"}))
+		})
+
+		It("creates no more clones at a time than the concurrency allows", func() {
+			transport := &creatingTransport{}
+			client = NewTestClient(server.HttpServer.URL, &ClientOptions{TaskPollDelay: time.Millisecond},
+				&http.Client{Transport: transport})
+			server.SetResponseJsonForPath(rootUrl+"/projects/p1/vms", 200, queued("create-task", ""))
+			server.SetResponseJson(200, &Task{State: "COMPLETED", Entity: Entity{ID: "vm-1"}})
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm-1", 200, vm("vm-1", "web", VmStateStopped))
+
+			vms, err := client.Projects.CreateClones("p1", template, &CloneSpec{
+				NamePattern: "web-%d",
+				Count:       8,
+				Concurrency: 2,
+			})
+			Expect(err).Should(BeNil())
+			Expect(vms).Should(HaveLen(8))
+			Expect(createSpecs()).Should(HaveLen(8))
+			Expect(transport.maxCreating).Should(Equal(2))
+		})
+
+		It("deletes the clones when one fails", func() {
+			server.SetResponsesJsonForPath(rootUrl+"/vms/vm-1", 200,
+				vm("vm-1", "web-1", VmStateStopped),
+				vm("vm-1", "web-1", VmStateStarted),
+				vm("vm-1", "web-1", VmStateStarted),
+				vm("vm-1", "web-1", VmStateStarted),
+				vm("vm-1", "web-1", VmStateStopped))
+			server.SetResponseJsonForPath(rootUrl+"/vms/vm-2", 200, vm("vm-2", "web-2", VmStateError))
+			server.SetResponseJsonForPath(rootUrl+"/tasks/create-2", 200,
+				&Task{ID: "create-2", State: "ERROR", Entity: Entity{ID: "vm-2"}})
+
+			vms, err := client.Projects.CreateClones("p1", template, &CloneSpec{
+				NamePattern: "web-%d",
+				Count:       3,
+				Concurrency: 1,
+				Start:       true,
+			})
+			Expect(vms).Should(BeNil())
+			cloneErr, ok := err.(CloneError)
+			Expect(ok).Should(BeTrue())
+			Expect(cloneErr.Errors).Should(HaveKey("web-2"))
+			Expect(cloneErr.Errors["web-2"]).Should(BeAssignableToTypeOf(TaskError{}))
+			Expect(cloneErr.Leftovers).Should(BeEmpty())
+
+			// The third clone is never created, the running one is stopped
+			// and both others are deleted.
+			Expect(createSpecs()).Should(HaveLen(2))
+			Expect(server.RequestsFor("POST", rootUrl+"/vms/vm-1/stop")).Should(HaveLen(1))
+			Expect(server.RequestsFor("DELETE", rootUrl+"/vms/vm-1")).Should(HaveLen(1))
+			Expect(server.RequestsFor("DELETE", rootUrl+"/vms/vm-2")).Should(HaveLen(1))
+		})
+
+		It("rejects name patterns that do not give unique names", func() {
+			_, err := client.Projects.CreateClones("p1", template, &CloneSpec{NamePattern: "web", Count: 2})
+			Expect(err).ShouldNot(BeNil())
+			_, err = client.Projects.CreateClones("p1", template, &CloneSpec{NamePattern: "web-%d", Count: 0})
+			Expect(err).ShouldNot(BeNil())
+			Expect(server.Requests()).Should(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,90 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
+)
+
+// State of the trust bootstrap requested through ClientOptions.TrustOptions.
+type trustBootstrap struct {
+	lock sync.Mutex
+	done bool
+}
+
+// Downloads the auth server root certificates, verifies them against
+// ClientOptions.TrustOptions and switches the client over to verifying
+// all connections with them. Only the first successful call does any work;
+// after a failure the next API call tries again.
+func (c *Client) bootstrapTrust() (err error) {
+	c.trust.lock.Lock()
+	defer c.trust.lock.Unlock()
+
+	if c.trust.done {
+		return
+	}
+
+	// Nothing is trusted yet, so the auth info and the certificates are fetched
+	// without verification. The auth info is not sensitive, and the certificates
+	// are only used once their fingerprints have been verified.
+	bootstrapOptions := &ClientOptions{IgnoreCertificate: true, AuthEndpoint: c.options.AuthEndpoint}
+	bootstrapClient := NewClient(c.Endpoint, bootstrapOptions, c.logger)
+	authEndpoint, err := bootstrapClient.Auth.getAuthEndpoint()
+	if err != nil {
+		return
+	}
+
+	oidcClient := lightwave.NewOIDCClient(authEndpoint, nil, c.logger)
+	certList, err := oidcClient.GetTrustedRootCerts(c.options.TrustOptions)
+	if err != nil {
+		return
+	}
+
+	// Keep the transport of the client, with its proxy, timeouts and the
+	// certificates it already trusts, and only add the verified ones.
+	transport, ok := c.restClient.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("photon: Cannot bootstrap trust, the client transport is not an *http.Transport")
+	}
+	config := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	}
+	pool := config.RootCAs
+	if pool == nil {
+		pool, err = x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		err = nil
+	}
+	for _, cert := range certList {
+		pool.AddCert(cert)
+	}
+	config.RootCAs = pool
+	config.InsecureSkipVerify = false
+
+	c.options.RootCAs = pool
+	c.options.IgnoreCertificate = false
+	transport.TLSClientConfig = config
+	transport.CloseIdleConnections()
+
+	// The cached OIDC client was built with the old TLS settings.
+	c.Auth.InvalidateCache()
+
+	c.trust.done = true
+	return
+}
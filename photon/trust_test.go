@@ -0,0 +1,161 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+	"github.com/vmware/photon-controller-go-sdk/photon/lightwave"
+)
+
+var _ = Describe("TrustBootstrap", func() {
+	var (
+		server      *mocks.Server
+		fingerprint string
+	)
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping trust bootstrap test on integration mode.")
+		}
+
+		// The same server plays both photon and the auth server.
+		server = mocks.NewTlsTestServer()
+		cert := server.HttpServer.Certificate()
+		fingerprint = lightwave.CertFingerprint(cert)
+
+		certOut := new(bytes.Buffer)
+		err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		Expect(err).Should(BeNil())
+
+		server.SetResponseJsonForPath(rootUrl+"/system/auth", 200, createMockAuthInfo(server))
+		server.SetResponseJsonForPath("/afd/vecs/ssl", 200, []map[string]string{{"encoded": certOut.String()}})
+		server.SetResponseJson(200, &Status{Status: "READY"})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("fails to connect without a trust bootstrap", func() {
+		client := NewClient(server.HttpServer.URL, nil, nil)
+		_, err := client.System.GetSystemStatus()
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("verifies connections after bootstrapping trust", func() {
+		options := &ClientOptions{
+			TrustOptions: &lightwave.CertTrustOptions{Fingerprints: []string{fingerprint}},
+		}
+		client := NewClient(server.HttpServer.URL, options, nil)
+
+		status, err := client.System.GetSystemStatus()
+		Expect(err).Should(BeNil())
+		Expect(status.Status).Should(Equal("READY"))
+		Expect(client.options.RootCAs).ShouldNot(BeNil())
+		Expect(client.options.IgnoreCertificate).Should(BeFalse())
+
+		expected := &TokenOptions{
+			AccessToken: "fake_access_token",
+			ExpiresIn:   36000,
+			IdToken:     "fake_id_token",
+			TokenType:   "Bearer",
+		}
+		server.SetResponseJsonForPath("/openidconnect/token", 200, expected)
+		tokens, err := client.Auth.GetTokensByPassword("username", "password")
+		Expect(err).Should(BeNil())
+		Expect(tokens).Should(BeEquivalentTo(expected))
+	})
+
+	It("bootstraps trust before the first token request to the auth endpoint", func() {
+		options := &ClientOptions{
+			AuthEndpoint: server.HttpServer.URL,
+			TrustOptions: &lightwave.CertTrustOptions{Fingerprints: []string{fingerprint}},
+		}
+		client := NewClient(server.HttpServer.URL, options, nil)
+
+		expected := &TokenOptions{AccessToken: "fake_access_token", TokenType: "Bearer"}
+		server.SetResponseJsonForPath("/openidconnect/token", 200, expected)
+		tokens, err := client.Auth.GetTokensByPassword("username", "password")
+		Expect(err).Should(BeNil())
+		Expect(tokens).Should(BeEquivalentTo(expected))
+		Expect(server.RequestsFor("GET", "/afd/vecs/ssl")).Should(HaveLen(1))
+		Expect(server.RequestsFor("POST", "/openidconnect/token")).Should(HaveLen(1))
+	})
+
+	It("keeps the transport and the certificates it already trusts", func() {
+		other := mocks.NewTlsTestServer()
+		defer other.Close()
+		other.SetResponseJson(200, &Status{Status: "READY"})
+
+		pool := x509.NewCertPool()
+		pool.AddCert(other.HttpServer.Certificate())
+		transport := &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+		options := &ClientOptions{
+			TrustOptions: &lightwave.CertTrustOptions{Fingerprints: []string{fingerprint}},
+		}
+		client := NewTestClient(server.HttpServer.URL, options, &http.Client{Transport: transport})
+
+		_, err := client.System.GetSystemStatus()
+		Expect(err).Should(BeNil())
+		Expect(client.restClient.httpClient.Transport).Should(BeIdenticalTo(transport))
+		Expect(transport.Proxy).ShouldNot(BeNil())
+
+		otherClient := NewTestClient(other.HttpServer.URL, nil, &http.Client{Transport: transport})
+		_, err = otherClient.System.GetSystemStatus()
+		Expect(err).Should(BeNil())
+	})
+
+	It("fails with a transport it cannot configure", func() {
+		options := &ClientOptions{
+			TrustOptions: &lightwave.CertTrustOptions{Fingerprints: []string{fingerprint}},
+		}
+		client := NewTestClient(server.HttpServer.URL, options, &http.Client{Transport: http.RoundTripper(nil)})
+
+		_, err := client.System.GetSystemStatus()
+		Expect(err).ShouldNot(BeNil())
+		Expect(err.Error()).Should(ContainSubstring("transport"))
+	})
+
+	It("ignores IgnoreCertificate", func() {
+		options := &ClientOptions{
+			IgnoreCertificate: true,
+			TrustOptions:      &lightwave.CertTrustOptions{Fingerprints: []string{fingerprint}},
+		}
+		client := NewClient(server.HttpServer.URL, options, nil)
+		Expect(client.options.IgnoreCertificate).Should(BeFalse())
+	})
+
+	It("fails when the fingerprint does not match", func() {
+		options := &ClientOptions{
+			TrustOptions: &lightwave.CertTrustOptions{Fingerprints: []string{"00:11:22"}},
+		}
+		client := NewClient(server.HttpServer.URL, options, nil)
+
+		_, err := client.System.GetSystemStatus()
+		_, ok := err.(lightwave.CertTrustError)
+		Expect(ok).Should(BeTrue())
+
+		// A failed bootstrap must not leave the client trusting anything.
+		_, err = client.System.GetSystemStatus()
+		_, ok = err.(lightwave.CertTrustError)
+		Expect(ok).Should(BeTrue())
+	})
+})
@@ -0,0 +1,215 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Every poll of WaitForNetwork starts a GET_NETWORKS task, so it polls slowly,
+// backing off from the first interval to the longest.
+const (
+	defaultNetworkPollInterval    time.Duration = 2 * time.Second
+	defaultNetworkMaxPollInterval time.Duration = 30 * time.Second
+)
+
+// Network connections of a VM, the resource properties of the GET_NETWORKS task.
+type VmNetworks struct {
+	NetworkConnections []VmNetworkConnection `json:"networkConnections"`
+}
+
+// One network interface of a VM.
+type VmNetworkConnection struct {
+	// ID of the subnet or network the interface is on.
+	Network     string `json:"network"`
+	MacAddress  string `json:"macAddress"`
+	IpAddress   string `json:"ipAddress"`
+	Netmask     string `json:"netmask"`
+	IsConnected string `json:"isConnected"`
+}
+
+// Options for WaitForNetwork.
+type VmNetworkWaitOptions struct {
+	// ID of the subnet or network to wait for an IP address on. If empty, an IP
+	// address on any network will do.
+	Network string
+
+	// Also wait for VM.FloatingIp to be set.
+	WaitForFloatingIp bool
+
+	// How long to wait, ClientOptions.TaskPollTimeout if not set.
+	Timeout time.Duration
+
+	// How long to wait after the first poll, 2 seconds if not set. The interval
+	// doubles after every poll, up to MaxPollInterval, 30 seconds if not set.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+}
+
+// How to reach a VM.
+type VmConnectionInfo struct {
+	ID string
+
+	// The connection WaitForNetwork waited for.
+	Network    string
+	IpAddress  string
+	MacAddress string
+	Netmask    string
+
+	FloatingIp string
+
+	// All network connections of the VM.
+	Connections []VmNetworkConnection
+}
+
+// Returns the floating IP if there is one, the IP address otherwise.
+func (info *VmConnectionInfo) Address() string {
+	if info.FloatingIp != "" {
+		return info.FloatingIp
+	}
+	return info.IpAddress
+}
+
+// Returned by WaitForNetwork when no IP address shows up in time.
+type VmNetworkTimeoutError struct {
+	ID      string
+	Network string
+}
+
+// Implement Go error interface for VmNetworkTimeoutError.
+func (e VmNetworkTimeoutError) Error() string {
+	network := "any network"
+	if e.Network != "" {
+		network = fmt.Sprintf("network '%s'", e.Network)
+	}
+	return fmt.Sprintf("photon: Timed out waiting for VM '%s' to get an IP address on %s", e.ID, network)
+}
+
+// Gets the network connections of a VM, waiting for the GET_NETWORKS task.
+func (api *VmAPI) GetNetworkConnections(id string) (connections []VmNetworkConnection, err error) {
+	return api.getNetworkConnections(id, api.client.options.TaskPollTimeout)
+}
+
+// Gets the network connections of a VM, waiting no longer than timeout for
+// the GET_NETWORKS task.
+func (api *VmAPI) getNetworkConnections(id string, timeout time.Duration) (connections []VmNetworkConnection, err error) {
+	task, err := api.GetNetworks(id)
+	if err != nil {
+		return
+	}
+	task, err = api.client.Tasks.WaitTimeout(task.ID, timeout)
+	if err != nil {
+		return
+	}
+
+	// The resource properties are decoded generically, so convert them back.
+	data, err := json.Marshal(task.ResourceProperties)
+	if err != nil {
+		return
+	}
+	networks := &VmNetworks{}
+	err = json.Unmarshal(data, networks)
+	if err != nil {
+		return
+	}
+	return networks.NetworkConnections, nil
+}
+
+// Waits until a started VM reports an IP address on the network given in the
+// options, or on any network, and returns how to connect to it. If options is
+// nil, the first IP address on any network is returned. Each poll starts a
+// GET_NETWORKS task, see VmNetworkWaitOptions.PollInterval.
+func (api *VmAPI) WaitForNetwork(id string, options *VmNetworkWaitOptions) (info *VmConnectionInfo, err error) {
+	if options == nil {
+		options = &VmNetworkWaitOptions{}
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = api.client.options.TaskPollTimeout
+	}
+
+	interval := options.PollInterval
+	if interval <= 0 {
+		interval = defaultNetworkPollInterval
+	}
+	maxInterval := options.MaxPollInterval
+	if maxInterval <= 0 {
+		maxInterval = defaultNetworkMaxPollInterval
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err = api.getConnectionInfo(id, options, deadline.Sub(time.Now()))
+		if _, ok := err.(TaskTimeoutError); ok {
+			return nil, VmNetworkTimeoutError{id, options.Network}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			return
+		}
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return nil, VmNetworkTimeoutError{id, options.Network}
+		}
+		if interval > remaining {
+			interval = remaining
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// Returns the connection info if the VM is reachable as the options ask for,
+// nil otherwise. The GET_NETWORKS task is waited for no longer than timeout.
+func (api *VmAPI) getConnectionInfo(id string, options *VmNetworkWaitOptions, timeout time.Duration) (info *VmConnectionInfo, err error) {
+	connections, err := api.getNetworkConnections(id, timeout)
+	if _, ok := err.(TaskError); ok {
+		// Fails while the guest tools are not up yet.
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, connection := range connections {
+		if connection.IpAddress == "" || (options.Network != "" && connection.Network != options.Network) {
+			continue
+		}
+		info = &VmConnectionInfo{
+			ID:          id,
+			Network:     connection.Network,
+			IpAddress:   connection.IpAddress,
+			MacAddress:  connection.MacAddress,
+			Netmask:     connection.Netmask,
+			Connections: connections,
+		}
+		break
+	}
+	if info == nil {
+		return
+	}
+
+	vm, err := api.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if options.WaitForFloatingIp && vm.FloatingIp == "" {
+		return nil, nil
+	}
+	info.FloatingIp = vm.FloatingIp
+	return
+}
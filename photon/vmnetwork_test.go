@@ -0,0 +1,127 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("VmNetwork", func() {
+	var (
+		server   *mocks.Server
+		client   *Client
+		networks []VmNetworkConnection
+	)
+
+	networksTask := func(connections ...VmNetworkConnection) *Task {
+		return &Task{ID: "networks-task", State: "COMPLETED", ResourceProperties: &VmNetworks{connections}}
+	}
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping VM network wait test on integration mode.")
+		}
+
+		server, client = mockServerClient()
+		networks = []VmNetworkConnection{
+			{Network: "subnet1", MacAddress: "00:50:56:00:00:01", IsConnected: "CONNECTED"},
+			{Network: "subnet2", MacAddress: "00:50:56:00:00:02", IpAddress: "10.0.0.5", Netmask: "255.255.255.0"},
+		}
+		server.SetResponseJsonForPath(rootUrl+"/vms/vm1", 200, &VM{ID: "vm1", State: VmStateStarted})
+		server.SetResponseJsonForPath(rootUrl+"/vms/vm1/subnets", 200, &Task{ID: "networks-task", State: "QUEUED"})
+		server.SetResponseJsonForPath(rootUrl+"/tasks/networks-task", 200, networksTask(networks...))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("gets the network connections", func() {
+		connections, err := client.VMs.GetNetworkConnections("vm1")
+		Expect(err).Should(BeNil())
+		Expect(connections).Should(Equal(networks))
+	})
+
+	It("waits for an IP address on any network", func() {
+		server.SetResponsesJsonForPath(rootUrl+"/tasks/networks-task", 200,
+			networksTask(), networksTask(), networksTask(networks...))
+		info, err := client.VMs.WaitForNetwork("vm1", &VmNetworkWaitOptions{PollInterval: time.Millisecond})
+		Expect(err).Should(BeNil())
+		Expect(info).Should(Equal(&VmConnectionInfo{
+			ID:          "vm1",
+			Network:     "subnet2",
+			IpAddress:   "10.0.0.5",
+			MacAddress:  "00:50:56:00:00:02",
+			Netmask:     "255.255.255.0",
+			Connections: networks,
+		}))
+		Expect(info.Address()).Should(Equal("10.0.0.5"))
+		Expect(server.RequestsFor("GET", rootUrl+"/vms/vm1/subnets")).Should(HaveLen(3))
+	})
+
+	It("waits for an IP address on the given network", func() {
+		_, err := client.VMs.WaitForNetwork("vm1",
+			&VmNetworkWaitOptions{Network: "subnet1", Timeout: 20 * time.Millisecond})
+		Expect(err).Should(Equal(VmNetworkTimeoutError{"vm1", "subnet1"}))
+
+		networks[0].IpAddress = "192.168.0.7"
+		server.SetResponseJsonForPath(rootUrl+"/tasks/networks-task", 200, networksTask(networks...))
+		info, err := client.VMs.WaitForNetwork("vm1", &VmNetworkWaitOptions{Network: "subnet1"})
+		Expect(err).Should(BeNil())
+		Expect(info.IpAddress).Should(Equal("192.168.0.7"))
+	})
+
+	It("picks up the floating IP", func() {
+		_, err := client.VMs.WaitForNetwork("vm1",
+			&VmNetworkWaitOptions{WaitForFloatingIp: true, Timeout: 20 * time.Millisecond})
+		Expect(err).Should(BeAssignableToTypeOf(VmNetworkTimeoutError{}))
+
+		server.SetResponseJsonForPath(rootUrl+"/vms/vm1", 200, &VM{ID: "vm1", State: VmStateStarted, FloatingIp: "172.16.0.9"})
+		info, err := client.VMs.WaitForNetwork("vm1", &VmNetworkWaitOptions{WaitForFloatingIp: true})
+		Expect(err).Should(BeNil())
+		Expect(info.FloatingIp).Should(Equal("172.16.0.9"))
+		Expect(info.Address()).Should(Equal("172.16.0.9"))
+	})
+
+	It("waits for the networks task no longer than the timeout", func() {
+		server.SetResponseJsonForPath(rootUrl+"/tasks/networks-task", 200, &Task{ID: "networks-task", State: "QUEUED"})
+		start := time.Now()
+		_, err := client.VMs.WaitForNetwork("vm1", &VmNetworkWaitOptions{Timeout: 50 * time.Millisecond})
+		Expect(err).Should(Equal(VmNetworkTimeoutError{"vm1", ""}))
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+
+	It("polls slowly and backs off", func() {
+		server.SetResponseJsonForPath(rootUrl+"/tasks/networks-task", 200, networksTask())
+
+		// The default interval is longer than the timeout, so the wait only
+		// polls once more when the time is up.
+		_, err := client.VMs.WaitForNetwork("vm1", &VmNetworkWaitOptions{Timeout: 50 * time.Millisecond})
+		Expect(err).Should(BeAssignableToTypeOf(VmNetworkTimeoutError{}))
+		Expect(server.RequestsFor("GET", rootUrl+"/vms/vm1/subnets")).Should(HaveLen(2))
+
+		// Polls after 20, 60, 140 and 150 milliseconds rather than every 20.
+		server.Close()
+		server, client = mockServerClient()
+		server.SetResponseJsonForPath(rootUrl+"/vms/vm1/subnets", 200, &Task{ID: "networks-task", State: "QUEUED"})
+		server.SetResponseJsonForPath(rootUrl+"/tasks/networks-task", 200, networksTask())
+		_, err = client.VMs.WaitForNetwork("vm1", &VmNetworkWaitOptions{
+			Timeout:         150 * time.Millisecond,
+			PollInterval:    20 * time.Millisecond,
+			MaxPollInterval: time.Second,
+		})
+		Expect(err).Should(BeAssignableToTypeOf(VmNetworkTimeoutError{}))
+		Expect(len(server.RequestsFor("GET", rootUrl+"/vms/vm1/subnets"))).Should(BeNumerically("<=", 5))
+	})
+})
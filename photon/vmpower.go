@@ -0,0 +1,212 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"fmt"
+	"time"
+)
+
+// States of a VM, as in VM.State.
+const (
+	VmStateCreating  string = "CREATING"
+	VmStateStarted   string = "STARTED"
+	VmStateStopped   string = "STOPPED"
+	VmStateSuspended string = "SUSPENDED"
+	VmStateError     string = "ERROR"
+)
+
+// Ways GracefulStop stopped a VM.
+const (
+	VmStopAlreadyStopped string = "ALREADY_STOPPED"
+	VmStopGraceful       string = "GRACEFUL"
+	VmStopForced         string = "FORCED"
+)
+
+// Operation and argument of a VmOperation that shuts the guest OS down
+// instead of powering the VM off.
+const (
+	VmStopOperation        string = "STOP_VM"
+	VmGracefulStopArgument string = "graceful"
+)
+
+// Most power operations needed to reach any state, e.g. STOPPED to SUSPENDED
+// takes a start and a suspend. One more is allowed for a state change made by
+// someone else while converging.
+const maxPowerOperations int = 3
+
+// Returned by EnsurePowerState when the VM cannot be brought to the desired state.
+type VmPowerStateError struct {
+	ID           string
+	State        string
+	DesiredState string
+
+	// Error of the last operation tried, if any.
+	Cause error
+}
+
+// Implement Go error interface for VmPowerStateError.
+func (e VmPowerStateError) Error() string {
+	msg := fmt.Sprintf("photon: Cannot bring VM '%s' from state %s to %s", e.ID, e.State, e.DesiredState)
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+// Brings a VM to the desired state, VmStateStarted, VmStateStopped or
+// VmStateSuspended, and returns the VM once it is there. The operations
+// needed are run one after the other, waiting for each task. A VM already in
+// the desired state is left alone.
+func (api *VmAPI) EnsurePowerState(id string, desiredState string) (vm *VM, err error) {
+	switch desiredState {
+	case VmStateStarted, VmStateStopped, VmStateSuspended:
+	default:
+		return nil, fmt.Errorf("photon: Invalid desired VM state '%s'", desiredState)
+	}
+
+	vm, err = api.Get(id)
+	if err != nil {
+		return
+	}
+
+	var lastErr error
+	for i := 0; vm.State != desiredState; i++ {
+		operation := api.nextPowerOperation(vm.State, desiredState)
+		if operation == nil || i == maxPowerOperations {
+			return nil, VmPowerStateError{id, vm.State, desiredState, lastErr}
+		}
+
+		var task *Task
+		task, lastErr = operation(id)
+		if lastErr == nil {
+			_, lastErr = api.client.Tasks.Wait(task.ID)
+		}
+		// The operation may have been rejected because someone else changed
+		// the state meanwhile, so carry on from wherever the VM is now.
+		if lastErr != nil && !isStateConflict(lastErr) {
+			return nil, lastErr
+		}
+
+		vm, err = api.Get(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vm, nil
+}
+
+// Returns the operation that takes a VM in the given state one step closer to
+// the desired state, or nil if there is none.
+func (api *VmAPI) nextPowerOperation(state string, desiredState string) func(string) (*Task, error) {
+	switch state {
+	case VmStateStarted:
+		if desiredState == VmStateSuspended {
+			return api.Suspend
+		}
+		return api.Stop
+	case VmStateStopped:
+		return api.Start
+	case VmStateSuspended:
+		// Suspended VMs have to be resumed before they can be stopped.
+		return api.Resume
+	}
+	return nil
+}
+
+// Returns true for the errors the API reports when an operation does not apply
+// to the current state of the VM.
+func isStateConflict(err error) bool {
+	switch err := err.(type) {
+	case TaskError:
+		return true
+	case ApiError:
+		return err.HttpStatusCode < 500
+	}
+	return false
+}
+
+// Outcome of GracefulStop.
+type VmStopResult struct {
+	VM *VM
+
+	// VmStopAlreadyStopped, VmStopGraceful or VmStopForced.
+	Path string
+
+	// Why the guest shutdown was given up on when the VM was stopped by force;
+	// nil if the guest merely did not shut down in time.
+	GracefulError error
+}
+
+// Stops a VM by shutting its guest OS down, waiting up to timeout for the VM
+// to reach VmStateStopped. If the guest shutdown cannot be requested or does
+// not finish in time, the VM is powered off. Suspended VMs are resumed before.
+func (api *VmAPI) GracefulStop(id string, timeout time.Duration) (result *VmStopResult, err error) {
+	deadline := time.Now().Add(timeout)
+	vm, err := api.Get(id)
+	if err != nil {
+		return
+	}
+	if vm.State == VmStateSuspended {
+		vm, err = api.EnsurePowerState(id, VmStateStarted)
+		if err != nil {
+			return
+		}
+	}
+	if vm.State == VmStateStopped {
+		return &VmStopResult{VM: vm, Path: VmStopAlreadyStopped}, nil
+	}
+
+	result = &VmStopResult{Path: VmStopGraceful}
+	shutdownErr := api.requestGuestShutdown(id, deadline)
+	switch shutdownErr.(type) {
+	case nil:
+		for {
+			vm, err = api.Get(id)
+			if err != nil {
+				return nil, err
+			}
+			if vm.State == VmStateStopped {
+				result.VM = vm
+				return
+			}
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(api.client.options.TaskPollDelay)
+		}
+	case TaskTimeoutError:
+		// The guest is still shutting down at the deadline.
+	default:
+		result.GracefulError = shutdownErr
+	}
+
+	result.Path = VmStopForced
+	result.VM, err = api.EnsurePowerState(id, VmStateStopped)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Requests the guest shutdown and waits for its task, no longer than until the
+// deadline.
+func (api *VmAPI) requestGuestShutdown(id string, deadline time.Time) (err error) {
+	op := &VmOperation{
+		Operation: VmStopOperation,
+		Arguments: map[string]interface{}{VmGracefulStopArgument: true},
+	}
+	task, err := api.Operation(id, op)
+	if err != nil {
+		return
+	}
+	_, err = api.client.Tasks.WaitTimeout(task.ID, deadline.Sub(time.Now()))
+	return
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+// Reads the VM at path as stopped once it is powered off.
+type stoppingTransport struct {
+	server *mocks.Server
+	path   string
+}
+
+func (t *stoppingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == "POST" && r.URL.Path == t.path+"/stop" {
+		t.server.SetResponseJsonForMethodPath("GET", t.path, 200, &VM{ID: "vm1", Name: "vm1", State: VmStateStopped})
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+var _ = Describe("VmPower", func() {
+	var (
+		server *mocks.Server
+		client *Client
+	)
+
+	vmPath := rootUrl + "/vms/vm1"
+
+	// The states the VM reads as, one per read; the last one is repeated.
+	states := func(states ...string) {
+		vms := []interface{}{}
+		for _, state := range states {
+			vms = append(vms, &VM{ID: "vm1", Name: "vm1", State: state})
+		}
+		server.SetResponsesJsonForPath(vmPath, 200, vms...)
+	}
+
+	// Power operations and guest shutdowns requested, in order.
+	ops := func() (ops []string) {
+		for _, r := range server.RequestsFor("POST", vmPath+"/") {
+			ops = append(ops, strings.TrimPrefix(r.Path, vmPath+"/"))
+		}
+		return
+	}
+
+	// Makes the VM read as stopped once it is powered off.
+	stopOnPowerOff := func() {
+		client = NewTestClient(server.HttpServer.URL, &ClientOptions{TaskPollDelay: time.Millisecond},
+			&http.Client{Transport: &stoppingTransport{server, vmPath}})
+	}
+
+	rejectOp := func(op string, status int) {
+		server.SetResponseJsonForPath(vmPath+"/"+op, status, &ApiError{Code: "InvalidVmState", Message: "rejected"})
+	}
+
+	// Tasks complete as soon as they are read.
+	startServer := func() {
+		server, client = mockServerClient()
+		server.SetResponseJson(200, &Task{State: "COMPLETED"})
+		server.SetResponseJsonForPath(vmPath+"/", 200, &Task{ID: "operation-task", State: "QUEUED"})
+	}
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping VM power test on integration mode.")
+		}
+		startServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("converges from every state", func() {
+		cases := []struct {
+			states       []string
+			desiredState string
+			ops          []string
+		}{
+			{[]string{VmStateStarted}, VmStateStarted, nil},
+			{[]string{VmStateStarted, VmStateStopped}, VmStateStopped, []string{"stop"}},
+			{[]string{VmStateStarted, VmStateSuspended}, VmStateSuspended, []string{"suspend"}},
+			{[]string{VmStateStopped, VmStateStarted}, VmStateStarted, []string{"start"}},
+			{[]string{VmStateStopped, VmStateStarted, VmStateSuspended}, VmStateSuspended, []string{"start", "suspend"}},
+			{[]string{VmStateSuspended, VmStateStarted}, VmStateStarted, []string{"resume"}},
+			{[]string{VmStateSuspended, VmStateStarted, VmStateStopped}, VmStateStopped, []string{"resume", "stop"}},
+		}
+		for _, c := range cases {
+			server.Close()
+			startServer()
+			states(c.states...)
+
+			vm, err := client.VMs.EnsurePowerState("vm1", c.desiredState)
+			Expect(err).Should(BeNil())
+			Expect(vm.State).Should(Equal(c.desiredState))
+			Expect(ops()).Should(Equal(c.ops), c.states[0]+" to "+c.desiredState)
+		}
+	})
+
+	It("carries on when the state was changed by someone else", func() {
+		states(VmStateSuspended, VmStateStopped, VmStateStarted)
+		rejectOp("resume", 400)
+		vm, err := client.VMs.EnsurePowerState("vm1", VmStateStarted)
+		Expect(err).Should(BeNil())
+		Expect(vm.State).Should(Equal(VmStateStarted))
+		Expect(ops()).Should(Equal([]string{"resume", "start"}))
+	})
+
+	It("gives up when the operation keeps failing", func() {
+		states(VmStateStopped)
+		rejectOp("start", 400)
+		_, err := client.VMs.EnsurePowerState("vm1", VmStateStarted)
+		powerErr, ok := err.(VmPowerStateError)
+		Expect(ok).Should(BeTrue())
+		Expect(powerErr.State).Should(Equal(VmStateStopped))
+		Expect(powerErr.Cause).Should(BeAssignableToTypeOf(ApiError{}))
+		Expect(ops()).Should(HaveLen(maxPowerOperations))
+	})
+
+	It("fails for VMs in other states", func() {
+		states(VmStateError)
+		_, err := client.VMs.EnsurePowerState("vm1", VmStateStarted)
+		Expect(err).Should(Equal(VmPowerStateError{"vm1", VmStateError, VmStateStarted, nil}))
+		Expect(ops()).Should(BeEmpty())
+	})
+
+	It("returns server errors", func() {
+		states(VmStateStopped)
+		rejectOp("start", 500)
+		_, err := client.VMs.EnsurePowerState("vm1", VmStateStarted)
+		Expect(err).Should(BeAssignableToTypeOf(ApiError{}))
+		Expect(ops()).Should(HaveLen(1))
+	})
+
+	It("rejects invalid desired states", func() {
+		states(VmStateStopped)
+		_, err := client.VMs.EnsurePowerState("vm1", VmStateError)
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	Describe("GracefulStop", func() {
+		It("shuts the guest down", func() {
+			states(VmStateStarted, VmStateStarted, VmStateStarted, VmStateStopped)
+			result, err := client.VMs.GracefulStop("vm1", time.Minute)
+			Expect(err).Should(BeNil())
+			Expect(result.Path).Should(Equal(VmStopGraceful))
+			Expect(result.VM.State).Should(Equal(VmStateStopped))
+			Expect(ops()).Should(Equal([]string{"operations"}))
+
+			var op VmOperation
+			Expect(json.Unmarshal([]byte(server.RequestsFor("POST", vmPath+"/operations")[0].Body), &op)).Should(Succeed())
+			Expect(op).Should(Equal(VmOperation{
+				Operation: VmStopOperation,
+				Arguments: map[string]interface{}{VmGracefulStopArgument: true},
+			}))
+		})
+
+		It("powers off when the guest does not shut down in time", func() {
+			stopOnPowerOff()
+			states(VmStateStarted)
+			result, err := client.VMs.GracefulStop("vm1", 20*time.Millisecond)
+			Expect(err).Should(BeNil())
+			Expect(result.Path).Should(Equal(VmStopForced))
+			Expect(result.GracefulError).Should(BeNil())
+			Expect(result.VM.State).Should(Equal(VmStateStopped))
+			Expect(ops()).Should(Equal([]string{"operations", "stop"}))
+		})
+
+		It("powers off when the guest shutdown task does not finish in time", func() {
+			stopOnPowerOff()
+			states(VmStateStarted)
+			server.SetResponseJsonForPath(vmPath+"/operations", 200, &Task{ID: "shutdown-task", State: "QUEUED"})
+			server.SetResponseJsonForPath(rootUrl+"/tasks/shutdown-task", 200, &Task{ID: "shutdown-task", State: "QUEUED"})
+			start := time.Now()
+			result, err := client.VMs.GracefulStop("vm1", 50*time.Millisecond)
+			Expect(err).Should(BeNil())
+			Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+			Expect(result.Path).Should(Equal(VmStopForced))
+			Expect(result.GracefulError).Should(BeNil())
+			Expect(result.VM.State).Should(Equal(VmStateStopped))
+			Expect(ops()).Should(Equal([]string{"operations", "stop"}))
+		})
+
+		It("powers off when the guest shutdown is rejected", func() {
+			stopOnPowerOff()
+			states(VmStateStarted)
+			rejectOp("operations", 400)
+			result, err := client.VMs.GracefulStop("vm1", time.Minute)
+			Expect(err).Should(BeNil())
+			Expect(result.Path).Should(Equal(VmStopForced))
+			Expect(result.GracefulError).Should(BeAssignableToTypeOf(ApiError{}))
+			Expect(ops()).Should(Equal([]string{"operations", "stop"}))
+		})
+
+		It("resumes suspended VMs first", func() {
+			states(VmStateSuspended, VmStateSuspended, VmStateStarted, VmStateStopped)
+			result, err := client.VMs.GracefulStop("vm1", time.Minute)
+			Expect(err).Should(BeNil())
+			Expect(result.Path).Should(Equal(VmStopGraceful))
+			Expect(ops()).Should(Equal([]string{"resume", "operations"}))
+		})
+
+		It("leaves stopped VMs alone", func() {
+			states(VmStateStopped)
+			result, err := client.VMs.GracefulStop("vm1", time.Minute)
+			Expect(err).Should(BeNil())
+			Expect(result.Path).Should(Equal(VmStopAlreadyStopped))
+			Expect(ops()).Should(BeEmpty())
+		})
+	})
+})
@@ -203,6 +203,25 @@ func (api *VmAPI) Suspend(id string) (task *Task, err error) {
 	return
 }
 
+// Runs a power operation such as STOP_VM with arguments on a VM.
+func (api *VmAPI) Operation(id string, op *VmOperation) (task *Task, err error) {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+	res, err := api.client.restClient.Post(
+		api.client.Endpoint+vmUrl+id+"/operations",
+		"application/json",
+		bytes.NewReader(body),
+		api.client.options.TokenOptions)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	task, err = getTask(getError(res))
+	return
+}
+
 func (api *VmAPI) SetMetadata(id string, metadata *VmMetadata) (task *Task, err error) {
 	body, err := json.Marshal(metadata)
 	if err != nil {
@@ -0,0 +1,311 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind of disks that live on after their VM.
+const PersistentDiskKind string = "persistent-disk"
+
+// Kind of the flavors of VMs; disk flavors are of the kind of their disks.
+const VmFlavorKind string = "vm"
+
+// State of flavors and subnets that can be used.
+const readyState string = "READY"
+
+// Returned by VmSpecBuilder.Build with every problem found in the spec.
+type VmSpecError struct {
+	Problems []string
+}
+
+// Implement Go error interface for VmSpecError.
+func (e VmSpecError) Error() string {
+	return "photon: Invalid VM spec: " + strings.Join(e.Problems, "; ")
+}
+
+// Builds a VmCreateSpec, checking it before it is submitted. Methods return
+// the builder, so calls can be chained:
+//
+//	spec, err := NewVmSpecBuilder("web-1").
+//	    Flavor("vm-small").
+//	    Image(imageID).
+//	    BootDisk("boot", "disk-ssd", 0).
+//	    EphemeralDisk("scratch", "disk-ssd", 20).
+//	    Subnets(subnetID).
+//	    Build(client)
+type VmSpecBuilder struct {
+	spec VmCreateSpec
+}
+
+// Starts building a spec for a VM of the given name.
+func NewVmSpecBuilder(name string) *VmSpecBuilder {
+	return &VmSpecBuilder{spec: VmCreateSpec{Name: name}}
+}
+
+// Sets the name of the VM flavor.
+func (b *VmSpecBuilder) Flavor(name string) *VmSpecBuilder {
+	b.spec.Flavor = name
+	return b
+}
+
+// Sets the ID of the image to create the VM from.
+func (b *VmSpecBuilder) Image(id string) *VmSpecBuilder {
+	b.spec.SourceImageID = id
+	return b
+}
+
+// Adds the boot disk, sized as the image if capacityGB is zero.
+func (b *VmSpecBuilder) BootDisk(name string, flavor string, capacityGB int) *VmSpecBuilder {
+	return b.Disk(AttachedDisk{Name: name, Kind: EphemeralDiskKind, Flavor: flavor, CapacityGB: capacityGB, BootDisk: true})
+}
+
+// Adds an ephemeral disk, one that is deleted along with the VM.
+func (b *VmSpecBuilder) EphemeralDisk(name string, flavor string, capacityGB int) *VmSpecBuilder {
+	return b.Disk(AttachedDisk{Name: name, Kind: EphemeralDiskKind, Flavor: flavor, CapacityGB: capacityGB})
+}
+
+// Adds a disk as it is. Only ephemeral disks can be part of a create spec;
+// persistent disks are attached once the VM exists.
+func (b *VmSpecBuilder) Disk(disk AttachedDisk) *VmSpecBuilder {
+	b.spec.AttachedDisks = append(b.spec.AttachedDisks, disk)
+	return b
+}
+
+// Adds the IDs of subnets to connect the VM to.
+func (b *VmSpecBuilder) Subnets(ids ...string) *VmSpecBuilder {
+	b.spec.Subnets = append(b.spec.Subnets, ids...)
+	return b
+}
+
+// Adds tags to the VM.
+func (b *VmSpecBuilder) Tags(tags ...string) *VmSpecBuilder {
+	b.spec.Tags = append(b.spec.Tags, tags...)
+	return b
+}
+
+// Adds an affinity, e.g. of kind "host" or "disk".
+func (b *VmSpecBuilder) Affinity(kind string, id string) *VmSpecBuilder {
+	b.spec.Affinities = append(b.spec.Affinities, LocalitySpec{Kind: kind, ID: id})
+	return b
+}
+
+// Sets a variable of the environment passed to the VM.
+func (b *VmSpecBuilder) Environment(key string, value string) *VmSpecBuilder {
+	if b.spec.Environment == nil {
+		b.spec.Environment = map[string]string{}
+	}
+	b.spec.Environment[key] = value
+	return b
+}
+
+// Returns the spec as built so far, without checking it.
+func (b *VmSpecBuilder) Spec() *VmCreateSpec {
+	spec := b.spec
+	spec.AttachedDisks = append([]AttachedDisk{}, b.spec.AttachedDisks...)
+	spec.Subnets = append([]string(nil), b.spec.Subnets...)
+	spec.Tags = append([]string(nil), b.spec.Tags...)
+	spec.Affinities = append([]LocalitySpec(nil), b.spec.Affinities...)
+	if b.spec.Environment != nil {
+		spec.Environment = map[string]string{}
+		for key, value := range b.spec.Environment {
+			spec.Environment[key] = value
+		}
+	}
+	return &spec
+}
+
+// Checks the spec on its own, without looking anything up.
+func (b *VmSpecBuilder) Check() error {
+	return problemsError(b.check())
+}
+
+// Checks the spec and that its flavors, image and subnets exist and can be
+// used, then returns it. All problems found are returned in a VmSpecError;
+// other errors are those of the API calls.
+func (b *VmSpecBuilder) Build(client *Client) (spec *VmCreateSpec, err error) {
+	problems := b.check()
+
+	flavorProblems, err := b.checkFlavors(client)
+	if err != nil {
+		return
+	}
+	problems = append(problems, flavorProblems...)
+
+	imageProblems, err := b.checkImage(client)
+	if err != nil {
+		return
+	}
+	problems = append(problems, imageProblems...)
+
+	subnetProblems, err := b.checkSubnets(client)
+	if err != nil {
+		return
+	}
+	problems = append(problems, subnetProblems...)
+
+	err = problemsError(problems)
+	if err != nil {
+		return
+	}
+	return b.Spec(), nil
+}
+
+func problemsError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return VmSpecError{problems}
+}
+
+func (b *VmSpecBuilder) check() (problems []string) {
+	spec := &b.spec
+	if spec.Name == "" {
+		problems = append(problems, "VM has no name")
+	}
+	if spec.Flavor == "" {
+		problems = append(problems, "VM has no flavor")
+	}
+	if spec.SourceImageID == "" {
+		problems = append(problems, "VM has no image")
+	}
+
+	bootDisks := 0
+	names := map[string]bool{}
+	for idx, disk := range spec.AttachedDisks {
+		label := fmt.Sprintf("disk %d", idx+1)
+		if disk.Name == "" {
+			problems = append(problems, label+" has no name")
+		} else {
+			label = fmt.Sprintf("disk '%s'", disk.Name)
+			if names[disk.Name] {
+				problems = append(problems, label+" is added more than once")
+			}
+			names[disk.Name] = true
+		}
+
+		// Persistent disks are created on their own and attached to the VM
+		// once it exists, so a create spec only takes ephemeral ones.
+		if disk.Kind == PersistentDiskKind {
+			problems = append(problems, fmt.Sprintf("%s is a %s, attach it after creating the VM",
+				label, PersistentDiskKind))
+		} else if disk.Kind != EphemeralDiskKind {
+			problems = append(problems, fmt.Sprintf("%s has kind '%s', not %s", label, disk.Kind, EphemeralDiskKind))
+		}
+		if disk.Flavor == "" {
+			problems = append(problems, label+" has no flavor")
+		}
+		if disk.CapacityGB < 0 {
+			problems = append(problems, label+" has a negative capacity")
+		}
+		if disk.BootDisk {
+			bootDisks++
+		} else if disk.CapacityGB == 0 {
+			problems = append(problems, label+" has no capacity")
+		}
+	}
+	if bootDisks != 1 {
+		problems = append(problems, fmt.Sprintf("VM has %d boot disks, needs exactly one", bootDisks))
+	}
+	return
+}
+
+// Flavors by kind and name.
+type flavorIndex map[string]map[string]*Flavor
+
+func getFlavorIndex(client *Client) (index flavorIndex, err error) {
+	flavors, err := client.Flavors.GetAll(nil)
+	if err != nil {
+		return
+	}
+	index = flavorIndex{}
+	for idx := range flavors.Items {
+		flavor := &flavors.Items[idx]
+		if index[flavor.Kind] == nil {
+			index[flavor.Kind] = map[string]*Flavor{}
+		}
+		index[flavor.Kind][flavor.Name] = flavor
+	}
+	return
+}
+
+func (b *VmSpecBuilder) checkFlavors(client *Client) (problems []string, err error) {
+	byKind, err := getFlavorIndex(client)
+	if err != nil {
+		return
+	}
+
+	checkFlavor := func(label string, kind string, name string) {
+		if name == "" {
+			return
+		}
+		flavor := byKind[kind][name]
+		if flavor == nil {
+			problems = append(problems, fmt.Sprintf("%s flavor '%s' does not exist", label, name))
+		} else if flavor.State != "" && flavor.State != readyState {
+			problems = append(problems, fmt.Sprintf("%s flavor '%s' is %s", label, name, flavor.State))
+		}
+	}
+	checkFlavor("VM", VmFlavorKind, b.spec.Flavor)
+	for _, disk := range b.spec.AttachedDisks {
+		if disk.Kind == EphemeralDiskKind {
+			checkFlavor(fmt.Sprintf("disk '%s' %s", disk.Name, disk.Kind), disk.Kind, disk.Flavor)
+		}
+	}
+	return
+}
+
+func (b *VmSpecBuilder) checkImage(client *Client) (problems []string, err error) {
+	if b.spec.SourceImageID == "" {
+		return
+	}
+	image, err := client.Images.Get(b.spec.SourceImageID)
+	if isNotFound(err) {
+		return []string{fmt.Sprintf("image '%s' does not exist", b.spec.SourceImageID)}, nil
+	}
+	if err != nil {
+		return
+	}
+	if image.State != ImageStateReady {
+		problems = append(problems, fmt.Sprintf("image '%s' is %s, not %s", image.ID, image.State, ImageStateReady))
+	}
+	return
+}
+
+func (b *VmSpecBuilder) checkSubnets(client *Client) (problems []string, err error) {
+	for _, id := range b.spec.Subnets {
+		var subnet *Subnet
+		subnet, err = client.Subnets.Get(id)
+		if isNotFound(err) {
+			problems = append(problems, fmt.Sprintf("subnet '%s' does not exist", id))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if subnet.State != "" && subnet.State != readyState {
+			problems = append(problems, fmt.Sprintf("subnet '%s' is %s", id, subnet.State))
+		}
+	}
+	return problems, nil
+}
+
+// A 404 comes as an HttpError if its body is not an ApiError, e.g. from a proxy.
+func isNotFound(err error) bool {
+	switch err := err.(type) {
+	case ApiError:
+		return err.HttpStatusCode == 404
+	case HttpError:
+		return err.StatusCode == 404
+	}
+	return false
+}
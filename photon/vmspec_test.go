@@ -0,0 +1,152 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/photon-controller-go-sdk/photon/internal/mocks"
+)
+
+var _ = Describe("VmSpecBuilder", func() {
+	var (
+		server *mocks.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		if isIntegrationTest() {
+			Skip("Skipping VM spec builder test on integration mode.")
+		}
+		server, client = mockServerClient()
+		server.SetResponseJson(404, createMockApiError("NotFound", "Not found", 404))
+		server.SetResponseJsonForPath(rootUrl+"/flavors", 200, &FlavorList{Items: []Flavor{
+			{Name: "vm-small", Kind: VmFlavorKind, State: "READY"},
+			{Name: "vm-old", Kind: VmFlavorKind, State: "PENDING_DELETE"},
+			{Name: "disk-ssd", Kind: EphemeralDiskKind, State: "READY"},
+			{Name: "disk-ssd", Kind: PersistentDiskKind, State: "READY"},
+		}})
+		server.SetResponseJsonForPath(rootUrl+"/images/img-ready", 200, &Image{ID: "img-ready", State: ImageStateReady})
+		server.SetResponseJsonForPath(rootUrl+"/images/img-creating", 200, &Image{ID: "img-creating", State: ImageStateCreating})
+		server.SetResponseJsonForPath(rootUrl+"/subnets/subnet-1", 200, &Subnet{ID: "subnet-1", State: "READY"})
+		server.SetResponseJsonForPath(rootUrl+"/subnets/subnet-2", 200, &Subnet{ID: "subnet-2", State: "PENDING_DELETE"})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("builds a valid spec", func() {
+		spec, err := NewVmSpecBuilder("web-1").
+			Flavor("vm-small").
+			Image("img-ready").
+			BootDisk("boot", "disk-ssd", 0).
+			EphemeralDisk("scratch", "disk-ssd", 20).
+			Subnets("subnet-1").
+			Tags("tier:web").
+			Environment("ROLE", "web").
+			Build(client)
+		Expect(err).Should(BeNil())
+		Expect(spec).Should(Equal(&VmCreateSpec{
+			Name:          "web-1",
+			Flavor:        "vm-small",
+			SourceImageID: "img-ready",
+			AttachedDisks: []AttachedDisk{
+				{Name: "boot", Kind: EphemeralDiskKind, Flavor: "disk-ssd", BootDisk: true},
+				{Name: "scratch", Kind: EphemeralDiskKind, Flavor: "disk-ssd", CapacityGB: 20},
+			},
+			Subnets:     []string{"subnet-1"},
+			Tags:        []string{"tier:web"},
+			Environment: map[string]string{"ROLE": "web"},
+		}))
+	})
+
+	It("reports all problems of the spec itself at once", func() {
+		err := NewVmSpecBuilder("").
+			EphemeralDisk("data", "disk-ssd", 0).
+			Disk(AttachedDisk{Name: "data", Kind: "ephemeral", Flavor: "disk-ssd", CapacityGB: 10}).
+			Check()
+		Expect(err).Should(Equal(VmSpecError{[]string{
+			"VM has no name",
+			"VM has no flavor",
+			"VM has no image",
+			"disk 'data' has no capacity",
+			"disk 'data' is added more than once",
+			"disk 'data' has kind 'ephemeral', not ephemeral-disk",
+			"VM has 0 boot disks, needs exactly one",
+		}}))
+	})
+
+	It("rejects persistent disks", func() {
+		err := NewVmSpecBuilder("web-1").
+			Flavor("vm-small").
+			Image("img-ready").
+			Disk(AttachedDisk{Name: "boot", Kind: PersistentDiskKind, Flavor: "disk-ssd", BootDisk: true}).
+			Disk(AttachedDisk{Name: "data", Kind: PersistentDiskKind, Flavor: "disk-ssd", CapacityGB: 10}).
+			Check()
+		Expect(err).Should(Equal(VmSpecError{[]string{
+			"disk 'boot' is a persistent-disk, attach it after creating the VM",
+			"disk 'data' is a persistent-disk, attach it after creating the VM",
+		}}))
+	})
+
+	It("reports flavors, images and subnets that cannot be used", func() {
+		_, err := NewVmSpecBuilder("web-1").
+			Flavor("vm-old").
+			Image("img-creating").
+			BootDisk("boot", "disk-hdd", 0).
+			BootDisk("boot-2", "disk-ssd", 0).
+			Subnets("subnet-1", "subnet-2", "subnet-3").
+			Build(client)
+		Expect(err).Should(Equal(VmSpecError{[]string{
+			"VM has 2 boot disks, needs exactly one",
+			"VM flavor 'vm-old' is PENDING_DELETE",
+			"disk 'boot' ephemeral-disk flavor 'disk-hdd' does not exist",
+			"image 'img-creating' is CREATING, not READY",
+			"subnet 'subnet-2' is PENDING_DELETE",
+			"subnet 'subnet-3' does not exist",
+		}}))
+	})
+
+	It("reports a missing image", func() {
+		_, err := NewVmSpecBuilder("web-1").
+			Flavor("vm-small").
+			Image("img-gone").
+			BootDisk("boot", "disk-ssd", 0).
+			Build(client)
+		Expect(err).Should(Equal(VmSpecError{[]string{"image 'img-gone' does not exist"}}))
+	})
+
+	It("reports a missing image behind a proxy", func() {
+		server.SetResponseForPath(rootUrl+"/images/img-gone", 404, "<html>Not Found</html>")
+		_, err := NewVmSpecBuilder("web-1").
+			Flavor("vm-small").
+			Image("img-gone").
+			BootDisk("boot", "disk-ssd", 0).
+			Build(client)
+		Expect(err).Should(Equal(VmSpecError{[]string{"image 'img-gone' does not exist"}}))
+	})
+
+	It("returns errors other than missing entities as they are", func() {
+		server.Close()
+		_, err := NewVmSpecBuilder("web-1").Flavor("vm-small").Image("img-ready").Build(client)
+		Expect(err).ShouldNot(BeNil())
+		_, ok := err.(VmSpecError)
+		Expect(ok).Should(BeFalse())
+	})
+
+	It("does not share state between built specs", func() {
+		builder := NewVmSpecBuilder("web-1").Environment("ROLE", "web").Subnets("subnet-1")
+		spec := builder.Spec()
+		builder.Environment("ROLE", "db").Subnets("subnet-2")
+		Expect(spec.Environment).Should(Equal(map[string]string{"ROLE": "web"}))
+		Expect(spec.Subnets).Should(Equal([]string{"subnet-1"}))
+	})
+})
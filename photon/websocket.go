@@ -0,0 +1,251 @@
+// Copyright (c) 2017 VMware, Inc. All Rights Reserved.
+//
+// This product is licensed to you under the Apache License, Version 2.0 (the "License").
+// You may not use this product except in compliance with the License.
+//
+// This product may include a number of subcomponents with separate copyright notices and
+// license terms. Your use of these subcomponents is subject to the terms and conditions
+// of the subcomponent's license, as noted in the LICENSE file.
+
+package photon
+
+// A minimal WebSocket (RFC 6455) connection, enough to carry the binary
+// stream of a WebMKS console.
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xa
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Value of the Sec-WebSocket-Accept header for the given key.
+func wsAcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Reads and writes WebSocket frames over a connection. Data frames are read
+// as one stream and written as binary frames; control frames are handled
+// while reading.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// Frames sent by a client are masked, frames sent by a server are not.
+	client bool
+
+	writeLock sync.Mutex
+
+	// State of the data frame being read.
+	remaining int64
+	mask      []byte
+	maskPos   int
+}
+
+// Does the opening handshake as a client over an established connection.
+func dialWebSocket(conn net.Conn, host, path, protocol string) (ws *wsConn, err error) {
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	_, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Protocol: %s\r\n\r\n", path, host, key, protocol)
+	if err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("photon: WebSocket handshake with %s failed: %s", host, res.Status)
+	}
+	if res.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return nil, fmt.Errorf("photon: WebSocket handshake with %s failed: bad Sec-WebSocket-Accept", host)
+	}
+	return &wsConn{conn: conn, reader: reader, client: true}, nil
+}
+
+// Reads the payload of data frames, answering pings and close frames on the way.
+func (c *wsConn) Read(p []byte) (n int, err error) {
+	for c.remaining == 0 {
+		var opcode byte
+		var length int64
+		opcode, length, err = c.readHeader()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpContinuation, wsOpText, wsOpBinary:
+			c.remaining = length
+		default:
+			err = c.readControl(opcode, length)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err = c.reader.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= int64(n)
+	return
+}
+
+// Writes p as one binary frame.
+func (c *wsConn) Write(p []byte) (n int, err error) {
+	err = c.writeFrame(wsOpBinary, p)
+	if err != nil {
+		return
+	}
+	return len(p), nil
+}
+
+// Sends a close frame and closes the connection.
+func (c *wsConn) Close() error {
+	// Status 1000, normal closure.
+	c.writeFrame(wsOpClose, []byte{0x03, 0xe8})
+	return c.conn.Close()
+}
+
+func (c *wsConn) readHeader() (opcode byte, length int64, err error) {
+	header := make([]byte, 2)
+	_, err = io.ReadFull(c.reader, header)
+	if err != nil {
+		return
+	}
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length = int64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, extended)
+		length = int64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(c.reader, extended)
+		length = int64(binary.BigEndian.Uint64(extended))
+		if length < 0 {
+			err = errors.New("photon: WebSocket frame too large")
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	c.mask, c.maskPos = nil, 0
+	if masked {
+		c.mask = make([]byte, 4)
+		_, err = io.ReadFull(c.reader, c.mask)
+	}
+	return
+}
+
+func (c *wsConn) readControl(opcode byte, length int64) (err error) {
+	if length > 125 {
+		return errors.New("photon: WebSocket control frame too large")
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return
+	}
+	c.unmask(payload)
+
+	switch opcode {
+	case wsOpPing:
+		return c.writeFrame(wsOpPong, payload)
+	case wsOpClose:
+		// Echo the status code back, then report the end of the stream.
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.writeFrame(wsOpClose, payload)
+		return io.EOF
+	case wsOpPong:
+		return nil
+	}
+	return fmt.Errorf("photon: Unknown WebSocket opcode %d", opcode)
+}
+
+func (c *wsConn) unmask(p []byte) {
+	if c.mask == nil {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) (err error) {
+	frame := []byte{0x80 | opcode, 0}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame[1] = byte(length)
+	case length <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame[1] = 127
+		frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	if c.client {
+		frame[1] |= 0x80
+		mask := make([]byte, 4)
+		_, err = rand.Read(mask)
+		if err != nil {
+			return
+		}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err = c.conn.Write(frame)
+	return
+}